@@ -17,11 +17,14 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -30,14 +33,18 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	hardwaremanagementcontroller "github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/hardwaremanagement"
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/service"
 	//+kubebuilder:scaffold:imports
 
 	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
@@ -61,6 +68,37 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var maxConcurrentReconciles int
+	var inventoryAPIAddr string
+	var maxConfigMapSize int
+	var releaseCooldown time.Duration
+	var validateInventory bool
+	var strictInventory bool
+	var inventoryCacheTTL time.Duration
+	var resyncInterval time.Duration
+	var finalizerRestoreGrace time.Duration
+	var warmPool string
+	var warmPoolInterval time.Duration
+	var enableDebugHandlers bool
+	var pruneStaleAllocations bool
+	var staleAllocationInterval time.Duration
+	var inventoryMaintenanceInterval time.Duration
+	var credentialCheckInterval time.Duration
+	var nodeSortKeys string
+	var preferAdjacentNodes bool
+	var preferPoweredOnNodes bool
+	var maxConcurrentProvisions int
+	var conflictRetries int
+	var allocationDelay time.Duration
+	var allocationTimeout time.Duration
+	var historyLimit int
+	var historyMaxAge time.Duration
+	var repairBMCSecrets bool
+	var inventoryDebounce time.Duration
+	var summaryLogFile string
+	var configMapName string
+	var resourcesKey string
+	var allocationsKey string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -70,6 +108,81 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The maximum number of NodePools that can be reconciled in parallel")
+	flag.StringVar(&inventoryAPIAddr, "inventory-api-bind-address", "0",
+		"The address the inventory API endpoint binds to. Use \"0\" to disable it. "+
+			"Requests must provide the bearer token set in the INVENTORY_API_TOKEN env variable.")
+	flag.StringVar(&configMapName, "configmap-name", "nodelist",
+		"The name of the configmap holding the node inventory and allocations, so that separate plugin instances can "+
+			"manage separate inventories.")
+	flag.StringVar(&resourcesKey, "resources-key", "resources",
+		"The key of the node inventory data in the nodelist configmap.")
+	flag.StringVar(&allocationsKey, "allocations-key", "allocations",
+		"The key of the allocations data in the nodelist configmap.")
+	flag.IntVar(&maxConfigMapSize, "max-configmap-size", 0,
+		"The maximum data size, in bytes, of the nodelist configmap. Use 0 for the default, just under the 1MiB limit.")
+	flag.DurationVar(&releaseCooldown, "release-cooldown", 0,
+		"The period after a node is released during which it is not allocated again, such as \"5m\".")
+	flag.BoolVar(&validateInventory, "validate-inventory", false,
+		"If set, the nodelist configmap is checked against the bundled schema whenever it is read")
+	flag.BoolVar(&strictInventory, "strict-inventory", false,
+		"If set, the node inventory is rejected whenever it is read if it has unknown fields, such as a misspelled field name")
+	flag.DurationVar(&inventoryCacheTTL, "inventory-cache-ttl", 0,
+		"The time for which the nodelist configmap is reused by read-only queries once read, such as \"2s\". "+
+			"Updates by the plugin invalidate it. Use 0 to read it on every query.")
+	flag.DurationVar(&resyncInterval, "resync-interval", 0,
+		"The interval at which provisioned NodePools are re-verified to catch drift, such as \"10m\". Use 0 to disable it.")
+	flag.DurationVar(&finalizerRestoreGrace, "finalizer-restore-grace", 0,
+		"The time for which the finalizer of a NodePool with allocated nodes may stay removed out-of-band before it is "+
+			"restored, such as \"1m\". Use 0 to restore it on the next reconcile.")
+	flag.StringVar(&warmPool, "warm-pool", "",
+		"The number of pre-provisioned nodes to keep ready for each hardware profile, such as \"profile-a=2,profile-b=1\".")
+	flag.DurationVar(&warmPoolInterval, "warm-pool-interval", 30*time.Second,
+		"The interval at which the warm pools are replenished.")
+	flag.BoolVar(&pruneStaleAllocations, "prune-stale-allocations", false,
+		"If set, allocated nodegroups with no corresponding nodegroup in any NodePool are released, rather than only flagged")
+	flag.DurationVar(&staleAllocationInterval, "stale-allocation-interval", time.Minute,
+		"The interval at which allocated nodegroups are checked for a corresponding nodegroup in a NodePool.")
+	flag.DurationVar(&inventoryMaintenanceInterval, "inventory-maintenance-interval", 30*time.Second,
+		"The interval at which lost allocations are recovered from the Node CRs, and the inventory status and free nodes "+
+			"metric are refreshed.")
+	flag.DurationVar(&credentialCheckInterval, "credential-check-interval", 0,
+		"The interval at which the bmc-secrets of the allocated nodes are checked against their BMCs by the credential "+
+			"verifier, if the service has one. Use 0 to disable the checks.")
+	flag.StringVar(&nodeSortKeys, "node-sort-keys", "",
+		"The keys by which ties between candidate nodes are broken, in order, such as \"rack,name\". "+
+			"Valid keys are rack, serial, hostname, and name. The node name is always the final key.")
+	flag.BoolVar(&preferAdjacentNodes, "prefer-adjacent-nodes", false,
+		"If set, the candidate nodes closest by name to those already allocated to a NodePool are preferred when it is "+
+			"scaled up, ahead of the node sort keys.")
+	flag.BoolVar(&preferPoweredOnNodes, "prefer-powered-on-nodes", false,
+		"If set, the candidate nodes marked as powered on in the inventory are preferred over those that are powered "+
+			"off, reducing the provisioning time.")
+	flag.IntVar(&maxConcurrentProvisions, "max-concurrent-provisions", 0,
+		"The maximum number of nodes provisioned at the same time across all NodePools. Use 0 for no limit.")
+	flag.IntVar(&conflictRetries, "conflict-retries", 4,
+		"The number of times an update of the allocations is retried when the nodelist configmap was modified concurrently.")
+	flag.DurationVar(&allocationDelay, "allocation-delay", 10*time.Second,
+		"The simulated time taken to provision a node after it is allocated, unless the node gives its own estimate. "+
+			"Use 0 to provision nodes immediately.")
+	flag.DurationVar(&allocationTimeout, "allocation-timeout", 30*time.Second,
+		"The time allowed for the apiserver operations that create the Node CR and bmc-secret of each allocated node, "+
+			"separately from the allocation delay. Use 0 for no timeout.")
+	flag.IntVar(&historyLimit, "history-limit", 0,
+		"The maximum number of entries kept in the allocation history of the nodelist configmap. Use 0 for no limit.")
+	flag.DurationVar(&historyMaxAge, "history-max-age", 0,
+		"The age after which entries are dropped from the allocation history of the nodelist configmap. "+
+			"Use 0 for no limit. The history is only recorded if this or --history-limit is set.")
+	flag.BoolVar(&repairBMCSecrets, "repair-bmc-secrets", false,
+		"If set, the bmc-secrets shared by nodes whose names collide are repaired on startup.")
+	flag.DurationVar(&inventoryDebounce, "inventory-debounce", 5*time.Second,
+		"The period over which changes to the node inventory are coalesced before the NodePools are reconciled again.")
+	flag.StringVar(&summaryLogFile, "summary-log-file", "",
+		"The file to which a JSON line summarizing each completed NodePool allocation and release is appended, such as "+
+			"for a sidecar to ship. Use \"-\" for standard output, or leave it empty to disable the summaries.")
+	flag.BoolVar(&enableDebugHandlers, "enable-debug-handlers", false,
+		"If set, the metrics server also serves the current allocations and capacity at "+service.DebugAllocationsPath)
 	opts := zap.Options{
 		Development: true,
 	}
@@ -111,13 +224,23 @@ func main() {
 		defaultNamespaces[ns] = cache.Config{}
 	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme: scheme,
-		Metrics: metricsserver.Options{
-			BindAddress:   metricsAddr,
-			SecureServing: secureMetrics,
-			TLSOpts:       tlsOpts,
+	// The debug handlers are registered once the HwMgrService is built, which requires the manager's client
+	metricsOpts := metricsserver.Options{
+		BindAddress:   metricsAddr,
+		SecureServing: secureMetrics,
+		TLSOpts:       tlsOpts,
+		ExtraHandlers: map[string]http.Handler{
+			hardwaremanagementcontroller.OpenMetricsPath: hardwaremanagementcontroller.NewOpenMetricsHandler(),
 		},
+	}
+	debugMux := http.NewServeMux()
+	if enableDebugHandlers {
+		metricsOpts.ExtraHandlers["/debug/"] = debugMux
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsOpts,
 		WebhookServer:          webhookServer,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
@@ -144,16 +267,168 @@ func main() {
 		os.Exit(1)
 	}
 
+	var summaryLogger *slog.Logger
+	switch summaryLogFile {
+	case "":
+	case "-":
+		summaryLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	default:
+		file, err := os.OpenFile(summaryLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			setupLog.Error(err, "unable to open --summary-log-file")
+			os.Exit(1)
+		}
+		summaryLogger = slog.New(slog.NewJSONHandler(file, nil))
+	}
+
+	sortKeys, err := service.ParseNodeSortKeys(nodeSortKeys)
+	if err != nil {
+		setupLog.Error(err, "invalid --node-sort-keys")
+		os.Exit(1)
+	}
+
+	// The nodelist configmap is read afresh through an uncached client before each update of it, while the read-only
+	// queries are served from the manager's cache
+	uncachedClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		setupLog.Error(err, "unable to create uncached client")
+		os.Exit(1)
+	}
+
+	recorder := mgr.GetEventRecorderFor("oran-hwmgr-plugin-test")
+	hwmgr, err := service.NewHwMgrService().
+		SetClient(uncachedClient).
+		SetReadClient(mgr.GetClient()).
+		SetLogger(slog.With("controller", "NodePool")).
+		SetConfigMapName(configMapName).
+		SetResourcesKey(resourcesKey).
+		SetAllocationsKey(allocationsKey).
+		SetMaxConfigMapSize(maxConfigMapSize).
+		SetReleaseCooldown(releaseCooldown).
+		SetValidateInventory(validateInventory).
+		SetStrictInventory(strictInventory).
+		SetInventoryCacheTTL(inventoryCacheTTL).
+		SetEventRecorder(recorder).
+		SetNodeSortKeys(sortKeys).
+		SetPreferAdjacentNodes(preferAdjacentNodes).
+		SetPreferPoweredOnNodes(preferPoweredOnNodes).
+		SetMaxConcurrentProvisions(maxConcurrentProvisions).
+		SetConflictRetries(conflictRetries).
+		SetAllocationDelay(allocationDelay).
+		SetAllocationTimeout(allocationTimeout).
+		SetHistoryLimit(historyLimit).
+		SetHistoryMaxAge(historyMaxAge).
+		Build(context.Background())
+	if err != nil {
+		setupLog.Error(err, "unable to create HwMgrService")
+		os.Exit(1)
+	}
+	debugMux.Handle(service.DebugAllocationsPath, service.NewDebugHandler(hwmgr))
+
 	if err = (&hardwaremanagementcontroller.NodePoolReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 		Logger: slog.With("controller", "NodePool"),
+		HwMgr:  hwmgr,
+
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		ResyncInterval:          resyncInterval,
+		InventoryDebounce:       inventoryDebounce,
+		Recorder:                recorder,
+		SummaryLogger:           summaryLogger,
+		FinalizerRestoreGrace:   finalizerRestoreGrace,
+		Clock:                   clock.RealClock{},
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "NodePool")
 		os.Exit(1)
 	}
+	if err = (&hardwaremanagementcontroller.NodeReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+		Logger: slog.With("controller", "Node"),
+		HwMgr:  hwmgr,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Node")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
+	if inventoryAPIAddr != "0" {
+		token := os.Getenv("INVENTORY_API_TOKEN")
+		if token == "" {
+			setupLog.Error(fmt.Errorf("unable to find env variable INVENTORY_API_TOKEN"),
+				"the inventory API requires a bearer token")
+			os.Exit(1)
+		}
+
+		if err := mgr.Add(&service.InventoryAPIServer{
+			Addr:    inventoryAPIAddr,
+			Handler: service.NewInventoryAPIHandler(hwmgr, token),
+		}); err != nil {
+			setupLog.Error(err, "unable to set up inventory API")
+			os.Exit(1)
+		}
+	}
+
+	if warmPool != "" {
+		sizes, err := service.ParseWarmPoolSizes(warmPool)
+		if err != nil {
+			setupLog.Error(err, "invalid --warm-pool")
+			os.Exit(1)
+		}
+
+		if err := mgr.Add(&service.WarmPoolManager{
+			HwMgr:    hwmgr,
+			Sizes:    sizes,
+			Interval: warmPoolInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up warm pool manager")
+			os.Exit(1)
+		}
+	}
+
+	if err := mgr.Add(&service.InventoryMaintenanceManager{
+		HwMgr:    hwmgr,
+		Interval: inventoryMaintenanceInterval,
+	}); err != nil {
+		setupLog.Error(err, "unable to set up inventory maintenance manager")
+		os.Exit(1)
+	}
+
+	if err := mgr.Add(&service.StaleAllocationManager{
+		HwMgr:    hwmgr,
+		Prune:    pruneStaleAllocations,
+		Interval: staleAllocationInterval,
+	}); err != nil {
+		setupLog.Error(err, "unable to set up stale allocation manager")
+		os.Exit(1)
+	}
+
+	if credentialCheckInterval > 0 {
+		if err := mgr.Add(&service.CredentialCheckManager{
+			HwMgr:    hwmgr,
+			Interval: credentialCheckInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to set up credential check manager")
+			os.Exit(1)
+		}
+	}
+
+	if repairBMCSecrets {
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			repaired, err := hwmgr.RepairBMCSecrets(ctx)
+			if err != nil {
+				setupLog.Error(err, "unable to repair bmc-secrets")
+				return nil
+			}
+			setupLog.Info("repaired bmc-secrets", "nodes", repaired)
+			return nil
+		})); err != nil {
+			setupLog.Error(err, "unable to set up bmc-secret repair")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -0,0 +1,144 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hardwaremanagement
+
+import (
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+// OpenMetricsPath is the path on the metrics server at which the metrics are served in the OpenMetrics format, which
+// is needed to expose exemplars. The builtin /metrics endpoint only serves the Prometheus text format.
+const OpenMetricsPath = "/metrics/openmetrics"
+
+// reconcileStalenessCollector reports, for each NodePool, the time elapsed since its last successful reconcile, so
+// that operators can alert on pools that have not been reconciled recently
+type reconcileStalenessCollector struct {
+	clock         clock.PassiveClock
+	desc          *prometheus.Desc
+	lock          sync.Mutex
+	lastReconcile map[types.NamespacedName]time.Time
+}
+
+var reconcileStaleness = &reconcileStalenessCollector{
+	clock: clock.RealClock{},
+	desc: prometheus.NewDesc(
+		"oran_hwmgr_nodepool_reconcile_staleness_seconds",
+		"Seconds since the last successful reconcile of the NodePool",
+		[]string{"namespace", "name"},
+		nil),
+	lastReconcile: make(map[types.NamespacedName]time.Time),
+}
+
+// allocationReconciles reports the number of processing passes taken by NodePools to be fully allocated and provisioned
+var allocationReconciles = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "oran_hwmgr_nodepool_allocation_reconciles",
+	Help:    "Number of reconciles taken by a NodePool to be fully allocated and provisioned",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 8),
+})
+
+// allocationDuration reports the time taken by NodePools from creation to be fully allocated and provisioned, with an
+// exemplar linking to the trace of the request where the NodePool carries a trace ID
+var allocationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "oran_hwmgr_nodepool_allocation_duration_seconds",
+	Help:    "Seconds taken by a NodePool from creation to be fully allocated and provisioned",
+	Buckets: prometheus.ExponentialBuckets(10, 2, 8),
+})
+
+// traceIDPattern matches a W3C trace context trace ID
+var traceIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileStaleness, allocationReconciles, allocationDuration)
+}
+
+// observeAllocationDuration records the allocation duration of a NodePool, attaching its trace ID as an exemplar if it
+// has a valid one
+func observeAllocationDuration(nodepool *hwmgmtv1alpha1.NodePool, duration time.Duration) {
+	if traceID := nodepool.Annotations[utils.TraceIDAnnotation]; traceIDPattern.MatchString(traceID) {
+		allocationDuration.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(),
+			prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	allocationDuration.Observe(duration.Seconds())
+}
+
+// NewOpenMetricsHandler returns a handler for the controller metrics that negotiates the OpenMetrics format, so that
+// exemplars are exposed to scrapers that request it
+func NewOpenMetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{
+		ErrorHandling:     promhttp.HTTPErrorOnError,
+		EnableOpenMetrics: true,
+	})
+}
+
+// setClock sets the clock against which the staleness is measured, which is that of the reconciler observing the
+// reconciles
+func (s *reconcileStalenessCollector) setClock(c clock.PassiveClock) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clock = c
+}
+
+// Observe records a successful reconcile of the specified NodePool
+func (s *reconcileStalenessCollector) Observe(key types.NamespacedName, when time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastReconcile[key] = when
+}
+
+// Forget stops reporting the specified NodePool, once it is deleted
+func (s *reconcileStalenessCollector) Forget(key types.NamespacedName) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.lastReconcile, key)
+}
+
+// Staleness returns the time since the last successful reconcile of the specified NodePool, if any
+func (s *reconcileStalenessCollector) Staleness(key types.NamespacedName) (time.Duration, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	when, exists := s.lastReconcile[key]
+	if !exists {
+		return 0, false
+	}
+	return s.clock.Since(when), true
+}
+
+func (s *reconcileStalenessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc
+}
+
+func (s *reconcileStalenessCollector) Collect(ch chan<- prometheus.Metric) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, when := range s.lastReconcile {
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue,
+			s.clock.Since(when).Seconds(), key.Namespace, key.Name)
+	}
+}
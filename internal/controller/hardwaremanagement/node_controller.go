@@ -0,0 +1,76 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hardwaremanagement
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/service"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+// NodeReconciler handles the deletion of Node CRs by anyone other than the plugin, releasing the deleted nodes
+type NodeReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Logger *slog.Logger
+	HwMgr  *service.HwMgrService
+}
+
+// Reconcile releases a Node that is being deleted while still holding the plugin finalizer
+func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	node := &hwmgmtv1alpha1.Node{}
+	if err := r.Client.Get(ctx, req.NamespacedName, node); err != nil {
+		if errors.IsNotFound(err) {
+			return doNotRequeue(), nil
+		}
+		return requeueWithError(fmt.Errorf("failed to get Node %s: %w", req.Name, err))
+	}
+
+	if node.GetDeletionTimestamp() == nil {
+		return doNotRequeue(), nil
+	}
+
+	err := r.HwMgr.HandleNodeDeletion(ctx, node)
+	if releasePending(err) {
+		r.Logger.InfoContext(ctx, "Waiting to release deleted Node", "name", node.Name, "reason", err.Error())
+		return requeueWithShortInterval(), nil
+	}
+	if err != nil {
+		return requeueWithError(fmt.Errorf("failed to handle deletion of Node %s: %w", node.Name, err))
+	}
+
+	return doNotRequeue(), nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := ctrl.NewControllerManagedBy(mgr).
+		For(&hwmgmtv1alpha1.Node{}).
+		Complete(r); err != nil {
+		return fmt.Errorf("failed to create controller: %w", err)
+	}
+
+	return nil
+}
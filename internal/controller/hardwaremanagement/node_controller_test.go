@@ -0,0 +1,126 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hardwaremanagement
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/service"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+var _ = Describe("Node Controller", func() {
+	Context("When an allocated Node CR is deleted externally", func() {
+		It("releases the node and lets the NodePool reallocate", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.Completed,
+				metav1.ConditionTrue,
+				"Created")
+
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+			node := newNode("node-a-0", "cloud-1", "master")
+			node.Finalizers = []string{service.NodeFinalizer}
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepool, node)
+			nodeReconciler := &NodeReconciler{Client: c, Scheme: r.Scheme, Logger: r.Logger, HwMgr: r.HwMgr}
+
+			Expect(c.Delete(ctx, node)).To(Succeed())
+			_, err := nodeReconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+				Name: "node-a-0", Namespace: testNamespace}})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, &hwmgmtv1alpha1.Node{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "nodelist", Namespace: testNamespace}, cm)).To(Succeed())
+			Expect(cm.Data["allocations"]).ToNot(ContainSubstring("node-a-0"))
+
+			// The provisioned NodePool is no longer fully allocated, so is returned to processing
+			Expect(r.mapNodeToNodePools(ctx, node)).To(HaveLen(1))
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithShortInterval()))
+			Expect(meta.IsStatusConditionTrue(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(hwmgmtv1alpha1.Provisioned))).To(BeFalse())
+
+			reconcileNodePool(ctx, r, nodepool)
+			Expect(r.HwMgr.GetAllocatedNodes(ctx, nodepool)).To(HaveLen(1))
+		})
+
+		It("deletes the bmc-secret of a node that is no longer allocated", func() {
+			ctx := context.Background()
+
+			// A release interrupted after updating the allocations leaves the Node CR and its bmc-secret behind
+			node := newNode("node-a-0", "cloud-1", "master")
+			node.Finalizers = []string{service.NodeFinalizer}
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name:        "node-a-0-bmc-secret",
+				Namespace:   testNamespace,
+				Annotations: map[string]string{utils.InventoryKeyLabel: "node-a-0"},
+			}}
+			r, c := newTestReconciler(newNodelistConfigMap(""), node, secret)
+			nodeReconciler := &NodeReconciler{Client: c, Scheme: r.Scheme, Logger: r.Logger, HwMgr: r.HwMgr}
+
+			Expect(c.Delete(ctx, node)).To(Succeed())
+			_, err := nodeReconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+				Name: "node-a-0", Namespace: testNamespace}})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, &hwmgmtv1alpha1.Node{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+			err = c.Get(ctx, types.NamespacedName{Name: "node-a-0-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("ignores Node CRs that are not being deleted", func() {
+			ctx := context.Background()
+
+			node := newNode("node-a-0", "cloud-1", "master")
+			node.Finalizers = []string{service.NodeFinalizer}
+			r, c := newTestReconciler(newNodelistConfigMap(""), node)
+			nodeReconciler := &NodeReconciler{Client: c, Scheme: r.Scheme, Logger: r.Logger, HwMgr: r.HwMgr}
+
+			_, err := nodeReconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+				Name: "node-a-0", Namespace: testNamespace}})
+			Expect(err).ToNot(HaveOccurred())
+
+			updated := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, updated)).To(Succeed())
+			Expect(updated.Finalizers).To(ContainElement(service.NodeFinalizer))
+		})
+	})
+})
@@ -18,18 +18,33 @@ package hardwaremanagement
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
 	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/service"
@@ -38,12 +53,71 @@ import (
 
 const pluginFinalizer = "oran-hwmgr-plugin-test.oran.openshift.io/nodepool-finalizer"
 
+// eventSource is the component name under which events are recorded
+const eventSource = "oran-hwmgr-plugin-test"
+
+// Reasons for the events recorded on NodePool CRs as they move through their lifecycle. The allocation of each node is
+// recorded by the HwMgrService.
+const (
+	EventReasonProcessing            = "Processing"
+	EventReasonProvisioned           = "Provisioned"
+	EventReasonValidationFailed      = "ValidationFailed"
+	EventReasonInsufficientResources = "InsufficientResources"
+	EventReasonDuplicateCloudID      = "DuplicateCloudID"
+	EventReasonFinalizerRestored     = "FinalizerRestored"
+)
+
+// defaultInventoryDebounce is the default period over which changes to the node inventory are coalesced before the
+// NodePools are re-enqueued
+const defaultInventoryDebounce = 5 * time.Second
+
+// finalizerRequeueInterval is the interval at which a deleted NodePool is checked for the deletion of its Node CRs
+const finalizerRequeueInterval = 5 * time.Second
+
 // NodePoolReconciler reconciles a NodePool object
 type NodePoolReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	Logger *slog.Logger
-	hwmgr  *service.HwMgrService
+
+	// HwMgr is the service used to manage the node inventory. If not set, one is built by SetupWithManager.
+	HwMgr *service.HwMgrService
+
+	// MaxConcurrentReconciles is the maximum number of NodePools that can be reconciled in parallel. Defaults to 1.
+	MaxConcurrentReconciles int
+
+	// ResyncInterval, if set, is the interval at which Provisioned NodePools are requeued to re-verify their
+	// allocation, catching drift that does not trigger an event. Defaults to 0, for no periodic resync.
+	ResyncInterval time.Duration
+
+	// Clock is used to timestamp successful reconciles, and to measure allocation deadlines and durations. If not set,
+	// the real clock is set by SetupWithManager.
+	Clock clock.PassiveClock
+
+	// InventoryDebounce is the period over which changes to the node inventory are coalesced before the NodePools are
+	// re-enqueued, so that a burst of edits to the nodelist configmap triggers a single reconcile of each NodePool.
+	// Defaults to 5 seconds.
+	InventoryDebounce time.Duration
+
+	// Recorder is used to record events on the NodePool CRs as they move through their lifecycle. If not set, one is
+	// obtained from the manager by SetupWithManager.
+	Recorder record.EventRecorder
+
+	// FinalizerRestoreGrace is how long the finalizer of a NodePool with allocated nodes may stay removed out-of-band
+	// before it is restored, giving an operator who removed it deliberately, such as to drop the NodePool without
+	// releasing its nodes, the time to delete it. Defaults to 0, restoring it on the next reconcile.
+	FinalizerRestoreGrace time.Duration
+
+	// SummaryLogger, if set, receives a single structured line for each completed allocation and release of a NodePool,
+	// for log-based analytics. It is typically backed by a JSON handler writing to a dedicated stream.
+	SummaryLogger *slog.Logger
+}
+
+// event records an event on a NodePool CR, if a recorder is set
+func (r *NodePoolReconciler) event(nodepool *hwmgmtv1alpha1.NodePool, eventtype, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(nodepool, eventtype, reason, messageFmt, args...)
+	}
 }
 
 func doNotRequeue() ctrl.Result { // nolint:unused
@@ -71,6 +145,13 @@ func requeueWithCustomInterval(interval time.Duration) ctrl.Result { // nolint:u
 	return ctrl.Result{RequeueAfter: interval}
 }
 
+// releasePending reports whether an error only indicates that a release of nodes must be repeated, such as while their
+// Node CRs are held by the finalizers of others, which is retried on an interval rather than the error backoff
+func releasePending(err error) bool {
+	var pending *service.ReleasePendingError
+	return goerrors.As(err, &pending)
+}
+
 //+kubebuilder:rbac:groups=o2ims-hardwaremanagement.oran.openshift.io,resources=nodepools,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups=o2ims-hardwaremanagement.oran.openshift.io,resources=nodepools/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=o2ims-hardwaremanagement.oran.openshift.io,resources=nodepools/finalizers,verbs=update
@@ -79,6 +160,7 @@ func requeueWithCustomInterval(interval time.Duration) ctrl.Result { // nolint:u
 //+kubebuilder:rbac:groups=o2ims-hardwaremanagement.oran.openshift.io,resources=nodes/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;create;update;patch;watch
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;create;update;patch;watch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -98,6 +180,7 @@ func (r *NodePoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (r
 	if err = r.Client.Get(ctx, req.NamespacedName, nodepool); err != nil {
 		if errors.IsNotFound(err) {
 			// The NodePool has likely been deleted
+			reconcileStaleness.Forget(req.NamespacedName)
 			err = nil
 			return
 		}
@@ -111,29 +194,344 @@ func (r *NodePoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (r
 
 	r.Logger.InfoContext(ctx, "[NodePool] "+nodepool.Name)
 
+	// A NodePool sharing the CloudID of an older one would act on its allocations, including releasing them on deletion
+	if nodepool.Namespace == r.HwMgr.Namespace() {
+		owner, err := r.cloudIDOwner(ctx, nodepool)
+		if err != nil {
+			return requeueWithError(err)
+		}
+		if owner != "" {
+			return r.rejectDuplicateCloudID(ctx, nodepool, owner)
+		}
+	}
+
 	if nodepool.GetDeletionTimestamp() != nil {
 		if controllerutil.ContainsFinalizer(nodepool, pluginFinalizer) {
-			if err := r.finalizer(ctx, nodepool); err != nil {
+			var done bool
+			if done, err = r.finalizer(ctx, nodepool); err != nil {
 				return requeueWithError(fmt.Errorf("finalizer failed: %w", err))
 			}
+			if !done {
+				// Keep the finalizer until the Node CRs of the NodePool are confirmed to be gone
+				return requeueWithCustomInterval(finalizerRequeueInterval), nil
+			}
 
 			controllerutil.RemoveFinalizer(nodepool, pluginFinalizer)
 			if err := r.Update(ctx, nodepool); err != nil {
 				return requeueWithError(fmt.Errorf("failed to update nodepool CR after removing finalizer: %w", err))
 			}
 
+			reconcileStaleness.Forget(req.NamespacedName)
 			return
 		}
 	}
 
+	// The manager cache is scoped to the plugin namespace, but guard against a NodePool from elsewhere, as its Node CRs
+	// and bmc-secrets would be created in the plugin namespace
+	if nodepool.Namespace != r.HwMgr.Namespace() {
+		return doNotRequeue(), r.rejectForeignNodePool(ctx, nodepool)
+	}
+
 	if !controllerutil.ContainsFinalizer(nodepool, pluginFinalizer) {
-		controllerutil.AddFinalizer(nodepool, pluginFinalizer)
-		if err := r.Update(ctx, nodepool); err != nil {
-			return requeueWithError(fmt.Errorf("failed to update nodepool CR after adding finalizer: %w", err))
+		remaining, err := r.addFinalizer(ctx, nodepool)
+		if err != nil {
+			return requeueWithError(err)
+		}
+		if remaining > 0 {
+			return requeueWithCustomInterval(remaining), nil
+		}
+	}
+
+	if paused, err := r.handlePause(ctx, nodepool); err != nil || paused {
+		return doNotRequeue(), err
+	}
+
+	if err := r.restoreMissingNodes(ctx, nodepool); err != nil {
+		return requeueWithError(err)
+	}
+
+	if err := r.reconcileBMCSecrets(ctx, nodepool); err != nil {
+		return requeueWithError(err)
+	}
+
+	if result, err = r.handleNodePoolObject(ctx, nodepool); err != nil {
+		return
+	}
+
+	if err := r.updateAllocationSummary(ctx, nodepool); err != nil {
+		return requeueWithError(err)
+	}
+
+	if err := r.recordReconcileTime(ctx, nodepool); err != nil {
+		return requeueWithError(err)
+	}
+
+	return
+}
+
+// rejectForeignNodePool marks a NodePool outside of the plugin namespace as failing validation, without allocating any
+// nodes to it
+func (r *NodePoolReconciler) rejectForeignNodePool(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+	message := fmt.Sprintf("NodePool must be in namespace %s, where the plugin manages its nodes", r.HwMgr.Namespace())
+	if condition := meta.FindStatusCondition(nodepool.Status.Conditions, string(utils.Validated)); condition != nil &&
+		condition.Reason == string(utils.NamespaceMismatch) {
+		return nil
+	}
+
+	r.Logger.WarnContext(ctx, "Rejecting NodePool outside of the plugin namespace",
+		"name", nodepool.Name, "namespace", nodepool.Namespace)
+	utils.SetStatusCondition(&nodepool.Status.Conditions,
+		utils.Validated,
+		utils.NamespaceMismatch,
+		metav1.ConditionFalse,
+		message)
+	utils.SetStatusCondition(&nodepool.Status.Conditions,
+		hwmgmtv1alpha1.Provisioned,
+		hwmgmtv1alpha1.Failed,
+		metav1.ConditionFalse,
+		message)
+	if err := utils.UpdateK8sCRStatus(ctx, r.Client, nodepool); err != nil {
+		return fmt.Errorf("failed to update status for NodePool %s: %w", nodepool.Name, err)
+	}
+
+	return nil
+}
+
+// cloudIDOwner gets the name of the NodePool that owns the CloudID of a NodePool, if it is another one. The oldest of
+// the NodePools with a CloudID owns it, with ties broken by name, including while it is being deleted, as its nodes
+// remain allocated until then.
+func (r *NodePoolReconciler) cloudIDOwner(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (string, error) {
+	nodepools := &hwmgmtv1alpha1.NodePoolList{}
+	if err := r.Client.List(ctx, nodepools, client.InNamespace(nodepool.Namespace)); err != nil {
+		return "", fmt.Errorf("failed to list NodePools: %w", err)
+	}
+
+	owner := nodepool
+	for i := range nodepools.Items {
+		other := &nodepools.Items[i]
+		if other.Spec.CloudID != nodepool.Spec.CloudID {
+			continue
+		}
+		if other.CreationTimestamp.Before(&owner.CreationTimestamp) ||
+			(other.CreationTimestamp.Equal(&owner.CreationTimestamp) && other.Name < owner.Name) {
+			owner = other
+		}
+	}
+
+	if owner.Name == nodepool.Name {
+		return "", nil
+	}
+	return owner.Name, nil
+}
+
+// rejectDuplicateCloudID marks a NodePool that shares the CloudID of an older NodePool as failing validation, without
+// allocating any nodes to it, and retries its admission periodically in case the conflict is resolved. If it is being
+// deleted, its finalizer is removed without releasing the nodes, which belong to the owner of the CloudID.
+func (r *NodePoolReconciler) rejectDuplicateCloudID(
+	ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool, owner string) (ctrl.Result, error) {
+	if nodepool.GetDeletionTimestamp() != nil {
+		if controllerutil.RemoveFinalizer(nodepool, pluginFinalizer) {
+			if err := r.Update(ctx, nodepool); err != nil {
+				return requeueWithError(fmt.Errorf("failed to update nodepool CR after removing finalizer: %w", err))
+			}
+		}
+		return doNotRequeue(), nil
+	}
+
+	message := fmt.Sprintf("CloudID %s is already used by NodePool %s", nodepool.Spec.CloudID, owner)
+	if condition := meta.FindStatusCondition(nodepool.Status.Conditions, string(utils.Validated)); condition != nil &&
+		condition.Reason == string(utils.DuplicateCloudID) && condition.Message == message {
+		return requeueWithLongInterval(), nil
+	}
+
+	r.Logger.WarnContext(ctx, "Rejecting NodePool with a duplicate CloudID",
+		"name", nodepool.Name, "cloudID", nodepool.Spec.CloudID, "owner", owner)
+	utils.SetStatusCondition(&nodepool.Status.Conditions,
+		utils.Validated,
+		utils.DuplicateCloudID,
+		metav1.ConditionFalse,
+		message)
+	utils.SetStatusCondition(&nodepool.Status.Conditions,
+		hwmgmtv1alpha1.Provisioned,
+		hwmgmtv1alpha1.Failed,
+		metav1.ConditionFalse,
+		message)
+	r.event(nodepool, corev1.EventTypeWarning, EventReasonDuplicateCloudID, "NodePool could not be admitted: %s", message)
+	if err := utils.UpdateK8sCRStatus(ctx, r.Client, nodepool); err != nil {
+		return requeueWithError(fmt.Errorf("failed to update status for NodePool %s: %w", nodepool.Name, err))
+	}
+
+	return requeueWithLongInterval(), nil
+}
+
+// addFinalizer adds the finalizer to a NodePool that lacks it. A NodePool with allocated nodes has had its finalizer
+// removed out-of-band, which would leak its nodes on deletion, so the finalizer is restored once the grace period has
+// elapsed since the removal was found, returning the time remaining until then.
+func (r *NodePoolReconciler) addFinalizer(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (time.Duration, error) {
+	allocated, err := r.HwMgr.GetAllocatedNodes(ctx, nodepool)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get allocated nodes for %s: %w", nodepool.Name, err)
+	}
+
+	if len(allocated) != 0 {
+		now := r.now()
+
+		removedAt, err := time.Parse(time.RFC3339, nodepool.Annotations[utils.FinalizerRemovedAtAnnotation])
+		if err != nil {
+			removedAt = now
+			if r.FinalizerRestoreGrace > 0 {
+				r.Logger.WarnContext(ctx, "Finalizer removed from NodePool with allocated nodes, restoring after grace period",
+					"name", nodepool.Name, "nodes", allocated, "grace", r.FinalizerRestoreGrace)
+				if nodepool.Annotations == nil {
+					nodepool.Annotations = make(map[string]string)
+				}
+				nodepool.Annotations[utils.FinalizerRemovedAtAnnotation] = now.UTC().Format(time.RFC3339)
+				if err := r.Update(ctx, nodepool); err != nil {
+					return 0, fmt.Errorf("failed to record finalizer removal for %s: %w", nodepool.Name, err)
+				}
+			}
+		}
+		if remaining := removedAt.Add(r.FinalizerRestoreGrace).Sub(now); remaining > 0 {
+			return remaining, nil
 		}
+
+		r.Logger.WarnContext(ctx, "Restoring finalizer removed from NodePool with allocated nodes",
+			"name", nodepool.Name, "nodes", allocated)
+		r.event(nodepool, corev1.EventTypeWarning, EventReasonFinalizerRestored,
+			"Finalizer restored, as the NodePool has %d allocated node(s)", len(allocated))
+	}
+
+	delete(nodepool.Annotations, utils.FinalizerRemovedAtAnnotation)
+	controllerutil.AddFinalizer(nodepool, pluginFinalizer)
+	if err := r.Update(ctx, nodepool); err != nil {
+		return 0, fmt.Errorf("failed to update nodepool CR after adding finalizer: %w", err)
+	}
+
+	return 0, nil
+}
+
+// now gets the current time from the reconciler's clock
+func (r *NodePoolReconciler) now() time.Time {
+	return r.Clock.Now()
+}
+
+// recordReconcileTime stamps the status of the NodePool with the time of its last successful reconcile, and records it
+// for the reconcile staleness metric. The status is merge patched, so that the stamp neither conflicts with nor
+// overwrites other changes to the NodePool.
+func (r *NodePoolReconciler) recordReconcileTime(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+	now := r.now()
+
+	patch := client.MergeFrom(nodepool.DeepCopy())
+	meta.SetStatusCondition(&nodepool.Status.Conditions, metav1.Condition{
+		Type:               string(utils.Reconciled),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(hwmgmtv1alpha1.Completed),
+		Message:            "Reconciled successfully",
+		ObservedGeneration: nodepool.Generation,
+	})
+	// The transition time is refreshed even though the condition is unchanged, as it records the reconcile time
+	meta.FindStatusCondition(nodepool.Status.Conditions, string(utils.Reconciled)).LastTransitionTime = metav1.NewTime(now)
+	if err := r.Status().Patch(ctx, nodepool, patch); err != nil {
+		return fmt.Errorf("failed to update last reconcile time for %s: %w", nodepool.Name, err)
+	}
+
+	reconcileStaleness.Observe(client.ObjectKeyFromObject(nodepool), now)
+	return nil
+}
+
+// updateAllocationSummary stamps the NodePool with an annotation summarizing its current allocation, for quick
+// inspection without digging into the nodelist configmap
+func (r *NodePoolReconciler) updateAllocationSummary(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+	allocations, err := r.HwMgr.GetAllAllocations(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get allocations for %s: %w", nodepool.Name, err)
+	}
+
+	nodegroups := allocations[nodepool.Spec.CloudID]
+	var counts []string
+	for _, nodegroup := range nodepool.Spec.NodeGroup {
+		counts = append(counts, fmt.Sprintf("%s=%d/%d", nodegroup.Name, len(nodegroups[nodegroup.Name]), nodegroup.Size))
+	}
+	slices.Sort(counts)
+	summary := strings.Join(counts, ",")
+
+	if nodepool.Annotations[utils.AllocationSummaryAnnotation] == summary {
+		return nil
+	}
+
+	if nodepool.Annotations == nil {
+		nodepool.Annotations = make(map[string]string)
+	}
+	nodepool.Annotations[utils.AllocationSummaryAnnotation] = summary
+	if err := r.Update(ctx, nodepool); err != nil {
+		return fmt.Errorf("failed to update allocation summary for %s: %w", nodepool.Name, err)
+	}
+
+	return nil
+}
+
+// setAllocationProgress sets the AllocationProgress condition of a NodePool to the allocated and requested node counts
+// of each of its nodegroups, in the order of its spec (e.g. "master: 2/3 allocated, worker: 0/2 allocated")
+func (r *NodePoolReconciler) setAllocationProgress(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+	allocations, err := r.HwMgr.GetAllAllocations(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get allocations for %s: %w", nodepool.Name, err)
+	}
+
+	nodegroups := allocations[nodepool.Spec.CloudID]
+	complete := true
+	progress := make([]string, 0, len(nodepool.Spec.NodeGroup))
+	for _, nodegroup := range nodepool.Spec.NodeGroup {
+		allocated := len(nodegroups[nodegroup.Name])
+		if allocated < nodegroup.Size {
+			complete = false
+		}
+		progress = append(progress, fmt.Sprintf("%s: %d/%d allocated", nodegroup.Name, allocated, nodegroup.Size))
+	}
+
+	reason, status := hwmgmtv1alpha1.InProgress, metav1.ConditionFalse
+	if complete {
+		reason, status = hwmgmtv1alpha1.Completed, metav1.ConditionTrue
+	}
+	utils.SetStatusCondition(&nodepool.Status.Conditions,
+		utils.AllocationProgress,
+		reason,
+		status,
+		strings.Join(progress, ", "))
+
+	return nil
+}
+
+// handlePause updates the Paused condition to reflect the paused annotation, returning true if reconciliation of the
+// NodePool is paused
+func (r *NodePoolReconciler) handlePause(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (bool, error) {
+	paused := utils.IsAnnotationTrue(nodepool, utils.PausedAnnotation)
+	condition := meta.FindStatusCondition(nodepool.Status.Conditions, string(utils.Paused))
+
+	switch {
+	case paused && (condition == nil || condition.Status != metav1.ConditionTrue):
+		r.Logger.InfoContext(ctx, "NodePool reconciliation paused", "name", nodepool.Name)
+		utils.SetStatusCondition(&nodepool.Status.Conditions,
+			utils.Paused,
+			utils.PauseRequested,
+			metav1.ConditionTrue,
+			"Reconciliation paused by the "+utils.PausedAnnotation+" annotation")
+	case !paused && condition != nil && condition.Status == metav1.ConditionTrue:
+		r.Logger.InfoContext(ctx, "NodePool reconciliation resumed", "name", nodepool.Name)
+		utils.SetStatusCondition(&nodepool.Status.Conditions,
+			utils.Paused,
+			utils.Resumed,
+			metav1.ConditionFalse,
+			"Reconciliation resumed")
+	default:
+		return paused, nil
 	}
 
-	return r.handleNodePoolObject(ctx, nodepool)
+	if err := utils.UpdateK8sCRStatus(ctx, r.Client, nodepool); err != nil {
+		return paused, fmt.Errorf("failed to update status for NodePool %s: %w", nodepool.Name, err)
+	}
+
+	return paused, nil
 }
 
 type NodePoolFSMAction int
@@ -142,45 +540,129 @@ const (
 	NodePoolFSMCreate = iota
 	NodePoolFSMProcessing
 	NodePoolFSMNoop
+	NodePoolFSMTerminal
 )
 
 func (r *NodePoolReconciler) determineAction(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) NodePoolFSMAction {
-	if len(nodepool.Status.Conditions) == 0 {
+	provisionedCondition := meta.FindStatusCondition(
+		nodepool.Status.Conditions,
+		string(hwmgmtv1alpha1.Provisioned))
+	if provisionedCondition == nil {
+		// Other conditions, such as Paused, may be set before the request is handled
 		r.Logger.InfoContext(ctx, "Handling Create NodePool request, name="+nodepool.Name)
 		return NodePoolFSMCreate
 	}
 
-	provisionedCondition := meta.FindStatusCondition(
-		nodepool.Status.Conditions,
-		string(hwmgmtv1alpha1.Provisioned))
-	if provisionedCondition != nil {
-		if provisionedCondition.Status == metav1.ConditionTrue {
-			r.Logger.InfoContext(ctx, "NodePool request in Provisioned state, name="+nodepool.Name)
-			return NodePoolFSMNoop
-		}
+	if terminalFailure(nodepool) {
+		r.Logger.InfoContext(ctx, "NodePool request failed until its spec changes, name="+nodepool.Name)
+		return NodePoolFSMTerminal
+	}
+
+	if admissionDeferred(nodepool) {
+		r.Logger.InfoContext(ctx, "Retrying admission of NodePool request, name="+nodepool.Name)
+		return NodePoolFSMCreate
+	}
 
-		return NodePoolFSMProcessing
+	if provisionedCondition.Status == metav1.ConditionTrue {
+		r.Logger.InfoContext(ctx, "NodePool request in Provisioned state, name="+nodepool.Name)
+		return NodePoolFSMNoop
 	}
 
-	return NodePoolFSMNoop
+	return NodePoolFSMProcessing
+}
+
+// admissionDeferred reports whether the admission of a NodePool was refused for a lack of nodes, or of its hardware
+// profile, for a cordoned hardware profile, for a malformed spec, or for a CloudID conflict, in which case it is retried
+// rather than the NodePool being processed
+func admissionDeferred(nodepool *hwmgmtv1alpha1.NodePool) bool {
+	condition := meta.FindStatusCondition(nodepool.Status.Conditions, string(utils.Validated))
+	return condition != nil && condition.Status == metav1.ConditionFalse &&
+		(condition.Reason == string(utils.InsufficientResources) || condition.Reason == string(utils.ExceedsCapacity) ||
+			condition.Reason == string(utils.UnknownProfile) || condition.Reason == string(utils.ProfileCordoned) ||
+			condition.Reason == string(utils.InvalidSpec) || condition.Reason == string(utils.DuplicateCloudID))
+}
+
+// terminalFailure reports whether the admission of the current generation of a NodePool was refused for a reason that
+// only an operator can resolve, such as by changing its spec, in which case it is not retried until its spec changes
+func terminalFailure(nodepool *hwmgmtv1alpha1.NodePool) bool {
+	condition := meta.FindStatusCondition(nodepool.Status.Conditions, string(utils.Validated))
+	return condition != nil && condition.Status == metav1.ConditionFalse &&
+		(condition.Reason == string(utils.ExceedsCapacity) || condition.Reason == string(utils.UnknownProfile) ||
+			condition.Reason == string(utils.InvalidSpec)) &&
+		condition.ObservedGeneration == nodepool.Generation
 }
 
 func (r *NodePoolReconciler) handleNodePoolCreate(
 	ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (ctrl.Result, error) {
-	if err := r.hwmgr.ProcessNewNodePool(ctx, nodepool); err != nil {
+	result := doNotRequeue()
+	if err := r.HwMgr.ProcessNewNodePool(ctx, nodepool); err != nil {
 		r.Logger.Error("failed createNodePool", "err", err)
+		reason := hwmgmtv1alpha1.Failed
+		provisionedReason := hwmgmtv1alpha1.Failed
+		provisionedMessage := "Creation request failed: "
+		var cordoned *service.ProfileCordonedError
+		var insufficient *service.InsufficientResourcesError
+		var exceeds *service.ExceedsCapacityError
+		var unknown *service.UnknownProfileError
+		var invalid *service.InvalidSpecError
+		switch {
+		case goerrors.As(err, &cordoned):
+			reason = utils.ProfileCordoned
+		case goerrors.As(err, &insufficient):
+			// The shortage may be resolved as nodes are freed, so admission is retried periodically
+			reason = utils.InsufficientResources
+			provisionedReason = utils.InsufficientResources
+			provisionedMessage = "Waiting for free nodes: "
+			result = requeueWithLongInterval()
+		case goerrors.As(err, &exceeds):
+			// The request can never be satisfied by the current inventory, so admission is only retried when the
+			// spec of the NodePool changes
+			reason = utils.ExceedsCapacity
+		case goerrors.As(err, &unknown):
+			reason = utils.UnknownProfile
+		case goerrors.As(err, &invalid):
+			reason = utils.InvalidSpec
+		}
+		utils.SetStatusCondition(&nodepool.Status.Conditions,
+			utils.Validated,
+			reason,
+			metav1.ConditionFalse,
+			"Validation failed: "+err.Error())
+		if exceeds != nil || unknown != nil || invalid != nil {
+			// Record the generation that failed, so that the failure is terminal until the spec changes
+			meta.FindStatusCondition(nodepool.Status.Conditions, string(utils.Validated)).ObservedGeneration =
+				nodepool.Generation
+		}
 		utils.SetStatusCondition(&nodepool.Status.Conditions,
 			hwmgmtv1alpha1.Provisioned,
-			hwmgmtv1alpha1.Failed,
+			provisionedReason,
 			metav1.ConditionFalse,
-			"Creation request failed: "+err.Error())
+			provisionedMessage+err.Error())
+
+		eventReason := EventReasonValidationFailed
+		if insufficient != nil {
+			eventReason = EventReasonInsufficientResources
+		}
+		r.event(nodepool, corev1.EventTypeWarning, eventReason, "NodePool could not be admitted: %s", err.Error())
+		if err := r.updateQueuePosition(ctx, nodepool, insufficient); err != nil {
+			return requeueWithError(err)
+		}
 	} else {
-		// Update the condition
+		// Update the conditions
+		utils.SetStatusCondition(&nodepool.Status.Conditions,
+			utils.Validated,
+			hwmgmtv1alpha1.Completed,
+			metav1.ConditionTrue,
+			"Validated")
 		utils.SetStatusCondition(&nodepool.Status.Conditions,
 			hwmgmtv1alpha1.Provisioned,
 			hwmgmtv1alpha1.InProgress,
 			metav1.ConditionFalse,
 			"Handling creation")
+		r.event(nodepool, corev1.EventTypeNormal, EventReasonProcessing, "NodePool admitted, allocating nodes")
+		if err := r.updateQueuePosition(ctx, nodepool, nil); err != nil {
+			return requeueWithError(err)
+		}
 	}
 
 	if updateErr := utils.UpdateK8sCRStatus(ctx, r.Client, nodepool); updateErr != nil {
@@ -188,46 +670,395 @@ func (r *NodePoolReconciler) handleNodePoolCreate(
 			fmt.Errorf("failed to update status for NodePool %s: %w", nodepool.Name, updateErr)
 	}
 
-	return doNotRequeue(), nil
+	return result, nil
+}
+
+// countAllocationReconcile records a processing pass of the NodePool, towards the number of reconciles it takes to be
+// fully allocated and provisioned
+func (r *NodePoolReconciler) countAllocationReconcile(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+	utils.IncrementCounterAnnotation(nodepool, utils.AllocationReconcilesAnnotation, 1)
+	if err := r.Update(ctx, nodepool); err != nil {
+		return fmt.Errorf("failed to count allocation reconcile for %s: %w", nodepool.Name, err)
+	}
+
+	return nil
+}
+
+// markProvisioned records when the NodePool was first provisioned
+func (r *NodePoolReconciler) markProvisioned(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+	if nodepool.Annotations == nil {
+		nodepool.Annotations = make(map[string]string)
+	}
+	nodepool.Annotations[utils.ProvisionedAtAnnotation] = r.now().UTC().Format(time.RFC3339)
+	if err := r.Update(ctx, nodepool); err != nil {
+		return fmt.Errorf("failed to record provisioning time for %s: %w", nodepool.Name, err)
+	}
+
+	return nil
+}
+
+// handleAllocationDeadline checks whether the NodePool has exceeded its allocation deadline, if one is set. If so, any
+// partially allocated nodes are released and the NodePool is marked as failed.
+func (r *NodePoolReconciler) handleAllocationDeadline(
+	ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (exceeded bool, remaining time.Duration, err error) {
+	deadline, err := utils.GetDurationAnnotation(nodepool, utils.AllocationDeadlineAnnotation)
+	if err != nil {
+		r.Logger.ErrorContext(ctx, "invalid allocation deadline", "name", nodepool.Name, "err", err)
+		utils.SetStatusCondition(&nodepool.Status.Conditions,
+			hwmgmtv1alpha1.Provisioned,
+			hwmgmtv1alpha1.Failed,
+			metav1.ConditionFalse,
+			err.Error())
+		return true, 0, nil
+	}
+
+	// The deadline applies to the initial allocation, not to topping up a NodePool that was already provisioned
+	if _, provisioned := nodepool.Annotations[utils.ProvisionedAtAnnotation]; deadline == 0 || provisioned {
+		return false, 0, nil
+	}
+
+	remaining = nodepool.CreationTimestamp.Add(deadline).Sub(r.now())
+	if remaining > 0 {
+		return false, remaining, nil
+	}
+
+	r.Logger.InfoContext(ctx, "NodePool allocation deadline exceeded, releasing allocated nodes",
+		"name", nodepool.Name, "deadline", deadline)
+
+	// A pending release is returned as is, so that it is repeated before the NodePool is marked as failed
+	if err := r.HwMgr.ReleaseNodePool(ctx, nodepool); err != nil {
+		return false, 0, fmt.Errorf("failed to release nodepool %s after allocation deadline: %w", nodepool.Name, err)
+	}
+	nodepool.Status.Properties.NodeNames = nil
+
+	utils.SetStatusCondition(&nodepool.Status.Conditions,
+		hwmgmtv1alpha1.Provisioned,
+		hwmgmtv1alpha1.Failed,
+		metav1.ConditionFalse,
+		fmt.Sprintf("Allocation deadline of %s exceeded", deadline))
+
+	return true, 0, nil
 }
 
 func (r *NodePoolReconciler) handleNodePoolProcessing(
 	ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (ctrl.Result, error) {
-	full, err := r.hwmgr.CheckNodePoolProgress(ctx, nodepool)
+	if err := r.countAllocationReconcile(ctx, nodepool); err != nil {
+		return requeueWithError(err)
+	}
+
+	exceeded, deadlineRemaining, err := r.handleAllocationDeadline(ctx, nodepool)
+	if releasePending(err) {
+		r.Logger.InfoContext(ctx, "Waiting to release nodes after allocation deadline", "name", nodepool.Name,
+			"reason", err.Error())
+		return requeueWithShortInterval(), nil
+	}
+	if err != nil {
+		return requeueWithError(err)
+	}
+	if exceeded {
+		if updateErr := utils.UpdateK8sCRStatus(ctx, r.Client, nodepool); updateErr != nil {
+			return requeueWithError(fmt.Errorf("failed to update status for NodePool %s: %w", nodepool.Name, updateErr))
+		}
+		return doNotRequeue(), nil
+	}
+
+	full, err := r.HwMgr.CheckNodePoolProgress(ctx, nodepool)
+	var insufficient *service.InsufficientResourcesError
+	var cordoned *service.ProfileCordonedError
+	if goerrors.As(err, &insufficient) || goerrors.As(err, &cordoned) {
+		// No progress is possible until nodes are freed or added, or the profile is uncordoned, so check back less
+		// often rather than hot-looping
+		r.Logger.InfoContext(ctx, "NodePool allocation stalled", "name", nodepool.Name, "reason", err.Error())
+		reason := utils.InsufficientResources
+		if cordoned != nil {
+			reason = utils.ProfileCordoned
+		}
+		utils.SetStatusCondition(&nodepool.Status.Conditions,
+			hwmgmtv1alpha1.Provisioned,
+			reason,
+			metav1.ConditionFalse,
+			err.Error())
+		r.event(nodepool, corev1.EventTypeWarning, string(reason), "NodePool allocation stalled: %s", err.Error())
+		if err := r.setAllocationProgress(ctx, nodepool); err != nil {
+			return requeueWithError(err)
+		}
+		if err := r.updateQueuePosition(ctx, nodepool, insufficient); err != nil {
+			return requeueWithError(err)
+		}
+		if updateErr := utils.UpdateK8sCRStatus(ctx, r.Client, nodepool); updateErr != nil {
+			return requeueWithError(fmt.Errorf("failed to update status for NodePool %s: %w", nodepool.Name, updateErr))
+		}
+
+		result := requeueWithLongInterval()
+		if deadlineRemaining > 0 && deadlineRemaining < result.RequeueAfter {
+			// Check back in time to fail the allocation at its deadline
+			result = requeueWithCustomInterval(deadlineRemaining)
+		}
+		return result, nil
+	}
+	if releasePending(err) {
+		r.Logger.InfoContext(ctx, "Waiting to release surplus nodes", "name", nodepool.Name, "reason", err.Error())
+		return requeueWithShortInterval(), nil
+	}
 	if err != nil {
+		if deadlineRemaining > 0 {
+			// Avoid the error backoff delaying the failure past the deadline
+			r.Logger.ErrorContext(ctx, "failed CheckNodePoolProgress", "name", nodepool.Name, "err", err)
+			return requeueWithCustomInterval(min(deadlineRemaining, 15*time.Second)), nil
+		}
 		return requeueWithError(fmt.Errorf("failed CheckNodePoolProgress: %w", err))
 	}
 
-	allocatedNodes, err := r.hwmgr.GetAllocatedNodes(ctx, nodepool)
+	// Provisioning of the allocated nodes completes after a delay, so requeue rather than wait for it
+	provisionDelay, err := r.HwMgr.ProvisionAllocatedNodes(ctx, nodepool)
+	if err != nil {
+		return requeueWithError(fmt.Errorf("failed to provision nodes for %s: %w", nodepool.Name, err))
+	}
+
+	// Unconfirmed tentative allocations that have expired are freed, to be reallocated on the next requeue
+	heldUntil, expired, err := r.HwMgr.ReconcileTentativeAllocations(ctx, nodepool)
+	if releasePending(err) {
+		r.Logger.InfoContext(ctx, "Waiting to free expired tentative allocations", "name", nodepool.Name, "reason", err.Error())
+		return requeueWithShortInterval(), nil
+	}
+	if err != nil {
+		return requeueWithError(fmt.Errorf("failed to handle tentative allocations for %s: %w", nodepool.Name, err))
+	}
+	if len(expired) != 0 {
+		full = false
+	}
+
+	allocatedNodes, err := r.HwMgr.GetAllocatedNodes(ctx, nodepool)
 	if err != nil {
 		return requeueWithError(fmt.Errorf("failed to get allocated nodes for %s: %w", nodepool.Name, err))
 	}
 	nodepool.Status.Properties.NodeNames = allocatedNodes
+	if err := r.setAllocationProgress(ctx, nodepool); err != nil {
+		return requeueWithError(err)
+	}
+	if err := r.updateQueuePosition(ctx, nodepool, nil); err != nil {
+		return requeueWithError(err)
+	}
 
 	var result ctrl.Result
+	var firstProvisioned bool
 
 	if full {
 		r.Logger.InfoContext(ctx, "NodePool request is fully allocated, name="+nodepool.Name)
 
-		utils.SetStatusCondition(&nodepool.Status.Conditions,
-			hwmgmtv1alpha1.Provisioned,
-			hwmgmtv1alpha1.Completed,
-			metav1.ConditionTrue,
-			"Created")
+		provisioned, err := r.HwMgr.IsNodePoolProvisioned(ctx, nodepool)
+		if err != nil {
+			return requeueWithError(fmt.Errorf("failed to check provisioning for %s: %w", nodepool.Name, err))
+		}
+
+		if provisioned && !heldUntil.IsZero() {
+			// The hold on the nodes lasts until the first pending allocation expires
+			expiry := heldUntil.UTC().Format(time.RFC3339)
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				utils.Configured,
+				hwmgmtv1alpha1.InProgress,
+				metav1.ConditionFalse,
+				"Waiting for tentative allocations to be confirmed")
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				utils.Allocated,
+				metav1.ConditionFalse,
+				"Nodes provisioned, waiting for tentative allocations to be confirmed, held until "+expiry)
+
+			result = requeueWithCustomInterval(min(max(heldUntil.Sub(r.now()), time.Second), 15*time.Second))
+		} else if provisioned {
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				utils.Configured,
+				hwmgmtv1alpha1.Completed,
+				metav1.ConditionTrue,
+				"Nodes configured")
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.Completed,
+				metav1.ConditionTrue,
+				"Created")
 
-		result = doNotRequeue()
+			if count, err := strconv.Atoi(nodepool.Annotations[utils.AllocationReconcilesAnnotation]); err == nil {
+				r.Logger.InfoContext(ctx, "NodePool provisioned", "name", nodepool.Name, "reconciles", count)
+				allocationReconciles.Observe(float64(count))
+			}
+			if _, exists := nodepool.Annotations[utils.ProvisionedAtAnnotation]; !exists {
+				firstProvisioned = true
+				r.event(nodepool, corev1.EventTypeNormal, EventReasonProvisioned,
+					"NodePool provisioned with nodes: %s", strings.Join(allocatedNodes, ", "))
+				var duration time.Duration
+				if !nodepool.CreationTimestamp.IsZero() {
+					duration = r.now().Sub(nodepool.CreationTimestamp.Time)
+					observeAllocationDuration(nodepool, duration)
+				}
+				r.logSummary(ctx, nodepool, summaryOperationAllocation, allocatedNodes, duration)
+			}
+
+			result = doNotRequeue()
+		} else {
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				utils.Configured,
+				hwmgmtv1alpha1.InProgress,
+				metav1.ConditionFalse,
+				"Waiting for nodes to be provisioned")
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				utils.Allocated,
+				metav1.ConditionFalse,
+				"Nodes allocated, waiting for provisioning")
+
+			result = requeueWithShortInterval()
+			if provisionDelay > 0 {
+				result = requeueWithCustomInterval(provisionDelay)
+			}
+		}
 	} else {
 		r.Logger.InfoContext(ctx, "NodePool request in progress, name="+nodepool.Name)
 		result = requeueWithShortInterval()
+		if deadlineRemaining > 0 && deadlineRemaining < result.RequeueAfter {
+			// Check back in time to fail the allocation at its deadline
+			result = requeueWithCustomInterval(deadlineRemaining)
+		}
 	}
 
 	if updateErr := utils.UpdateK8sCRStatus(ctx, r.Client, nodepool); updateErr != nil {
 		return requeueWithError(fmt.Errorf("failed to update status for NodePool %s: %w", nodepool.Name, updateErr))
 	}
 
+	// The annotation is recorded once the status is updated, as the update of the NodePool returns its stored status
+	if firstProvisioned {
+		if err := r.markProvisioned(ctx, nodepool); err != nil {
+			return requeueWithError(err)
+		}
+	}
+
 	return result, nil
 }
 
+// handleNodePoolResync re-verifies the allocation of a Provisioned NodePool, releasing the surplus nodes of scaled down
+// nodegroups, and returning it to processing if it is no longer fully allocated, such as after one of its Node CRs is
+// deleted or a nodegroup is scaled up. It is requeued if periodic resync is enabled.
+func (r *NodePoolReconciler) handleNodePoolResync(
+	ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (ctrl.Result, error) {
+	released, err := r.HwMgr.ReconcileNodePoolSize(ctx, nodepool)
+	if releasePending(err) {
+		r.Logger.InfoContext(ctx, "Waiting to release surplus nodes", "name", nodepool.Name, "reason", err.Error())
+		return requeueWithShortInterval(), nil
+	}
+	if err != nil {
+		return requeueWithError(fmt.Errorf("failed to release surplus nodes for %s: %w", nodepool.Name, err))
+	}
+	if len(released) != 0 {
+		r.Logger.InfoContext(ctx, "Released surplus nodes of scaled down NodePool", "name", nodepool.Name, "nodes", released)
+		allocatedNodes, err := r.HwMgr.GetAllocatedNodes(ctx, nodepool)
+		if err != nil {
+			return requeueWithError(fmt.Errorf("failed to get allocated nodes for %s: %w", nodepool.Name, err))
+		}
+		nodepool.Status.Properties.NodeNames = allocatedNodes
+		if err := utils.UpdateK8sCRStatus(ctx, r.Client, nodepool); err != nil {
+			return requeueWithError(fmt.Errorf("failed to update status for NodePool %s: %w", nodepool.Name, err))
+		}
+	}
+
+	scaled, err := r.HwMgr.UnderAllocatedNodeGroups(ctx, nodepool)
+	if err != nil {
+		return requeueWithError(fmt.Errorf("failed to check nodegroup sizes for %s: %w", nodepool.Name, err))
+	}
+	if len(scaled) != 0 {
+		r.Logger.InfoContext(ctx, "NodePool nodegroups request more nodes, scaling up", "name", nodepool.Name, "nodegroups", scaled)
+		if err := r.returnToProcessing(ctx, nodepool, "Scaling up nodegroups: "+strings.Join(scaled, ", ")); err != nil {
+			return requeueWithError(err)
+		}
+		return requeueWithShortInterval(), nil
+	}
+
+	full, err := r.HwMgr.IsNodeFullyAllocated(ctx, nodepool)
+	if err != nil {
+		return requeueWithError(fmt.Errorf("failed to verify allocation for %s: %w", nodepool.Name, err))
+	}
+	if full {
+		// A node allocated since the NodePool was provisioned, such as one swapped in, is provisioned by processing
+		provisioned, err := r.HwMgr.IsNodePoolProvisioned(ctx, nodepool)
+		if err != nil {
+			return requeueWithError(fmt.Errorf("failed to check provisioning for %s: %w", nodepool.Name, err))
+		}
+		if !provisioned {
+			r.Logger.InfoContext(ctx, "NodePool has unprovisioned nodes, provisioning", "name", nodepool.Name)
+			if err := r.returnToProcessing(ctx, nodepool, "Provisioning newly allocated nodes"); err != nil {
+				return requeueWithError(err)
+			}
+			return requeueWithShortInterval(), nil
+		}
+
+		if r.ResyncInterval == 0 {
+			return doNotRequeue(), nil
+		}
+		return requeueWithCustomInterval(r.ResyncInterval), nil
+	}
+
+	r.Logger.WarnContext(ctx, "NodePool is no longer fully allocated, reallocating", "name", nodepool.Name)
+	if err := r.returnToProcessing(ctx, nodepool, "Allocation drift detected, reallocating"); err != nil {
+		return requeueWithError(err)
+	}
+
+	return requeueWithShortInterval(), nil
+}
+
+// returnToProcessing moves a Provisioned NodePool back to processing, restarting the count of its allocation reconciles
+func (r *NodePoolReconciler) returnToProcessing(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool, message string) error {
+	if _, exists := nodepool.Annotations[utils.AllocationReconcilesAnnotation]; exists {
+		delete(nodepool.Annotations, utils.AllocationReconcilesAnnotation)
+		if err := r.Update(ctx, nodepool); err != nil {
+			return fmt.Errorf("failed to reset allocation reconciles for %s: %w", nodepool.Name, err)
+		}
+	}
+
+	utils.SetStatusCondition(&nodepool.Status.Conditions,
+		hwmgmtv1alpha1.Provisioned,
+		hwmgmtv1alpha1.InProgress,
+		metav1.ConditionFalse,
+		message)
+	if err := utils.UpdateK8sCRStatus(ctx, r.Client, nodepool); err != nil {
+		return fmt.Errorf("failed to update status for NodePool %s: %w", nodepool.Name, err)
+	}
+	r.event(nodepool, corev1.EventTypeNormal, EventReasonProcessing, "%s", message)
+
+	return nil
+}
+
+// restoreMissingNodes recreates the Node CRs of allocated nodes that are missing them, returning a Provisioned NodePool
+// to processing so the restored nodes are provisioned again
+func (r *NodePoolReconciler) restoreMissingNodes(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+	restored, err := r.HwMgr.RestoreMissingNodes(ctx, nodepool)
+	if err != nil {
+		return fmt.Errorf("failed to restore missing nodes for %s: %w", nodepool.Name, err)
+	}
+	if len(restored) == 0 {
+		return nil
+	}
+
+	r.Logger.WarnContext(ctx, "Restored missing Node CRs", "name", nodepool.Name, "nodes", restored)
+	if !meta.IsStatusConditionTrue(nodepool.Status.Conditions, string(hwmgmtv1alpha1.Provisioned)) {
+		return nil
+	}
+
+	return r.returnToProcessing(ctx, nodepool, "Restored missing nodes, provisioning")
+}
+
+// reconcileBMCSecrets brings the bmc-secrets of the nodes allocated to a NodePool back in line with the credentials in
+// the inventory
+func (r *NodePoolReconciler) reconcileBMCSecrets(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+	reconciled, err := r.HwMgr.ReconcileBMCSecrets(ctx, nodepool)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile bmc-secrets for %s: %w", nodepool.Name, err)
+	}
+	if len(reconciled) != 0 {
+		r.Logger.InfoContext(ctx, "Updated bmc-secrets to match the inventory", "name", nodepool.Name, "nodes", reconciled)
+	}
+
+	return nil
+}
+
 func (r *NodePoolReconciler) handleNodePoolObject(
 	ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (result ctrl.Result, err error) {
 	result = doNotRequeue()
@@ -238,41 +1069,170 @@ func (r *NodePoolReconciler) handleNodePoolObject(
 	case NodePoolFSMProcessing:
 		return r.handleNodePoolProcessing(ctx, nodepool)
 	case NodePoolFSMNoop:
-		// Nothing to do
-		return
+		return r.handleNodePoolResync(ctx, nodepool)
+	case NodePoolFSMTerminal:
+		return doNotRequeue(), nil
 	}
 
 	return
 }
 
-func (r *NodePoolReconciler) finalizer(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+// finalizer releases the nodes of a NodePool, returning true once all of its Node CRs are gone and the finalizer can
+// be removed
+func (r *NodePoolReconciler) finalizer(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (bool, error) {
 	r.Logger.InfoContext(ctx, "Finalizing nodepool", "name", nodepool.Name)
 
-	if err := r.hwmgr.ReleaseNodePool(ctx, nodepool); err != nil {
-		return fmt.Errorf("failed to release nodepool %s: %w", nodepool.Name, err)
+	allocated, err := r.HwMgr.GetAllocatedNodes(ctx, nodepool)
+	if err != nil {
+		return false, fmt.Errorf("failed to get allocated nodes for %s: %w", nodepool.Name, err)
 	}
 
-	return nil
+	if err := r.HwMgr.ReleaseNodePool(ctx, nodepool); releasePending(err) {
+		r.Logger.InfoContext(ctx, "Waiting to release nodepool", "name", nodepool.Name, "reason", err.Error())
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to release nodepool %s: %w", nodepool.Name, err)
+	}
+
+	// The release may wait for the nodes to be powered off, so it is only summarized once they are freed
+	if len(allocated) != 0 {
+		unreleased, err := r.HwMgr.GetAllocatedNodes(ctx, nodepool)
+		if err != nil {
+			return false, fmt.Errorf("failed to get allocated nodes for %s: %w", nodepool.Name, err)
+		}
+		if len(unreleased) == 0 {
+			r.logSummary(ctx, nodepool, summaryOperationRelease, allocated,
+				r.now().Sub(nodepool.GetDeletionTimestamp().Time))
+		}
+	}
+
+	remaining, err := r.HwMgr.GetNodePoolNodes(ctx, nodepool)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for remaining nodes of nodepool %s: %w", nodepool.Name, err)
+	}
+	if len(remaining) != 0 {
+		r.Logger.InfoContext(ctx, "Waiting for nodes to be deleted", "name", nodepool.Name, "nodes", remaining)
+		return false, nil
+	}
+
+	return true, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *NodePoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	ctx := context.TODO()
 
-	if hwmgr, err := service.NewHwMgrService().
-		SetClient(mgr.GetClient()).
-		SetLogger(r.Logger).
-		Build(ctx); err != nil {
-		return fmt.Errorf("failed to create HwMgrService: %w", err)
-	} else {
-		r.hwmgr = hwmgr
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor(eventSource)
+	}
+
+	if r.Clock == nil {
+		r.Clock = clock.RealClock{}
+	}
+	reconcileStaleness.setClock(r.Clock)
+
+	if r.HwMgr == nil {
+		if hwmgr, err := service.NewHwMgrService().
+			SetClient(mgr.GetClient()).
+			SetLogger(r.Logger).
+			SetEventRecorder(r.Recorder).
+			Build(ctx); err != nil {
+			return fmt.Errorf("failed to create HwMgrService: %w", err)
+		} else {
+			r.HwMgr = hwmgr
+		}
 	}
 
 	if err := ctrl.NewControllerManagedBy(mgr).
-		For(&hwmgmtv1alpha1.NodePool{}).
+		For(&hwmgmtv1alpha1.NodePool{}, builder.WithPredicates(ignoreBookkeepingUpdates())).
+		Watches(&hwmgmtv1alpha1.Node{},
+			handler.EnqueueRequestsFromMapFunc(r.mapNodeToNodePools),
+			builder.WithPredicates(predicate.Funcs{
+				// Only deletions of Node CRs affect the allocation of their NodePool
+				CreateFunc: func(event.CreateEvent) bool { return false },
+				UpdateFunc: func(e event.UpdateEvent) bool { return e.ObjectNew.GetDeletionTimestamp() != nil },
+				DeleteFunc: func(event.DeleteEvent) bool { return true },
+			})).
+		Watches(&corev1.ConfigMap{}, r.inventoryChangeHandler()).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r); err != nil {
 		return fmt.Errorf("failed to create controller: %w", err)
 	}
 
 	return nil
 }
+
+// inventoryChangeHandler re-enqueues all NodePools when the node inventory in the nodelist configmap changes, such as
+// to retry a NodePool waiting for capacity. Each NodePool is enqueued after the debounce period, and the workqueue
+// coalesces the requests for any further changes within that period into the pending one.
+func (r *NodePoolReconciler) inventoryChangeHandler() handler.EventHandler {
+	return handler.Funcs{
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			if !r.HwMgr.InventoryChanged(e.ObjectOld, e.ObjectNew) {
+				return
+			}
+
+			nodepools := &hwmgmtv1alpha1.NodePoolList{}
+			if err := r.Client.List(ctx, nodepools, client.InNamespace(e.ObjectNew.GetNamespace())); err != nil {
+				r.Logger.ErrorContext(ctx, "failed to list NodePools", "error", err)
+				return
+			}
+
+			debounce := r.InventoryDebounce
+			if debounce == 0 {
+				debounce = defaultInventoryDebounce
+			}
+			for _, nodepool := range nodepools.Items {
+				q.AddAfter(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&nodepool)}, debounce)
+			}
+		},
+	}
+}
+
+// ignoreBookkeepingUpdates filters out NodePool updates that only change the annotations maintained by the plugin
+// itself, or the time of its last reconcile, which would otherwise trigger a new reconcile after each successful one
+func ignoreBookkeepingUpdates() predicate.Predicate {
+	strip := func(object client.Object) client.Object {
+		object = object.DeepCopyObject().(client.Object)
+		if nodepool, ok := object.(*hwmgmtv1alpha1.NodePool); ok {
+			meta.RemoveStatusCondition(&nodepool.Status.Conditions, string(utils.Reconciled))
+		}
+		annotations := object.GetAnnotations()
+		delete(annotations, utils.AllocationSummaryAnnotation)
+		delete(annotations, utils.AllocationReconcilesAnnotation)
+		delete(annotations, utils.ProvisionedAtAnnotation)
+		delete(annotations, utils.FinalizerRemovedAtAnnotation)
+		object.SetAnnotations(annotations)
+		object.SetResourceVersion("")
+		object.SetManagedFields(nil)
+		return object
+	}
+
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !equality.Semantic.DeepEqual(strip(e.ObjectOld), strip(e.ObjectNew))
+		},
+	}
+}
+
+// mapNodeToNodePools gets the NodePools to which a Node CR is allocated, to be reconciled as the Node is deleted
+func (r *NodePoolReconciler) mapNodeToNodePools(ctx context.Context, object client.Object) (requests []reconcile.Request) {
+	node, ok := object.(*hwmgmtv1alpha1.Node)
+	if !ok || node.Spec.NodePool == "" {
+		return
+	}
+
+	nodepools := &hwmgmtv1alpha1.NodePoolList{}
+	if err := r.Client.List(ctx, nodepools, client.InNamespace(node.Namespace)); err != nil {
+		r.Logger.ErrorContext(ctx, "failed to list NodePools", "error", err)
+		return
+	}
+
+	for _, nodepool := range nodepools.Items {
+		if nodepool.Spec.CloudID == node.Spec.NodePool {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&nodepool)})
+		}
+	}
+
+	return
+}
@@ -17,9 +17,153 @@ limitations under the License.
 package hardwaremanagement
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/service"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
 )
 
+const (
+	testNamespace = "oran-hwmgr-plugin-test"
+
+	testResources = `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    bmc:
+      address: "redfish+https://192.168.1.0/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+    hostname: node-a-0.localhost
+  node-a-1:
+    hwprofile: profile-a
+    bmc:
+      address: "redfish+https://192.168.1.1/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+    hostname: node-a-1.localhost
+`
+)
+
+func newTestReconciler(objs ...client.Object) (*NodePoolReconciler, client.Client) {
+	Expect(os.Setenv("MY_POD_NAMESPACE", testNamespace)).To(Succeed())
+
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(hwmgmtv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&hwmgmtv1alpha1.NodePool{}, &hwmgmtv1alpha1.Node{}).
+		Build()
+
+	logger := slog.New(slog.NewTextHandler(GinkgoWriter, nil))
+	hwmgr, err := service.NewHwMgrService().
+		SetClient(c).
+		SetLogger(logger).
+		Build(context.Background())
+	Expect(err).ToNot(HaveOccurred())
+
+	return &NodePoolReconciler{
+		Client: c,
+		Scheme: scheme,
+		Logger: logger,
+		HwMgr:  hwmgr,
+		Clock:  clock.RealClock{},
+	}, c
+}
+
+func newNodelistConfigMap(allocations string) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nodelist",
+			Namespace: testNamespace,
+		},
+		Data: map[string]string{
+			"resources": testResources,
+		},
+	}
+	if allocations != "" {
+		cm.Data["allocations"] = allocations
+	}
+	return cm
+}
+
+func newNodePool(name, cloudID string, groups ...hwmgmtv1alpha1.NodeGroup) *hwmgmtv1alpha1.NodePool {
+	return &hwmgmtv1alpha1.NodePool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  testNamespace,
+			Finalizers: []string{pluginFinalizer},
+		},
+		Spec: hwmgmtv1alpha1.NodePoolSpec{
+			CloudID:   cloudID,
+			NodeGroup: groups,
+		},
+	}
+}
+
+func newNode(name, cloudID, groupname string) *hwmgmtv1alpha1.Node {
+	return &hwmgmtv1alpha1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Spec: hwmgmtv1alpha1.NodeSpec{
+			NodePool:  cloudID,
+			GroupName: groupname,
+			HwProfile: "profile-a",
+		},
+	}
+}
+
+func reconcileNodePool(ctx context.Context, r *NodePoolReconciler, nodepool *hwmgmtv1alpha1.NodePool) ctrl.Result {
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(nodepool)})
+	Expect(err).ToNot(HaveOccurred())
+	return result
+}
+
+func getNodePool(ctx context.Context, c client.Client, name string) *hwmgmtv1alpha1.NodePool {
+	nodepool := &hwmgmtv1alpha1.NodePool{}
+	Expect(c.Get(ctx, types.NamespacedName{Name: name, Namespace: testNamespace}, nodepool)).To(Succeed())
+	return nodepool
+}
+
 var _ = Describe("NodePool Controller", func() {
 	Context("When reconciling a resource", func() {
 
@@ -29,4 +173,1395 @@ var _ = Describe("NodePool Controller", func() {
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
 	})
+
+	Context("When the allocated nodes are being provisioned", func() {
+		It("requeues for the provisioning delay rather than blocking", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.InProgress,
+				metav1.ConditionFalse,
+				"Handling creation")
+			r, c := newTestReconciler(newNodelistConfigMap(""), nodepool)
+
+			fakeClock := clocktesting.NewFakeClock(time.Now())
+			hwmgr, err := service.NewHwMgrService().
+				SetClient(c).
+				SetLogger(r.Logger).
+				SetClock(fakeClock).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			r.HwMgr = hwmgr
+
+			start := time.Now()
+			result := reconcileNodePool(ctx, r, nodepool)
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(result.RequeueAfter).To(Equal(10 * time.Second))
+
+			updated := getNodePool(ctx, c, nodepool.Name)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Reason).To(Equal(string(utils.Allocated)))
+			Expect(updated.Status.Properties.NodeNames).To(Equal([]string{"node-a-0"}))
+
+			fakeClock.Step(4 * time.Second)
+			result = reconcileNodePool(ctx, r, nodepool)
+			Expect(result.RequeueAfter).To(Equal(6 * time.Second))
+
+			fakeClock.Step(6 * time.Second)
+			result = reconcileNodePool(ctx, r, nodepool)
+			Expect(result).To(Equal(doNotRequeue()))
+
+			updated = getNodePool(ctx, c, nodepool.Name)
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))).To(BeTrue())
+		})
+
+		It("requeues for the provisioning time estimate of the node", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.InProgress,
+				metav1.ConditionFalse,
+				"Handling creation")
+			cm := newNodelistConfigMap("")
+			cm.Data["resources"] = strings.Replace(testResources,
+				"hostname: node-a-0.localhost", "hostname: node-a-0.localhost\n    provisionTime: 30s", 1)
+			r, c := newTestReconciler(cm, nodepool)
+
+			fakeClock := clocktesting.NewFakeClock(time.Now())
+			hwmgr, err := service.NewHwMgrService().
+				SetClient(c).
+				SetLogger(r.Logger).
+				SetClock(fakeClock).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			r.HwMgr = hwmgr
+
+			Expect(reconcileNodePool(ctx, r, nodepool).RequeueAfter).To(Equal(30 * time.Second))
+
+			// The default delay has no effect on the node
+			fakeClock.Step(10 * time.Second)
+			Expect(reconcileNodePool(ctx, r, nodepool).RequeueAfter).To(Equal(20 * time.Second))
+
+			fakeClock.Step(20 * time.Second)
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			updated := getNodePool(ctx, c, nodepool.Name)
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))).To(BeTrue())
+		})
+	})
+
+	Context("When a NodePool takes several passes to be allocated", func() {
+		It("counts the processing passes until it is provisioned", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			r, c := newTestReconciler(newNodelistConfigMap(""), nodepool)
+
+			fakeClock := clocktesting.NewFakeClock(time.Now())
+			hwmgr, err := service.NewHwMgrService().
+				SetClient(c).
+				SetLogger(r.Logger).
+				SetClock(fakeClock).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			r.HwMgr = hwmgr
+
+			observed := func() uint64 {
+				metric := &dto.Metric{}
+				Expect(allocationReconciles.Write(metric)).To(Succeed())
+				return metric.GetHistogram().GetSampleCount()
+			}
+			before := observed()
+
+			// Admission is not a processing pass
+			reconcileNodePool(ctx, r, nodepool)
+			Expect(getNodePool(ctx, c, nodepool.Name).Annotations).ToNot(HaveKey(utils.AllocationReconcilesAnnotation))
+
+			// One node is allocated per pass, then the nodes are provisioned once the delay elapses
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithShortInterval()))
+			Expect(reconcileNodePool(ctx, r, nodepool).RequeueAfter).To(Equal(10 * time.Second))
+			fakeClock.Step(10 * time.Second)
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+
+			updated := getNodePool(ctx, c, nodepool.Name)
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))).To(BeTrue())
+			Expect(updated.Annotations).To(HaveKeyWithValue(utils.AllocationReconcilesAnnotation, "3"))
+			Expect(observed()).To(Equal(before + 1))
+
+			// The count is final once the NodePool is provisioned
+			reconcileNodePool(ctx, r, nodepool)
+			Expect(getNodePool(ctx, c, nodepool.Name).Annotations).To(HaveKeyWithValue(utils.AllocationReconcilesAnnotation, "3"))
+			Expect(observed()).To(Equal(before + 1))
+		})
+	})
+
+	Context("When a provisioned NodePool carries a trace ID", func() {
+		It("attaches the trace ID as an exemplar to its allocation duration", func() {
+			const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+			nodepool := newNodePool("np1", "cloud-1")
+			nodepool.Annotations = map[string]string{utils.TraceIDAnnotation: traceID}
+
+			observeAllocationDuration(nodepool, 42*time.Second)
+
+			metric := &dto.Metric{}
+			Expect(allocationDuration.Write(metric)).To(Succeed())
+			var exemplar *dto.Exemplar
+			for _, bucket := range metric.GetHistogram().GetBucket() {
+				if bucket.GetExemplar().GetValue() == 42 {
+					exemplar = bucket.GetExemplar()
+				}
+			}
+			Expect(exemplar).ToNot(BeNil())
+			Expect(exemplar.GetLabel()).To(HaveLen(1))
+			Expect(exemplar.GetLabel()[0].GetName()).To(Equal("trace_id"))
+			Expect(exemplar.GetLabel()[0].GetValue()).To(Equal(traceID))
+
+			req := httptest.NewRequest(http.MethodGet, OpenMetricsPath, nil)
+			req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+			rec := httptest.NewRecorder()
+			NewOpenMetricsHandler().ServeHTTP(rec, req)
+			Expect(rec.Body.String()).To(ContainSubstring(`# {trace_id="` + traceID + `"} 42`))
+		})
+	})
+
+	Context("When a NodePool is processed to completion", func() {
+		It("sets the Validated condition at admission and Provisioned at completion", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+			node := newNode("node-a-0", "cloud-1", "master")
+			utils.SetStatusCondition(&node.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.Completed,
+				metav1.ConditionTrue,
+				"Provisioned")
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepool, node)
+
+			// Admission
+			reconcileNodePool(ctx, r, nodepool)
+
+			updated := getNodePool(ctx, c, nodepool.Name)
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(utils.Validated))).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))).To(BeTrue())
+
+			// Completion
+			result := reconcileNodePool(ctx, r, nodepool)
+			Expect(result).To(Equal(doNotRequeue()))
+
+			updated = getNodePool(ctx, c, nodepool.Name)
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(utils.Validated))).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(utils.Configured))).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))).To(BeTrue())
+			Expect(updated.Status.Properties.NodeNames).To(ConsistOf("node-a-0"))
+		})
+	})
+
+	Context("When a NodePool is outside of the plugin namespace", func() {
+		It("rejects it without allocating nodes", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			nodepool.Namespace = "other-namespace"
+			nodepool.Finalizers = nil
+			r, c := newTestReconciler(newNodelistConfigMap(""), nodepool)
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+
+			updated := &hwmgmtv1alpha1.NodePool{}
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(nodepool), updated)).To(Succeed())
+			Expect(updated.Finalizers).To(BeEmpty())
+			condition := meta.FindStatusCondition(updated.Status.Conditions, string(utils.Validated))
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(utils.NamespaceMismatch)))
+			Expect(condition.Message).To(ContainSubstring(testNamespace))
+
+			Expect(r.HwMgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+			nodes := &hwmgmtv1alpha1.NodeList{}
+			Expect(c.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(BeEmpty())
+		})
+	})
+
+	Context("When a periodic resync is configured", func() {
+		It("requeues a provisioned NodePool at the resync interval", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.Completed,
+				metav1.ConditionTrue,
+				"Created")
+
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+			node := newNode("node-a-0", "cloud-1", "master")
+			utils.SetStatusCondition(&node.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.Completed,
+				metav1.ConditionTrue,
+				"Provisioned")
+			r, _ := newTestReconciler(newNodelistConfigMap(allocations), nodepool, node)
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+
+			r.ResyncInterval = 10 * time.Minute
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithCustomInterval(10 * time.Minute)))
+		})
+
+		It("returns a NodePool that is no longer fully allocated to processing", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.Completed,
+				metav1.ConditionTrue,
+				"Created")
+			r, c := newTestReconciler(newNodelistConfigMap(""), nodepool)
+			r.ResyncInterval = 10 * time.Minute
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithShortInterval()))
+
+			condition := meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.InProgress)))
+		})
+	})
+
+	Context("When a provisioned NodePool is scaled up", func() {
+		It("allocates and provisions the additional nodes", func() {
+			ctx := context.Background()
+
+			var resources strings.Builder
+			resources.WriteString("hwprofiles:\n  - profile-a\nnodes:\n")
+			for i := 0; i < 4; i++ {
+				fmt.Fprintf(&resources, "  node-a-%d:\n    hwprofile: profile-a\n    provisionTime: 0s\n", i)
+			}
+			cm := newNodelistConfigMap("")
+			cm.Data["resources"] = resources.String()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			r, c := newTestReconciler(cm, nodepool)
+			fakeClock := clocktesting.NewFakeClock(time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC))
+			r.Clock = fakeClock
+
+			provisioned := func() bool {
+				for i := 0; i < 10; i++ {
+					reconcileNodePool(ctx, r, nodepool)
+					if meta.IsStatusConditionTrue(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+						string(hwmgmtv1alpha1.Provisioned)) {
+						return true
+					}
+				}
+				return false
+			}
+
+			Expect(provisioned()).To(BeTrue())
+			Expect(r.HwMgr.GetNodePoolNodes(ctx, nodepool)).To(Equal([]string{"node-a-0", "node-a-1"}))
+			Expect(getNodePool(ctx, c, nodepool.Name).Annotations).To(
+				HaveKeyWithValue(utils.ProvisionedAtAnnotation, "2024-10-01T12:00:00Z"))
+
+			nodepool = getNodePool(ctx, c, nodepool.Name)
+			nodepool.Spec.NodeGroup[0].Size = 4
+			Expect(c.Update(ctx, nodepool)).To(Succeed())
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithShortInterval()))
+			condition := meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.InProgress)))
+			Expect(condition.Message).To(Equal("Scaling up nodegroups: master"))
+
+			Expect(provisioned()).To(BeTrue())
+			condition = meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.Completed)))
+			Expect(r.HwMgr.GetNodePoolNodes(ctx, nodepool)).To(Equal(
+				[]string{"node-a-0", "node-a-1", "node-a-2", "node-a-3"}))
+		})
+	})
+
+	Context("When a node of a provisioned NodePool is swapped", func() {
+		It("provisions the swapped in node", func() {
+			ctx := context.Background()
+
+			var resources strings.Builder
+			resources.WriteString("hwprofiles:\n  - profile-a\nnodes:\n")
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(&resources, "  node-a-%d:\n    hwprofile: profile-a\n    provisionTime: 0s\n", i)
+			}
+			cm := newNodelistConfigMap("")
+			cm.Data["resources"] = resources.String()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			r, c := newTestReconciler(cm, nodepool)
+
+			provisioned := func() bool {
+				for i := 0; i < 10; i++ {
+					reconcileNodePool(ctx, r, nodepool)
+					if meta.IsStatusConditionTrue(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+						string(hwmgmtv1alpha1.Provisioned)) {
+						return true
+					}
+				}
+				return false
+			}
+
+			Expect(provisioned()).To(BeTrue())
+			nodepool = getNodePool(ctx, c, nodepool.Name)
+			Expect(r.HwMgr.SwapNode(ctx, nodepool, "master", "node-a-0", "node-a-2")).To(Succeed())
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithShortInterval()))
+			condition := meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.InProgress)))
+			Expect(condition.Message).To(Equal("Provisioning newly allocated nodes"))
+
+			Expect(provisioned()).To(BeTrue())
+			Expect(r.HwMgr.IsNodePoolProvisioned(ctx, nodepool)).To(BeTrue())
+			Expect(r.HwMgr.GetNodePoolNodes(ctx, nodepool)).To(ConsistOf("node-a-1", "node-a-2"))
+		})
+	})
+
+	Context("When NodePools are reconciled concurrently", func() {
+		It("provisions each NodePool from distinct nodes", func() {
+			ctx := context.Background()
+
+			var resources strings.Builder
+			resources.WriteString("hwprofiles:\n  - profile-a\nnodes:\n")
+			for i := 0; i < 8; i++ {
+				fmt.Fprintf(&resources, "  node-a-%d:\n    hwprofile: profile-a\n    provisionTime: 0s\n", i)
+			}
+			cm := newNodelistConfigMap("")
+			cm.Data["resources"] = resources.String()
+
+			var pools []client.Object
+			for i := 0; i < 4; i++ {
+				pools = append(pools, newNodePool(fmt.Sprintf("np%d", i), fmt.Sprintf("cloud-%d", i),
+					hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2}))
+			}
+			r, c := newTestReconciler(append(pools, cm)...)
+			r.MaxConcurrentReconciles = 4
+
+			isProvisioned := func(name string) bool {
+				return meta.IsStatusConditionTrue(getNodePool(ctx, c, name).Status.Conditions,
+					string(hwmgmtv1alpha1.Provisioned))
+			}
+
+			// As in the controller, each NodePool is reconciled by one worker at a time, and the workers reconcile
+			// different NodePools in parallel. A NodePool is requeued until it is provisioned, standing in for the
+			// watch events on its updates.
+			q := workqueue.New()
+			for _, nodepool := range pools {
+				q.Add(ctrl.Request{NamespacedName: client.ObjectKeyFromObject(nodepool)})
+			}
+
+			var wg sync.WaitGroup
+			var passes atomic.Int32
+			for i := 0; i < r.MaxConcurrentReconciles; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for {
+						item, shutdown := q.Get()
+						if shutdown {
+							return
+						}
+						req := item.(ctrl.Request)
+						result, err := r.Reconcile(ctx, req)
+						Expect(err).ToNot(HaveOccurred())
+						if (result.Requeue || result.RequeueAfter > 0 || !isProvisioned(req.Name)) &&
+							passes.Add(1) < 200 {
+							q.Add(item)
+						}
+						q.Done(item)
+					}
+				}()
+			}
+
+			provisioned := func() bool {
+				for _, nodepool := range pools {
+					if !isProvisioned(nodepool.GetName()) {
+						return false
+					}
+				}
+				return true
+			}
+			Eventually(provisioned).WithTimeout(10 * time.Second).Should(BeTrue())
+			q.ShutDownWithDrain()
+			wg.Wait()
+
+			seen := make(map[string]string)
+			for _, nodepool := range pools {
+				updated := getNodePool(ctx, c, nodepool.GetName())
+				Expect(updated.Status.Properties.NodeNames).To(HaveLen(2))
+				for _, nodename := range updated.Status.Properties.NodeNames {
+					Expect(seen).ToNot(HaveKey(nodename), "node %s allocated to both %s and %s",
+						nodename, seen[nodename], updated.Name)
+					seen[nodename] = updated.Name
+				}
+			}
+		})
+	})
+
+	Context("When a NodePool is being allocated", func() {
+		It("reports the allocation progress of each nodegroup", func() {
+			ctx := context.Background()
+
+			var resources strings.Builder
+			resources.WriteString("hwprofiles:\n  - profile-a\n  - profile-b\nnodes:\n")
+			for i := 0; i < 4; i++ {
+				fmt.Fprintf(&resources, "  node-a-%d:\n    hwprofile: profile-a\n    provisionTime: 0s\n", i)
+			}
+			resources.WriteString("  node-b-0:\n    hwprofile: profile-b\n    provisionTime: 0s\n")
+			cm := newNodelistConfigMap("")
+			cm.Data["resources"] = resources.String()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 4},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1})
+			r, c := newTestReconciler(cm, nodepool)
+
+			// Each pass allocates a node to each nodegroup that needs one, for as many rounds as there are nodegroups
+			var messages []string
+			for i := 0; i < 10; i++ {
+				reconcileNodePool(ctx, r, nodepool)
+				condition := meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+					string(utils.AllocationProgress))
+				if condition == nil {
+					continue
+				}
+				if len(messages) == 0 || messages[len(messages)-1] != condition.Message {
+					messages = append(messages, condition.Message)
+				}
+				if condition.Status == metav1.ConditionTrue {
+					Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.Completed)))
+					break
+				}
+				Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.InProgress)))
+			}
+
+			Expect(messages).To(Equal([]string{
+				"master: 2/4 allocated, worker: 1/1 allocated",
+				"master: 4/4 allocated, worker: 1/1 allocated",
+			}))
+		})
+	})
+
+	Context("When an event recorder is set", func() {
+		It("records the lifecycle transitions of the NodePool", func() {
+			ctx := context.Background()
+
+			cm := newNodelistConfigMap("")
+			cm.Data["resources"] = `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    provisionTime: 0s
+  node-a-1:
+    hwprofile: profile-a
+    provisionTime: 0s
+`
+			np1 := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			r, c := newTestReconciler(cm, np1, np2)
+
+			recorder := record.NewFakeRecorder(20)
+			r.Recorder = recorder
+			hwmgr, err := service.NewHwMgrService().
+				SetClient(c).
+				SetLogger(r.Logger).
+				SetEventRecorder(recorder).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			r.HwMgr = hwmgr
+
+			reasons := func() (result []string) {
+				for len(recorder.Events) > 0 {
+					result = append(result, strings.Fields(<-recorder.Events)[1])
+				}
+				return
+			}
+
+			for i := 0; i < 10 && !meta.IsStatusConditionTrue(getNodePool(ctx, c, np1.Name).Status.Conditions,
+				string(hwmgmtv1alpha1.Provisioned)); i++ {
+				reconcileNodePool(ctx, r, np1)
+			}
+			Expect(reasons()).To(Equal([]string{
+				EventReasonProcessing,
+				service.EventReasonAllocationPlanned,
+				service.EventReasonNodeAllocated,
+				service.EventReasonAllocationPlanned,
+				service.EventReasonNodeAllocated,
+				EventReasonProvisioned,
+			}))
+
+			// No nodes remain free for the second NodePool
+			reconcileNodePool(ctx, r, np2)
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning " + EventReasonInsufficientResources + " ")))
+		})
+	})
+
+	Context("When a NodePool has tentative allocations", func() {
+		It("reports when the hold on its nodes expires", func() {
+			ctx := context.Background()
+
+			cm := newNodelistConfigMap("")
+			cm.Data["resources"] = `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    provisionTime: 0s
+`
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			nodepool.Annotations = map[string]string{utils.TentativeAllocationTTLAnnotation: "10m"}
+			r, c := newTestReconciler(cm, nodepool)
+
+			fakeClock := clocktesting.NewFakeClock(time.Now().Truncate(time.Second))
+			hwmgr, err := service.NewHwMgrService().
+				SetClient(c).
+				SetLogger(r.Logger).
+				SetClock(fakeClock).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			r.HwMgr = hwmgr
+			r.Clock = fakeClock
+
+			var condition *metav1.Condition
+			for i := 0; i < 10; i++ {
+				reconcileNodePool(ctx, r, nodepool)
+				condition = meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+					string(hwmgmtv1alpha1.Provisioned))
+				if condition != nil && condition.Reason == string(utils.Allocated) &&
+					strings.Contains(condition.Message, "tentative") {
+					break
+				}
+			}
+
+			message := "Nodes provisioned, waiting for tentative allocations to be confirmed, " +
+				"held until " + fakeClock.Now().Add(10*time.Minute).UTC().Format(time.RFC3339)
+			Expect(condition.Message).To(Equal(message))
+
+			// The expiry does not move with the time of later reconciles
+			fakeClock.Step(3 * time.Minute)
+			reconcileNodePool(ctx, r, nodepool)
+			condition = meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Message).To(Equal(message))
+		})
+	})
+
+	Context("When a summary logger is set", func() {
+		It("logs a JSON summary of each completed allocation and release", func() {
+			ctx := context.Background()
+
+			cm := newNodelistConfigMap("")
+			cm.Data["resources"] = `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    provisionTime: 0s
+  node-a-1:
+    hwprofile: profile-a
+    provisionTime: 0s
+`
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			r, c := newTestReconciler(cm, nodepool)
+			var output bytes.Buffer
+			r.SummaryLogger = slog.New(slog.NewJSONHandler(&output, nil))
+
+			summaries := func() (result []map[string]interface{}) {
+				for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+					if line == "" {
+						continue
+					}
+					summary := make(map[string]interface{})
+					Expect(json.Unmarshal([]byte(line), &summary)).To(Succeed())
+					result = append(result, summary)
+				}
+				output.Reset()
+				return
+			}
+
+			for i := 0; i < 10 && !meta.IsStatusConditionTrue(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(hwmgmtv1alpha1.Provisioned)); i++ {
+				reconcileNodePool(ctx, r, nodepool)
+			}
+			// A further reconcile of the provisioned NodePool is not summarized again
+			reconcileNodePool(ctx, r, nodepool)
+
+			allocation := summaries()
+			Expect(allocation).To(HaveLen(1))
+			Expect(allocation[0]).To(HaveKeyWithValue("msg", "NodePool allocation completed"))
+			Expect(allocation[0]).To(HaveKeyWithValue("operation", "allocation"))
+			Expect(allocation[0]).To(HaveKeyWithValue("cloudID", "cloud-1"))
+			Expect(allocation[0]).To(HaveKeyWithValue("nodepool", "np1"))
+			Expect(allocation[0]).To(HaveKeyWithValue("count", BeEquivalentTo(2)))
+			Expect(allocation[0]).To(HaveKeyWithValue("nodes", ConsistOf("node-a-0", "node-a-1")))
+			Expect(allocation[0]).To(HaveKey("durationSeconds"))
+
+			Expect(c.Delete(ctx, getNodePool(ctx, c, nodepool.Name))).To(Succeed())
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+
+			release := summaries()
+			Expect(release).To(HaveLen(1))
+			Expect(release[0]).To(HaveKeyWithValue("operation", "release"))
+			Expect(release[0]).To(HaveKeyWithValue("cloudID", "cloud-1"))
+			Expect(release[0]).To(HaveKeyWithValue("count", BeEquivalentTo(2)))
+			Expect(release[0]).To(HaveKeyWithValue("nodes", ConsistOf("node-a-0", "node-a-1")))
+			Expect(release[0]).To(HaveKey("durationSeconds"))
+		})
+	})
+
+	Context("When the NodePool allocation changes", func() {
+		It("summarizes the current allocation counts in an annotation", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.InProgress,
+				metav1.ConditionFalse,
+				"Handling creation")
+
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepool, newNode("node-a-0", "cloud-1", "master"))
+
+			nodepool = getNodePool(ctx, c, nodepool.Name)
+			Expect(r.updateAllocationSummary(ctx, nodepool)).To(Succeed())
+			Expect(getNodePool(ctx, c, nodepool.Name).Annotations).To(
+				HaveKeyWithValue(utils.AllocationSummaryAnnotation, "master=1/1,worker=0/1"))
+
+			// The summary is left unchanged if the allocation has not changed
+			resourceVersion := getNodePool(ctx, c, nodepool.Name).ResourceVersion
+			Expect(r.updateAllocationSummary(ctx, nodepool)).To(Succeed())
+			Expect(getNodePool(ctx, c, nodepool.Name).ResourceVersion).To(Equal(resourceVersion))
+
+			Expect(r.HwMgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			Expect(r.updateAllocationSummary(ctx, nodepool)).To(Succeed())
+			Expect(getNodePool(ctx, c, nodepool.Name).Annotations).To(
+				HaveKeyWithValue(utils.AllocationSummaryAnnotation, "master=0/1,worker=0/1"))
+		})
+
+		It("is updated by each reconcile", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+			node := newNode("node-a-0", "cloud-1", "master")
+			utils.SetStatusCondition(&node.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.Completed,
+				metav1.ConditionTrue,
+				"Provisioned")
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepool, node)
+
+			reconcileNodePool(ctx, r, nodepool)
+			reconcileNodePool(ctx, r, nodepool)
+
+			updated := getNodePool(ctx, c, nodepool.Name)
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))).To(BeTrue())
+			Expect(updated.Annotations).To(HaveKeyWithValue(utils.AllocationSummaryAnnotation, "master=1/1"))
+		})
+	})
+
+	Context("When the NodePool is reconciled successfully", func() {
+		It("records the time of the last successful reconcile", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			r, c := newTestReconciler(newNodelistConfigMap(""), nodepool)
+
+			start := time.Date(2024, time.September, 1, 12, 0, 0, 0, time.UTC)
+			fakeClock := clocktesting.NewFakeClock(start)
+			r.Clock = fakeClock
+			reconcileStaleness.setClock(fakeClock)
+			DeferCleanup(reconcileStaleness.setClock, clock.RealClock{})
+
+			reconcileTime := func() time.Time {
+				condition := meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+					string(utils.Reconciled))
+				Expect(condition).ToNot(BeNil())
+				Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+				return condition.LastTransitionTime.UTC()
+			}
+
+			reconcileNodePool(ctx, r, nodepool)
+			Expect(reconcileTime()).To(Equal(start))
+
+			fakeClock.Step(time.Minute)
+			reconcileNodePool(ctx, r, nodepool)
+			Expect(reconcileTime()).To(Equal(start.Add(time.Minute)))
+
+			// The staleness is measured against the same clock
+			key := client.ObjectKeyFromObject(nodepool)
+			fakeClock.Step(30 * time.Second)
+			staleness, tracked := reconcileStaleness.Staleness(key)
+			Expect(tracked).To(BeTrue())
+			Expect(staleness).To(Equal(30 * time.Second))
+
+			Expect(c.Delete(ctx, getNodePool(ctx, c, nodepool.Name))).To(Succeed())
+			reconcileNodePool(ctx, r, nodepool)
+			_, tracked = reconcileStaleness.Staleness(key)
+			Expect(tracked).To(BeFalse())
+		})
+
+		It("is not triggered again by its own bookkeeping", func() {
+			nodepool := newNodePool("np1", "cloud-1")
+			updated := nodepool.DeepCopy()
+			updated.Annotations = map[string]string{
+				utils.AllocationSummaryAnnotation: "master=1/1",
+			}
+			meta.SetStatusCondition(&updated.Status.Conditions, metav1.Condition{
+				Type:   string(utils.Reconciled),
+				Status: metav1.ConditionTrue,
+				Reason: string(hwmgmtv1alpha1.Completed),
+			})
+			updated.ResourceVersion = "2"
+
+			p := ignoreBookkeepingUpdates()
+			Expect(p.Update(event.UpdateEvent{ObjectOld: nodepool, ObjectNew: updated})).To(BeFalse())
+
+			updated.Annotations[utils.PausedAnnotation] = "true"
+			Expect(p.Update(event.UpdateEvent{ObjectOld: nodepool, ObjectNew: updated})).To(BeTrue())
+		})
+	})
+
+	Context("When the NodePool is deleted", func() {
+		It("keeps its finalizer until the Node CRs are gone", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+			// The Node is held by another finalizer, so it is deleted slowly
+			node := newNode("node-a-0", "cloud-1", "master")
+			node.Finalizers = []string{"example.com/slow-delete"}
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepool, node)
+
+			Expect(c.Delete(ctx, getNodePool(ctx, c, nodepool.Name))).To(Succeed())
+
+			for i := 0; i < 3; i++ {
+				Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithCustomInterval(finalizerRequeueInterval)))
+				Expect(getNodePool(ctx, c, nodepool.Name).Finalizers).To(ContainElement(pluginFinalizer))
+				Expect(r.HwMgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0"}))
+			}
+
+			node = &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.DeletionTimestamp).ToNot(BeNil())
+			node.Finalizers = nil
+			Expect(c.Update(ctx, node)).To(Succeed())
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			err := c.Get(ctx, types.NamespacedName{Name: nodepool.Name, Namespace: testNamespace}, &hwmgmtv1alpha1.NodePool{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Context("When the finalizer of an allocated NodePool is removed out-of-band", func() {
+		var (
+			ctx      context.Context
+			nodepool *hwmgmtv1alpha1.NodePool
+			r        *NodePoolReconciler
+			c        client.Client
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+
+			nodepool = newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+			r, c = newTestReconciler(newNodelistConfigMap(allocations), nodepool, newNode("node-a-0", "cloud-1", "master"))
+			reconcileNodePool(ctx, r, nodepool)
+
+			updated := getNodePool(ctx, c, nodepool.Name)
+			updated.Finalizers = nil
+			Expect(c.Update(ctx, updated)).To(Succeed())
+		})
+
+		It("restores the finalizer on the next reconcile", func() {
+			reconcileNodePool(ctx, r, nodepool)
+			Expect(getNodePool(ctx, c, nodepool.Name).Finalizers).To(ContainElement(pluginFinalizer))
+		})
+
+		It("restores the finalizer once the grace period has elapsed", func() {
+			// The removal is recorded to the second
+			fakeClock := clocktesting.NewFakeClock(time.Now().Truncate(time.Second))
+			r.Clock = fakeClock
+			r.FinalizerRestoreGrace = time.Minute
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithCustomInterval(time.Minute)))
+			updated := getNodePool(ctx, c, nodepool.Name)
+			Expect(updated.Finalizers).To(BeEmpty())
+			Expect(updated.Annotations).To(HaveKey(utils.FinalizerRemovedAtAnnotation))
+
+			fakeClock.Step(30 * time.Second)
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithCustomInterval(30 * time.Second)))
+			Expect(getNodePool(ctx, c, nodepool.Name).Finalizers).To(BeEmpty())
+
+			fakeClock.Step(30 * time.Second)
+			reconcileNodePool(ctx, r, nodepool)
+			updated = getNodePool(ctx, c, nodepool.Name)
+			Expect(updated.Finalizers).To(ContainElement(pluginFinalizer))
+			Expect(updated.Annotations).ToNot(HaveKey(utils.FinalizerRemovedAtAnnotation))
+		})
+	})
+
+	Context("When two NodePools share a CloudID", func() {
+		It("rejects the newer one, and deletes it without releasing the nodes of the older one", func() {
+			ctx := context.Background()
+
+			older := newNodePool("np2", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			older.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+			newer := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			newer.CreationTimestamp = metav1.NewTime(time.Now())
+
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), older, newer,
+				newNode("node-a-0", "cloud-1", "master"))
+
+			Expect(reconcileNodePool(ctx, r, newer)).To(Equal(requeueWithLongInterval()))
+			updated := getNodePool(ctx, c, newer.Name)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, string(utils.Validated))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(utils.DuplicateCloudID)))
+			Expect(condition.Message).To(Equal("CloudID cloud-1 is already used by NodePool np2"))
+			condition = meta.FindStatusCondition(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.Failed)))
+
+			Expect(c.Delete(ctx, updated)).To(Succeed())
+			Expect(reconcileNodePool(ctx, r, newer)).To(Equal(doNotRequeue()))
+			err := c.Get(ctx, types.NamespacedName{Name: newer.Name, Namespace: testNamespace}, &hwmgmtv1alpha1.NodePool{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+
+			Expect(r.HwMgr.GetAllocatedNodes(ctx, older)).To(ConsistOf("node-a-0"))
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace},
+				&hwmgmtv1alpha1.Node{})).To(Succeed())
+		})
+	})
+
+	Context("When allocated nodes are missing their Node CRs", func() {
+		It("recreates the Node CRs and bmc-secrets, and provisions them again", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.Completed,
+				metav1.ConditionTrue,
+				"Created")
+
+			// The controller was restarted after recording the allocation of node-a-1, but before creating its Node CR
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+        - node-a-1
+`
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepool, newNode("node-a-0", "cloud-1", "master"))
+			reconcileNodePool(ctx, r, nodepool)
+
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-1", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Spec.NodePool).To(Equal("cloud-1"))
+			Expect(node.Spec.GroupName).To(Equal("master"))
+			Expect(node.Finalizers).To(ContainElement(service.NodeFinalizer))
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-1-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})).To(Succeed())
+
+			Expect(meta.IsStatusConditionTrue(node.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))).To(BeFalse())
+			Expect(meta.IsStatusConditionTrue(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(hwmgmtv1alpha1.Provisioned))).To(BeFalse())
+
+			// The allocation record is unchanged
+			Expect(r.HwMgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0", "node-a-1"}))
+		})
+	})
+
+	Context("When the node inventory changes", func() {
+		It("debounces the re-enqueue of the NodePools", func() {
+			ctx := context.Background()
+
+			np1 := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			cm := newNodelistConfigMap("")
+			r, _ := newTestReconciler(cm, np1, np2)
+
+			fakeClock := clocktesting.NewFakeClock(time.Now())
+			q := workqueue.NewRateLimitingQueueWithDelayingInterface(
+				workqueue.NewDelayingQueueWithCustomClock(fakeClock, "test"), workqueue.DefaultControllerRateLimiter())
+			defer q.ShutDown()
+
+			eventHandler := r.inventoryChangeHandler()
+			update := func(key, value string) {
+				updated := cm.DeepCopy()
+				updated.Data[key] = value
+				eventHandler.Update(ctx, event.UpdateEvent{ObjectOld: cm, ObjectNew: updated}, q)
+				cm = updated
+			}
+
+			// Updates of the allocations by the plugin itself are ignored
+			update("allocations", "clouds: []")
+
+			// A burst of inventory edits
+			for i := 0; i < 5; i++ {
+				update("resources", fmt.Sprintf("%s\n# edit %d\n", testResources, i))
+				fakeClock.Step(500 * time.Millisecond)
+			}
+			Consistently(q.Len, 100*time.Millisecond).Should(BeZero())
+
+			fakeClock.Step(defaultInventoryDebounce)
+			Eventually(q.Len).Should(Equal(2))
+			Consistently(q.Len, 100*time.Millisecond).Should(Equal(2))
+		})
+	})
+
+	Context("When the NodePool is paused", func() {
+		It("neither allocates nor releases nodes until resumed", func() {
+			ctx := context.Background()
+
+			fakeClock := clocktesting.NewFakeClock(time.Now().Truncate(time.Second))
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			nodepool.CreationTimestamp = metav1.NewTime(fakeClock.Now())
+			nodepool.Annotations = map[string]string{
+				utils.PausedAnnotation:             "true",
+				utils.AllocationDeadlineAnnotation: "1s",
+			}
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.InProgress,
+				metav1.ConditionFalse,
+				"Handling creation")
+
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepool, newNode("node-a-0", "cloud-1", "master"))
+			r.Clock = fakeClock
+			fakeClock.Step(time.Minute)
+
+			result := reconcileNodePool(ctx, r, nodepool)
+			Expect(result).To(Equal(doNotRequeue()))
+
+			updated := getNodePool(ctx, c, nodepool.Name)
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(utils.Paused))).To(BeTrue())
+			condition := meta.FindStatusCondition(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.InProgress)))
+
+			// The partial allocation is neither released by the exceeded deadline, nor completed
+			allocatedNodes, err := r.HwMgr.GetAllocatedNodes(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(allocatedNodes).To(Equal([]string{"node-a-0"}))
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, &hwmgmtv1alpha1.Node{})).To(Succeed())
+
+			// Resuming the NodePool lets the deadline take effect
+			delete(updated.Annotations, utils.PausedAnnotation)
+			Expect(c.Update(ctx, updated)).To(Succeed())
+			reconcileNodePool(ctx, r, nodepool)
+
+			updated = getNodePool(ctx, c, nodepool.Name)
+			Expect(meta.IsStatusConditionFalse(updated.Status.Conditions, string(utils.Paused))).To(BeTrue())
+			condition = meta.FindStatusCondition(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.Failed)))
+		})
+	})
+
+	Context("When a NodePool cannot be admitted for a lack of nodes", func() {
+		It("retries the admission periodically while the free nodes are short", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+
+			// One of the two nodes of the profile is allocated to another pool
+			allocations := `
+clouds:
+  - cloudID: cloud-2
+    nodegroups:
+      master:
+        - node-a-0
+`
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepool, newNode("node-a-0", "cloud-2", "master"))
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithLongInterval()))
+			updated := getNodePool(ctx, c, nodepool.Name)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, string(utils.Validated))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(utils.InsufficientResources)))
+			condition = meta.FindStatusCondition(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Reason).To(Equal(string(utils.InsufficientResources)))
+
+			// The admission is retried rather than the NodePool being processed
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithLongInterval()))
+			Expect(r.HwMgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+
+			// Once the node is freed, the NodePool is admitted
+			other := newNodePool("np2", "cloud-2", hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(r.HwMgr.ReleaseNodePool(ctx, other)).To(Succeed())
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			updated = getNodePool(ctx, c, nodepool.Name)
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(utils.Validated))).To(BeTrue())
+		})
+
+		It("rejects a request exceeding the capacity of the profile without requeueing", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 3})
+			r, c := newTestReconciler(newNodelistConfigMap(""), nodepool)
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			updated := getNodePool(ctx, c, nodepool.Name)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, string(utils.Validated))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(utils.ExceedsCapacity)))
+			Expect(condition.Message).To(ContainSubstring("hardware profile profile-a has only 2 node(s)"))
+			condition = meta.FindStatusCondition(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.Failed)))
+
+			// A further reconcile, such as on an inventory change, leaves the NodePool alone
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			Expect(r.HwMgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+		})
+
+		It("does not retry a terminal failure until the spec of the NodePool changes", func() {
+			ctx := context.Background()
+
+			cm := newNodelistConfigMap("")
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 3})
+			nodepool.Generation = 1
+			r, c := newTestReconciler(cm, nodepool)
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			condition := meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(utils.Validated))
+			Expect(condition.Reason).To(Equal(string(utils.ExceedsCapacity)))
+			Expect(condition.ObservedGeneration).To(Equal(int64(1)))
+
+			// Growing the inventory would satisfy the request, but the failure is terminal for this generation
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(cm), cm)).To(Succeed())
+			cm.Data["resources"] = `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+  node-a-1:
+    hwprofile: profile-a
+  node-a-2:
+    hwprofile: profile-a
+`
+			Expect(c.Update(ctx, cm)).To(Succeed())
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			Expect(r.HwMgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+			condition = meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(utils.Validated))
+			Expect(condition.Reason).To(Equal(string(utils.ExceedsCapacity)))
+
+			updated := getNodePool(ctx, c, nodepool.Name)
+			updated.Spec.NodeGroup[0].Size = 2
+			updated.Generation = 2
+			Expect(c.Update(ctx, updated)).To(Succeed())
+			reconcileNodePool(ctx, r, nodepool)
+			Expect(meta.IsStatusConditionTrue(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(utils.Validated))).To(BeTrue())
+		})
+
+		It("rejects a request for an unknown hardware profile without requeueing", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-bogus", Size: 1})
+			r, c := newTestReconciler(newNodelistConfigMap(""), nodepool)
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			updated := getNodePool(ctx, c, nodepool.Name)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, string(utils.Validated))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(utils.UnknownProfile)))
+			condition = meta.FindStatusCondition(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.Failed)))
+			Expect(condition.Message).To(Equal(
+				`Creation request failed: nodegroup master requests unknown hardware profile "profile-bogus"`))
+		})
+
+		It("re-runs the admission of a request for a cordoned hardware profile once it is uncordoned", func() {
+			ctx := context.Background()
+
+			cm := newNodelistConfigMap("")
+			cm.Data["resources"] = testResources + "cordonedProfiles:\n  - profile-a\n"
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			r, c := newTestReconciler(cm, nodepool)
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			condition := meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(utils.Validated))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(utils.ProfileCordoned)))
+
+			// Once uncordoned, the NodePool is admitted before any nodes are allocated to it
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(cm), cm)).To(Succeed())
+			cm.Data["resources"] = testResources
+			Expect(c.Update(ctx, cm)).To(Succeed())
+			reconcileNodePool(ctx, r, nodepool)
+			Expect(meta.IsStatusConditionTrue(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(utils.Validated))).To(BeTrue())
+		})
+
+		It("rejects a malformed spec without requeueing, rather than completing with no nodes", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 0})
+			nodepool.Generation = 1
+			r, c := newTestReconciler(newNodelistConfigMap(""), nodepool)
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			updated := getNodePool(ctx, c, nodepool.Name)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, string(utils.Validated))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(utils.InvalidSpec)))
+			Expect(condition.ObservedGeneration).To(Equal(int64(1)))
+			condition = meta.FindStatusCondition(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.Failed)))
+			Expect(condition.Message).To(Equal(
+				"Creation request failed: nodegroup master has size 0, which must be positive"))
+
+			// A further reconcile leaves the NodePool failed, rather than processing it
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(doNotRequeue()))
+			updated = getNodePool(ctx, c, nodepool.Name)
+			Expect(meta.IsStatusConditionTrue(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))).To(BeFalse())
+			Expect(r.HwMgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+		})
+	})
+
+	Context("When several NodePools are waiting for the same hardware profile", func() {
+		It("reports the position of each in the queue, in order of creation", func() {
+			ctx := context.Background()
+
+			// Both nodes of the profile are allocated to another pool
+			allocations := `
+clouds:
+  - cloudID: cloud-0
+    nodegroups:
+      master:
+        - node-a-0
+        - node-a-1
+`
+			now := time.Now()
+			var nodepools []*hwmgmtv1alpha1.NodePool
+			for i, name := range []string{"np-c", "np-a", "np-b"} {
+				nodepool := newNodePool(name, fmt.Sprintf("cloud-%d", i+1),
+					hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+				nodepool.CreationTimestamp = metav1.NewTime(now.Add(time.Duration(i) * time.Minute))
+				nodepools = append(nodepools, nodepool)
+			}
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepools[0], nodepools[1], nodepools[2],
+				newNode("node-a-0", "cloud-0", "master"), newNode("node-a-1", "cloud-0", "master"))
+
+			// The positions are settled once each pool has been found waiting, newest first to make it count
+			for pass := 0; pass < 2; pass++ {
+				for i := len(nodepools) - 1; i >= 0; i-- {
+					Expect(reconcileNodePool(ctx, r, nodepools[i])).To(Equal(requeueWithLongInterval()))
+				}
+			}
+
+			for i, nodepool := range nodepools {
+				updated := getNodePool(ctx, c, nodepool.Name)
+				condition := meta.FindStatusCondition(updated.Status.Conditions, string(utils.Queued))
+				Expect(condition).ToNot(BeNil())
+				Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+				Expect(condition.Message).To(Equal(
+					fmt.Sprintf("Position %d of 3 in the queue for hardware profile profile-a", i+1)))
+			}
+
+			// Once a node is freed, the oldest pool is admitted and leaves the queue
+			other := newNodePool("np0", "cloud-0", hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			Expect(r.HwMgr.ReleaseNodePool(ctx, other)).To(Succeed())
+			Expect(reconcileNodePool(ctx, r, nodepools[0])).To(Equal(doNotRequeue()))
+			updated := getNodePool(ctx, c, nodepools[0].Name)
+			Expect(meta.FindStatusCondition(updated.Status.Conditions, string(utils.Queued))).To(BeNil())
+		})
+	})
+
+	Context("When the capacity is exhausted", func() {
+		It("requeues with the long interval rather than hot-looping", func() {
+			ctx := context.Background()
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.InProgress,
+				metav1.ConditionFalse,
+				"Handling creation")
+
+			// Both nodes of the profile are allocated to another pool
+			allocations := `
+clouds:
+  - cloudID: cloud-2
+    nodegroups:
+      master:
+        - node-a-0
+        - node-a-1
+`
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepool,
+				newNode("node-a-0", "cloud-2", "master"), newNode("node-a-1", "cloud-2", "master"))
+
+			Expect(reconcileNodePool(ctx, r, nodepool)).To(Equal(requeueWithLongInterval()))
+
+			condition := meta.FindStatusCondition(getNodePool(ctx, c, nodepool.Name).Status.Conditions,
+				string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(utils.InsufficientResources)))
+			Expect(condition.Message).To(ContainSubstring("not enough free resources in group profile-a"))
+		})
+	})
+
+	Context("When the NodePool has an allocation deadline", func() {
+		It("fails and releases the partial allocation once the deadline is exceeded", func() {
+			ctx := context.Background()
+
+			fakeClock := clocktesting.NewFakeClock(time.Now().Truncate(time.Second))
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 3})
+			nodepool.CreationTimestamp = metav1.NewTime(fakeClock.Now())
+			nodepool.Annotations = map[string]string{utils.AllocationDeadlineAnnotation: "1s"}
+			utils.SetStatusCondition(&nodepool.Status.Conditions,
+				hwmgmtv1alpha1.Provisioned,
+				hwmgmtv1alpha1.InProgress,
+				metav1.ConditionFalse,
+				"Handling creation")
+
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+			r, c := newTestReconciler(newNodelistConfigMap(allocations), nodepool, newNode("node-a-0", "cloud-1", "master"))
+			r.Clock = fakeClock
+
+			// The deadline is measured from the creation of the NodePool by the reconciler's clock
+			exceeded, remaining, err := r.handleAllocationDeadline(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exceeded).To(BeFalse())
+			Expect(remaining).To(Equal(time.Second))
+
+			fakeClock.Step(2 * time.Second)
+			result := reconcileNodePool(ctx, r, nodepool)
+			Expect(result).To(Equal(doNotRequeue()))
+
+			updated := getNodePool(ctx, c, nodepool.Name)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+			Expect(condition).ToNot(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(hwmgmtv1alpha1.Failed)))
+			Expect(condition.Message).To(ContainSubstring("deadline"))
+			Expect(updated.Status.Properties.NodeNames).To(BeEmpty())
+
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "nodelist", Namespace: testNamespace}, cm)).To(Succeed())
+			Expect(cm.Data["allocations"]).ToNot(ContainSubstring("node-a-0"))
+
+			node := &hwmgmtv1alpha1.Node{}
+			err = c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, node)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
 })
@@ -0,0 +1,83 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hardwaremanagement
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/service"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// waitingForNodes reports whether a NodePool is waiting for free nodes, whether to be admitted or to complete its
+// allocation
+func waitingForNodes(nodepool *hwmgmtv1alpha1.NodePool) bool {
+	condition := meta.FindStatusCondition(nodepool.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+	return nodepool.GetDeletionTimestamp() == nil && condition != nil && condition.Status == metav1.ConditionFalse &&
+		condition.Reason == string(utils.InsufficientResources)
+}
+
+// requestsProfile reports whether any nodegroup of a NodePool requests the specified hardware profile
+func requestsProfile(nodepool *hwmgmtv1alpha1.NodePool, hwprofile string) bool {
+	return slices.ContainsFunc(nodepool.Spec.NodeGroup, func(nodegroup hwmgmtv1alpha1.NodeGroup) bool {
+		return nodegroup.HwProfile == hwprofile
+	})
+}
+
+// updateQueuePosition sets the Queued condition of a NodePool that is short of free nodes to its position among the
+// NodePools waiting for nodes of the contested hardware profile, the oldest first, with ties broken by name. The
+// condition is removed once the NodePool is no longer short of nodes. The positions of the other NodePools are updated
+// as they are reconciled in turn.
+func (r *NodePoolReconciler) updateQueuePosition(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool,
+	insufficient *service.InsufficientResourcesError) error {
+	if insufficient == nil {
+		meta.RemoveStatusCondition(&nodepool.Status.Conditions, string(utils.Queued))
+		return nil
+	}
+
+	nodepools := &hwmgmtv1alpha1.NodePoolList{}
+	if err := r.Client.List(ctx, nodepools, client.InNamespace(nodepool.Namespace)); err != nil {
+		return fmt.Errorf("failed to list NodePools: %w", err)
+	}
+
+	position, waiting := 1, 1
+	for i := range nodepools.Items {
+		other := &nodepools.Items[i]
+		if other.Name == nodepool.Name || !waitingForNodes(other) || !requestsProfile(other, insufficient.HwProfile) {
+			continue
+		}
+		waiting++
+		if other.CreationTimestamp.Before(&nodepool.CreationTimestamp) ||
+			(other.CreationTimestamp.Equal(&nodepool.CreationTimestamp) && other.Name < nodepool.Name) {
+			position++
+		}
+	}
+
+	utils.SetStatusCondition(&nodepool.Status.Conditions,
+		utils.Queued,
+		utils.InsufficientResources,
+		metav1.ConditionTrue,
+		fmt.Sprintf("Position %d of %d in the queue for hardware profile %s", position, waiting, insufficient.HwProfile))
+
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hardwaremanagement
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+// Operations summarized on the summary logger
+const (
+	summaryOperationAllocation = "allocation"
+	summaryOperationRelease    = "release"
+)
+
+// logSummary writes a single line summarizing a completed allocation or release of a NodePool to the summary logger,
+// if one is set. The duration is measured from the creation of the NodePool for an allocation, and from its deletion
+// for a release.
+func (r *NodePoolReconciler) logSummary(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool, operation string,
+	nodenames []string, duration time.Duration) {
+	if r.SummaryLogger == nil {
+		return
+	}
+
+	r.SummaryLogger.InfoContext(ctx, "NodePool "+operation+" completed",
+		slog.String("operation", operation),
+		slog.String("cloudID", nodepool.Spec.CloudID),
+		slog.String("nodepool", nodepool.Name),
+		slog.Int("count", len(nodenames)),
+		slog.Any("nodes", nodenames),
+		slog.Float64("durationSeconds", duration.Seconds()))
+}
@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Annotations used to tune the handling of a NodePool
+const (
+	AnnotationPrefix = "oran-hwmgr/"
+
+	// AllocationDeadlineAnnotation is the maximum duration (e.g. "10m") from NodePool creation for it to be fully
+	// allocated, after which the allocation fails and any allocated nodes are released
+	AllocationDeadlineAnnotation = AnnotationPrefix + "allocation-deadline"
+
+	// PausedAnnotation freezes the reconciliation of a NodePool while set to "true"
+	PausedAnnotation = AnnotationPrefix + "paused"
+
+	// DebugAnnotation enables a detailed trace of the allocation decisions for a NodePool while set to "true"
+	DebugAnnotation = AnnotationPrefix + "debug"
+
+	// AllocationSummaryAnnotation is set by the plugin to summarize the allocated and requested node counts for each
+	// nodegroup of a NodePool (e.g. "master=3/3,worker=1/2")
+	AllocationSummaryAnnotation = AnnotationPrefix + "allocation-summary"
+
+	// AllocationReconcilesAnnotation is set by the plugin to the number of processing passes taken by a NodePool to
+	// be fully allocated and provisioned. It counts up while the NodePool is processed, and is final once Provisioned.
+	AllocationReconcilesAnnotation = AnnotationPrefix + "allocation-reconciles"
+
+	// ProvisionedAtAnnotation is set by the plugin to the time (RFC 3339) at which a NodePool was first provisioned.
+	// The allocation deadline no longer applies once it is set, so that topping up the NodePool does not release it.
+	ProvisionedAtAnnotation = AnnotationPrefix + "provisioned-at"
+
+	// FinalizerRemovedAtAnnotation is set by the plugin to the time (RFC 3339) at which it found the finalizer of a
+	// NodePool with allocated nodes removed out-of-band, while it waits for the grace period before restoring it
+	FinalizerRemovedAtAnnotation = AnnotationPrefix + "finalizer-removed-at"
+
+	// TraceIDAnnotation is the W3C trace ID (32 lowercase hex digits) of the request that created a NodePool, which is
+	// attached as an exemplar to its allocation duration metric
+	TraceIDAnnotation = AnnotationPrefix + "trace-id"
+
+	// TentativeAllocationTTLAnnotation makes the node allocations of a NodePool tentative, pending confirmation by an
+	// external system, with the duration (e.g. "10m") after which unconfirmed allocations expire and are freed
+	TentativeAllocationTTLAnnotation = AnnotationPrefix + "tentative-allocation-ttl"
+
+	// RequireFirmwareComplianceAnnotation restricts the allocation of a NodePool to nodes whose firmware is compliant
+	// while set to "true", such as for production pools
+	RequireFirmwareComplianceAnnotation = AnnotationPrefix + "require-firmware-compliance"
+
+	// RequireBMCAnnotation restricts the allocation of a NodePool to nodes with a BMC address while set to "true", such
+	// as for pools whose nodes are managed through their BMCs
+	RequireBMCAnnotation = AnnotationPrefix + "require-bmc"
+
+	// MaxPerRackAnnotation is the maximum number of nodes of a NodePool, across all of its nodegroups, that may be
+	// allocated from any one rack (e.g. "1"), so that the NodePool is spread across racks
+	MaxPerRackAnnotation = AnnotationPrefix + "max-per-rack"
+
+	// ExcludeNodesAnnotationPrefix is followed by a nodegroup name, with a comma-separated list of nodes that must not
+	// be allocated to that nodegroup (e.g. "oran-hwmgr/exclude-nodes.master: node-a-0,node-a-1")
+	ExcludeNodesAnnotationPrefix = AnnotationPrefix + "exclude-nodes."
+
+	// SerialsAnnotationPrefix is followed by a nodegroup name, with a comma-separated list of hardware serial numbers
+	// that pins the nodegroup to the nodes with those serials (e.g. "oran-hwmgr/serials.master: SN0001,SN0002")
+	SerialsAnnotationPrefix = AnnotationPrefix + "serials."
+
+	// AcceleratorsAnnotationPrefix is followed by a nodegroup name, with the total number of accelerators requested for
+	// that nodegroup (e.g. "oran-hwmgr/accelerators.worker: 8"). The nodegroup is allocated enough nodes with
+	// accelerators to reach the total, in place of its size.
+	AcceleratorsAnnotationPrefix = AnnotationPrefix + "accelerators."
+
+	// TeardownPriorityAnnotationPrefix is followed by a nodegroup name, with the priority (e.g.
+	// "oran-hwmgr/teardown-priority.storage: 10") in which the nodegroup is torn down when its NodePool is released.
+	// Nodegroups are torn down from the lowest priority to the highest, with unset priorities being zero.
+	TeardownPriorityAnnotationPrefix = AnnotationPrefix + "teardown-priority."
+)
+
+// Annotations maintained by the plugin on Node CRs
+const (
+	// AllocatedAtAnnotation records when a node was allocated (RFC 3339), from which its provisioning is timed
+	AllocatedAtAnnotation = AnnotationPrefix + "allocated-at"
+
+	// WarmAnnotation is set to "true" by the plugin on the Node CRs of pre-provisioned nodes in a warm pool, which are
+	// not yet allocated to a NodePool
+	WarmAnnotation = AnnotationPrefix + "warm"
+
+	// SelectionStrategyAnnotation, SelectionProfileAnnotation, and SelectionCriteriaAnnotation are set by the plugin
+	// on allocated Node CRs to record why the node was selected, for auditing: the strategy by which it was chosen
+	// among the candidates (e.g. "sorted" or "warm-pool"), the hardware profile it matched, and a comma-separated list
+	// of the other criteria it was matched against (e.g. "serial,firmware"), which is omitted if there were none
+	SelectionStrategyAnnotation = AnnotationPrefix + "selection-strategy"
+	SelectionProfileAnnotation  = AnnotationPrefix + "selection-profile"
+	SelectionCriteriaAnnotation = AnnotationPrefix + "selection-criteria"
+
+	// TentativeAnnotation is set to "true" by the plugin on Node CRs whose allocation is tentative
+	TentativeAnnotation = AnnotationPrefix + "tentative"
+
+	// ConfirmedAnnotation is set to "true" by an external system to confirm a tentative allocation, making it firm
+	ConfirmedAnnotation = AnnotationPrefix + "confirmed"
+
+	// BMCSchemeAnnotation, BMCHostAnnotation, and BMCPortAnnotation are set by the plugin to the components of the BMC
+	// address in the Node status, as the status cannot be extended
+	BMCSchemeAnnotation = AnnotationPrefix + "bmc-scheme"
+	BMCHostAnnotation   = AnnotationPrefix + "bmc-host"
+	BMCPortAnnotation   = AnnotationPrefix + "bmc-port"
+
+	// BMCProtocolAnnotation is set by the plugin to the protocol spoken by the BMC of the node ("redfish" or "ipmi"),
+	// so that consumers of the Node CR know how to reach it
+	BMCProtocolAnnotation = AnnotationPrefix + "bmc-protocol"
+
+	// BootMACAddressAnnotation is set by the plugin to the MAC address of the interface from which the node is
+	// provisioned, chosen among its interfaces by their labels, as the status does not single it out
+	BootMACAddressAnnotation = AnnotationPrefix + "boot-mac-address"
+
+	// InventoryKeyLabel is set, as a label where valid and always as an annotation, on Node CRs whose name differs
+	// from the key of the node in the nodelist configmap inventory. It is also set as an annotation on bmc-secrets, to
+	// record the node whose credentials they hold.
+	InventoryKeyLabel = AnnotationPrefix + "inventory-key"
+)
+
+// Annotations maintained by the plugin on the nodelist configmap
+const (
+	// AllocatedCountAnnotationPrefix is followed by a hardware profile name, with the cumulative number of nodes
+	// allocated from that profile
+	AllocatedCountAnnotationPrefix = AnnotationPrefix + "allocated-count."
+
+	// ReleasedCountAnnotationPrefix is followed by a hardware profile name, with the cumulative number of nodes
+	// released back to that profile
+	ReleasedCountAnnotationPrefix = AnnotationPrefix + "released-count."
+)
+
+// GetDurationAnnotation parses a duration from the specified annotation, returning zero if it is not set
+func GetDurationAnnotation(object client.Object, annotation string) (time.Duration, error) {
+	value, exists := object.GetAnnotations()[annotation]
+	if !exists {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q: %w", annotation, value, err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q: must not be negative", annotation, value)
+	}
+
+	return duration, nil
+}
+
+// GetIntAnnotation parses a non-negative integer from the specified annotation, returning zero if it is not set
+func GetIntAnnotation(object client.Object, annotation string) (int, error) {
+	value, exists := object.GetAnnotations()[annotation]
+	if !exists {
+		return 0, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q: %w", annotation, value, err)
+	}
+	if number < 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q: must not be negative", annotation, value)
+	}
+
+	return number, nil
+}
+
+// GetListAnnotation parses a comma-separated list from the specified annotation, returning nil if it is not set
+func GetListAnnotation(object client.Object, annotation string) (values []string) {
+	value, exists := object.GetAnnotations()[annotation]
+	if !exists {
+		return
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+
+	return
+}
+
+// IsAnnotationTrue checks whether the specified annotation is set to "true"
+func IsAnnotationTrue(object client.Object, annotation string) bool {
+	return strings.EqualFold(object.GetAnnotations()[annotation], "true")
+}
+
+// IncrementCounterAnnotation adds delta to the integer counter in the specified annotation, treating a missing or
+// invalid value as zero
+func IncrementCounterAnnotation(object client.Object, annotation string, delta int) {
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	count, _ := strconv.Atoi(annotations[annotation])
+	annotations[annotation] = strconv.Itoa(count + delta)
+	object.SetAnnotations(annotations)
+}
@@ -6,6 +6,58 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// Condition types set by the plugin, in addition to those defined by the hardwaremanagement API
+const (
+	// Validated indicates whether the NodePool request has been admitted
+	Validated hwmgmtv1alpha1.ConditionType = "Validated"
+	// Configured indicates whether the allocated nodes have been configured for use
+	Configured hwmgmtv1alpha1.ConditionType = "Configured"
+	// Paused indicates whether reconciliation of the NodePool has been paused by annotation
+	Paused hwmgmtv1alpha1.ConditionType = "Paused"
+	// AllocationProgress reports the allocated and requested node counts of each nodegroup, and whether all of them
+	// are fully allocated
+	AllocationProgress hwmgmtv1alpha1.ConditionType = "AllocationProgress"
+	// Queued reports the position of a NodePool waiting for free nodes among the NodePools waiting for the same hardware
+	// profile, in order of creation
+	Queued hwmgmtv1alpha1.ConditionType = "Queued"
+	// CredentialsValid indicates whether the BMC of a Node accepts the credentials in its bmc-secret, when checked
+	CredentialsValid hwmgmtv1alpha1.ConditionType = "CredentialsValid"
+	// Reconciled records the time of the last successful reconcile of a NodePool as its LastTransitionTime, which is
+	// refreshed on every successful reconcile
+	Reconciled hwmgmtv1alpha1.ConditionType = "Reconciled"
+)
+
+// Condition reasons used by the plugin, in addition to those defined by the hardwaremanagement API
+const (
+	// Allocated indicates that the nodes have been reserved, but are not yet provisioned
+	Allocated hwmgmtv1alpha1.ConditionReason = "Allocated"
+	// InsufficientResources indicates that allocation is stalled until enough nodes become free
+	InsufficientResources hwmgmtv1alpha1.ConditionReason = "InsufficientResources"
+	// ExceedsCapacity indicates that the NodePool requests more nodes than exist in a hardware profile, so that it cannot
+	// be admitted until the spec of the NodePool changes
+	ExceedsCapacity hwmgmtv1alpha1.ConditionReason = "ExceedsCapacity"
+	// InvalidSpec indicates that the nodegroups of the NodePool are malformed, such as a nodegroup without a positive
+	// size, so that it cannot be admitted until the spec of the NodePool changes
+	InvalidSpec hwmgmtv1alpha1.ConditionReason = "InvalidSpec"
+	// UnknownProfile indicates that the NodePool requests a hardware profile that is not in the inventory, so that it
+	// cannot be admitted until the spec of the NodePool changes
+	UnknownProfile hwmgmtv1alpha1.ConditionReason = "UnknownProfile"
+	// InvalidCredentials indicates that the BMC of a Node rejected the credentials in its bmc-secret, such as after
+	// they were rotated out-of-band
+	InvalidCredentials hwmgmtv1alpha1.ConditionReason = "InvalidCredentials"
+	// DuplicateCloudID indicates that the NodePool has the same CloudID as an older NodePool, which owns the nodes
+	// allocated to it, so that it cannot be admitted until the conflict is resolved
+	DuplicateCloudID hwmgmtv1alpha1.ConditionReason = "DuplicateCloudID"
+	// NamespaceMismatch indicates that the NodePool is not in the namespace managed by the plugin
+	NamespaceMismatch hwmgmtv1alpha1.ConditionReason = "NamespaceMismatch"
+	// ProfileCordoned indicates that the NodePool requests a hardware profile from which no nodes are allocated
+	ProfileCordoned hwmgmtv1alpha1.ConditionReason = "ProfileCordoned"
+	// PauseRequested indicates that reconciliation is paused by annotation
+	PauseRequested hwmgmtv1alpha1.ConditionReason = "PauseRequested"
+	// Resumed indicates that reconciliation has resumed after being paused
+	Resumed hwmgmtv1alpha1.ConditionReason = "Resumed"
+)
+
 // SetStatusCondition is a convenience wrapper for meta.SetStatusCondition that takes in the types defined here and converts them to strings
 func SetStatusCondition(existingConditions *[]metav1.Condition, conditionType hwmgmtv1alpha1.ConditionType, conditionReason hwmgmtv1alpha1.ConditionReason, conditionStatus metav1.ConditionStatus, message string) {
 	conditions := *existingConditions
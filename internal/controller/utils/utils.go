@@ -112,7 +112,7 @@ func CreateK8sCR(ctx context.Context, c client.Client,
 	return nil
 }
 
-func DoesK8SResourceExist(ctx context.Context, c client.Client, name, namespace string, obj client.Object) (resourceExists bool, err error) {
+func DoesK8SResourceExist(ctx context.Context, c client.Reader, name, namespace string, obj client.Object) (resourceExists bool, err error) {
 	err = c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, obj)
 
 	if err != nil {
@@ -130,7 +130,7 @@ func DoesK8SResourceExist(ctx context.Context, c client.Client, name, namespace
 	}
 }
 
-func GetConfigmap(ctx context.Context, c client.Client, name, namespace string) (*corev1.ConfigMap, error) {
+func GetConfigmap(ctx context.Context, c client.Reader, name, namespace string) (*corev1.ConfigMap, error) {
 	existingConfigmap := &corev1.ConfigMap{}
 	cmExists, err := DoesK8SResourceExist(
 		ctx, c, name, namespace, existingConfigmap)
@@ -147,6 +147,17 @@ func GetConfigmap(ctx context.Context, c client.Client, name, namespace string)
 }
 
 func ExtractDataFromConfigMap[T any](cm *corev1.ConfigMap, key string) (T, error) {
+	return extractDataFromConfigMap[T](cm, key, yaml.Unmarshal)
+}
+
+// ExtractDataFromConfigMapStrict is like ExtractDataFromConfigMap, but fails if the data has any fields that are
+// unknown to T, such as a misspelled field name
+func ExtractDataFromConfigMapStrict[T any](cm *corev1.ConfigMap, key string) (T, error) {
+	return extractDataFromConfigMap[T](cm, key, yaml.UnmarshalStrict)
+}
+
+func extractDataFromConfigMap[T any](cm *corev1.ConfigMap, key string,
+	unmarshal func([]byte, interface{}, ...yaml.JSONOpt) error) (T, error) {
 	var object T
 
 	data, exists := cm.Data[key]
@@ -154,9 +165,9 @@ func ExtractDataFromConfigMap[T any](cm *corev1.ConfigMap, key string) (T, error
 		return object, fmt.Errorf("unable to find %s data in configmap", key)
 	}
 
-	err := yaml.Unmarshal([]byte(data), &object)
+	err := unmarshal([]byte(data), &object)
 	if err != nil {
-		return object, fmt.Errorf("unable to parse %s from configmap", key)
+		return object, fmt.Errorf("unable to parse %s from configmap: %w", key, err)
 	}
 
 	return object, nil
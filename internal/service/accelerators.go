@@ -0,0 +1,61 @@
+package service
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+// acceleratorTarget gets the total number of accelerators requested for a nodegroup, or zero if it requests a number
+// of nodes instead. An invalid annotation is rejected when the NodePool is admitted, so it is ignored here.
+func acceleratorTarget(nodepool *hwmgmtv1alpha1.NodePool, nodegroup hwmgmtv1alpha1.NodeGroup) int {
+	target, _ := utils.GetIntAnnotation(nodepool, utils.AcceleratorsAnnotationPrefix+nodegroup.Name)
+	return target
+}
+
+// requireAccelerators is a nodeFilter that accepts only the nodes with accelerators
+func requireAccelerators(_ string, node cmNodeInfo) bool {
+	return node.Accelerators > 0
+}
+
+// mostAcceleratorsFirst orders the candidate nodes by descending accelerator count, keeping the existing order of nodes
+// with the same count, so that an accelerator target is reached with the fewest nodes
+func mostAcceleratorsFirst(resources cmResources, nodenames []string) []string {
+	slices.SortStableFunc(nodenames, func(a, b string) int {
+		return cmp.Compare(resources.Nodes[b].Accelerators, resources.Nodes[a].Accelerators)
+	})
+	return nodenames
+}
+
+// nodesNeeded gets the number of further nodes to be allocated to a nodegroup, given the nodes already allocated to it
+// and the free candidates. For a nodegroup requesting a number of accelerators, it is the number of candidates needed
+// to reach the target, taking those with the most accelerators first, or one more than there are candidates if they
+// fall short.
+func nodesNeeded(resources cmResources, nodepool *hwmgmtv1alpha1.NodePool, nodegroup hwmgmtv1alpha1.NodeGroup,
+	allocated, candidates []string) int {
+	target := acceleratorTarget(nodepool, nodegroup)
+	if target == 0 {
+		return nodegroup.Size - len(allocated)
+	}
+
+	total := 0
+	for _, nodename := range allocated {
+		total += resources.Nodes[nodename].Accelerators
+	}
+
+	needed := 0
+	for _, nodename := range mostAcceleratorsFirst(resources, slices.Clone(candidates)) {
+		if total >= target {
+			break
+		}
+		total += resources.Nodes[nodename].Accelerators
+		needed++
+	}
+	if total < target {
+		needed++
+	}
+
+	return needed
+}
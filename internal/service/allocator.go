@@ -0,0 +1,36 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+)
+
+// NodeAllocator is a strategy for selecting which of the candidate free nodes is allocated to a nodegroup
+type NodeAllocator interface {
+	// SelectNode selects a node from the free nodes of a hardware profile. The free nodes are ordered by preference,
+	// with the nodes of the warm pool first, then by the configured sort keys.
+	SelectNode(profile string, free []string, resources cmResources) (string, error)
+}
+
+// firstAvailableAllocator is the default NodeAllocator, selecting the most preferred free node
+type firstAvailableAllocator struct{}
+
+func (firstAvailableAllocator) SelectNode(profile string, free []string, _ cmResources) (string, error) {
+	if len(free) == 0 {
+		return "", fmt.Errorf("no free nodes in hardware profile %s", profile)
+	}
+	return free[0], nil
+}
+
+// selectNode selects a node from the free nodes of a hardware profile with the configured allocator, checking that it
+// selected one of them
+func (h *HwMgrService) selectNode(profile string, free []string, resources cmResources) (string, error) {
+	nodename, err := h.allocator.SelectNode(profile, free, resources)
+	if err != nil {
+		return "", fmt.Errorf("failed to select node in hardware profile %s: %w", profile, err)
+	}
+	if !slices.Contains(free, nodename) {
+		return "", fmt.Errorf("allocator selected node %q, which is not a free node in hardware profile %s", nodename, profile)
+	}
+	return nodename, nil
+}
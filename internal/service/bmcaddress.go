@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// BMCAddress is a BMC address split into its components
+type BMCAddress struct {
+	Scheme string `json:"scheme"`
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+}
+
+// defaultBMCPorts are the ports used by the BMC protocols and transports when the address does not specify one
+var defaultBMCPorts = map[string]int{
+	"https": 443,
+	"http":  80,
+	"ipmi":  623,
+}
+
+// ParseBMCAddress splits a BMC address, such as "redfish+https://10.0.0.1/redfish/v1/Systems/1", into its scheme, host,
+// and port. If no port is given, the default for the transport of the scheme is used (e.g. 443 for redfish+https). An
+// address without a scheme is taken to be an IPMI host, as is the convention for BMC addresses.
+func ParseBMCAddress(address string) (BMCAddress, error) {
+	if !strings.Contains(address, "://") {
+		address = "ipmi://" + address
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return BMCAddress{}, fmt.Errorf("invalid BMC address %q: %w", address, err)
+	}
+	if u.Hostname() == "" {
+		return BMCAddress{}, fmt.Errorf("invalid BMC address %q: missing host", address)
+	}
+
+	parsed := BMCAddress{Scheme: u.Scheme, Host: u.Hostname()}
+
+	if port := u.Port(); port != "" {
+		if parsed.Port, err = strconv.Atoi(port); err != nil {
+			return BMCAddress{}, fmt.Errorf("invalid BMC address %q: invalid port: %w", address, err)
+		}
+		return parsed, nil
+	}
+
+	// The transport follows the protocol in schemes such as redfish+https
+	transport := u.Scheme
+	if _, after, found := strings.Cut(u.Scheme, "+"); found {
+		transport = after
+	}
+	port, exists := defaultBMCPorts[transport]
+	if !exists {
+		return BMCAddress{}, fmt.Errorf("invalid BMC address %q: no default port for scheme %s", address, u.Scheme)
+	}
+	parsed.Port = port
+
+	return parsed, nil
+}
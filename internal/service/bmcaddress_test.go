@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+)
+
+var _ = Describe("ParseBMCAddress", func() {
+	DescribeTable("splits the address into its components",
+		func(address string, expected BMCAddress) {
+			Expect(ParseBMCAddress(address)).To(Equal(expected))
+		},
+		Entry("with an explicit port", "redfish+https://10.0.0.1:443",
+			BMCAddress{Scheme: "redfish+https", Host: "10.0.0.1", Port: 443}),
+		Entry("with the default Redfish port", "redfish+https://192.168.1.0/redfish/v1/Systems/1",
+			BMCAddress{Scheme: "redfish+https", Host: "192.168.1.0", Port: 443}),
+		Entry("with the default http port", "redfish+http://bmc.example.com/redfish/v1/Systems/1",
+			BMCAddress{Scheme: "redfish+http", Host: "bmc.example.com", Port: 80}),
+		Entry("with an IPv6 host", "idrac-virtualmedia+https://[fd00::1]:8443/redfish/v1/Systems/1",
+			BMCAddress{Scheme: "idrac-virtualmedia+https", Host: "fd00::1", Port: 8443}),
+		Entry("without a scheme", "10.0.0.2",
+			BMCAddress{Scheme: "ipmi", Host: "10.0.0.2", Port: 623}),
+	)
+
+	It("rejects an address without a default port for its scheme", func() {
+		_, err := ParseBMCAddress("unknown://10.0.0.1")
+		Expect(err).To(MatchError(ContainSubstring("no default port for scheme unknown")))
+	})
+
+	It("publishes the components on the provisioned Node", func() {
+		ctx := context.Background()
+		c := newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build()
+		hwmgr := newTestService(c)
+
+		nodepool := newNodePool("np1", "cloud-1",
+			hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+		Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+		Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+
+		node := &hwmgmtv1alpha1.Node{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, node)).To(Succeed())
+		Expect(node.Status.BMC.Address).To(Equal("redfish+https://192.168.1.0/redfish/v1/Systems/1"))
+		Expect(node.Annotations).To(HaveKeyWithValue(utils.BMCSchemeAnnotation, "redfish+https"))
+		Expect(node.Annotations).To(HaveKeyWithValue(utils.BMCHostAnnotation, "192.168.1.0"))
+		Expect(node.Annotations).To(HaveKeyWithValue(utils.BMCPortAnnotation, "443"))
+		Expect(node.Annotations).To(HaveKeyWithValue(utils.BMCProtocolAnnotation, BMCProtocolRedfish))
+	})
+
+	It("publishes the protocol of an IPMI BMC on the provisioned Node", func() {
+		resources := `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    bmc:
+      address: "192.168.1.0"
+      protocol: ipmi
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+`
+		ctx := context.Background()
+		c := newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+		hwmgr := newTestService(c)
+		Expect(hwmgr.ValidateInventory(ctx)).To(Succeed())
+
+		nodepool := newNodePool("np1", "cloud-1",
+			hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+		Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+		Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+
+		node := &hwmgmtv1alpha1.Node{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, node)).To(Succeed())
+		Expect(node.Status.BMC.Address).To(Equal("192.168.1.0"))
+		Expect(node.Annotations).To(HaveKeyWithValue(utils.BMCProtocolAnnotation, BMCProtocolIPMI))
+		Expect(node.Annotations).To(HaveKeyWithValue(utils.BMCPortAnnotation, "623"))
+	})
+})
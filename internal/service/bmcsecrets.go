@@ -0,0 +1,315 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// altBMCSecretName gets the disambiguated bmc-secret name for a node whose default bmc-secret name is already used by
+// another node, such as when two inventory keys are mapped to the same Node CR name
+func (h *HwMgrService) altBMCSecretName(nodename string) string {
+	suffix := "-" + keyHash(nodename)
+
+	name := h.nodeName(nodename)
+	if len(name) > maxNodeNameLength-len(suffix) {
+		name = strings.TrimRight(name[:maxNodeNameLength-len(suffix)], ".-")
+	}
+
+	return name + suffix + bmcSecretSuffix
+}
+
+// bmcSecretOwner gets the inventory key of the node for which a bmc-secret was written, if recorded
+func bmcSecretOwner(secret *corev1.Secret) string {
+	return secret.Annotations[utils.InventoryKeyLabel]
+}
+
+// resolveBMCSecretName gets the name of the bmc-secret for a node. The default name is used unless it is held by the
+// bmc-secret of another node, in which case the disambiguated name is used, so that the credentials of nodes whose
+// names collide are never crossed.
+func (h *HwMgrService) resolveBMCSecretName(ctx context.Context, nodename string) (string, error) {
+	altName := h.altBMCSecretName(nodename)
+	secret := &corev1.Secret{}
+	err := h.Client.Get(ctx, types.NamespacedName{Name: altName, Namespace: h.namespace}, secret)
+	if err == nil {
+		return altName, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get bmc-secret %s: %w", altName, err)
+	}
+
+	name := h.bmcSecretName(nodename)
+	err = h.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: h.namespace}, secret)
+	if apierrors.IsNotFound(err) {
+		return name, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get bmc-secret %s: %w", name, err)
+	}
+
+	// A bmc-secret without a recorded owner predates the collision detection, so it is assumed to be the node's own
+	if owner := bmcSecretOwner(secret); owner != "" && owner != nodename {
+		h.logger.WarnContext(ctx, "bmc-secret name collision, using disambiguated name",
+			"nodename", nodename, "owner", owner, "secret", name, "disambiguated", altName)
+		return altName, nil
+	}
+
+	return name, nil
+}
+
+// credentialsName gets the name of the Secret holding the BMC credentials of a node, which is the Secret referenced by
+// its BMC info, if any, and otherwise its bmc-secret
+func (h *HwMgrService) credentialsName(ctx context.Context, nodename string, bmc *cmBmcInfo) (string, error) {
+	if bmc.CredentialsSecretRef != "" {
+		return bmc.CredentialsSecretRef, nil
+	}
+	return h.resolveBMCSecretName(ctx, nodename)
+}
+
+// verifyReferencedBMCSecret checks that the Secret referenced by the BMC info of a node exists and holds a username and
+// password. It must not have the name of a bmc-secret of the node, which the plugin deletes along with the Node CR.
+func (h *HwMgrService) verifyReferencedBMCSecret(ctx context.Context, nodename, secretName string) error {
+	if secretName == h.bmcSecretName(nodename) || secretName == h.altBMCSecretName(nodename) {
+		return fmt.Errorf("credentials secret %s of node %s has the name of a bmc-secret managed by the plugin",
+			secretName, nodename)
+	}
+
+	h.logger.InfoContext(ctx, "Using referenced credentials secret:", "nodename", nodename, "secret", secretName)
+	secret := &corev1.Secret{}
+	if err := h.Client.Get(ctx, types.NamespacedName{Name: secretName, Namespace: h.namespace}, secret); err != nil {
+		return fmt.Errorf("failed to get credentials secret %s of node %s: %w", secretName, nodename, err)
+	}
+
+	for _, key := range []string{"username", "password"} {
+		if len(secret.Data[key]) == 0 {
+			return fmt.Errorf("credentials secret %s of node %s has no %s", secretName, nodename, key)
+		}
+	}
+
+	return nil
+}
+
+// bmcCredentials gets the credentials of a node from its BMC info, using the plaintext credentials if set, and
+// otherwise decoding the base64 encoded ones
+func bmcCredentials(nodename string, bmc *cmBmcInfo) (username, password []byte, err error) {
+	if bmc.Username != "" || bmc.Password != "" {
+		return []byte(bmc.Username), []byte(bmc.Password), nil
+	}
+
+	if username, err = base64.StdEncoding.DecodeString(bmc.UsernameBase64); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode usernameBase64 for node %s: %w", nodename, err)
+	}
+	if password, err = base64.StdEncoding.DecodeString(bmc.PasswordBase64); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode passwordBase64 for node %s: %w", nodename, err)
+	}
+
+	return username, password, nil
+}
+
+// checkBMCCredentials decodes the base64 encoded credentials of every node in the inventory, so that a malformed one is
+// caught when the inventory is read, rather than when its node is allocated. It returns a single error naming all of
+// the offending nodes.
+func checkBMCCredentials(resources cmResources) error {
+	nodenames := make([]string, 0, len(resources.Nodes))
+	for nodename := range resources.Nodes {
+		nodenames = append(nodenames, nodename)
+	}
+	slices.Sort(nodenames)
+
+	var invalid []string
+	var errs []error
+	for _, nodename := range nodenames {
+		bmc := resources.Nodes[nodename].BMC
+		if bmc == nil || bmc.CredentialsSecretRef != "" {
+			continue
+		}
+		if _, _, err := bmcCredentials(nodename, bmc); err != nil {
+			invalid = append(invalid, nodename)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("malformed BMC credentials for nodes %s: %w", strings.Join(invalid, ", "), errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// RepairBMCSecrets finds the nodes holding a bmc-secret, whether allocated or in a warm pool, whose default bmc-secret
+// names collide, and rewrites their bmc-secrets from the inventory so that each has a distinct bmc-secret with its own
+// credentials. The node recorded as the owner of the default name keeps it, while the others are moved to their
+// disambiguated names, with the credentials name in the status of their Node CRs updated to match. It returns the
+// nodes whose bmc-secrets were rewritten.
+func (h *HwMgrService) RepairBMCSecrets(ctx context.Context) ([]string, error) {
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	_, resources, allocations, err := h.GetCurrentResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	holders := slices.Clone(allocations.Warm)
+	for _, cloud := range allocations.Clouds {
+		for _, nodenames := range cloud.Nodegroups {
+			holders = append(holders, nodenames...)
+		}
+	}
+
+	collisions := make(map[string][]string)
+	for _, nodename := range holders {
+		if nodeinfo, exists := resources.Nodes[nodename]; exists && nodeinfo.BMC != nil &&
+			nodeinfo.BMC.CredentialsSecretRef == "" {
+			name := h.bmcSecretName(nodename)
+			collisions[name] = append(collisions[name], nodename)
+		}
+	}
+
+	var repaired []string
+	for name, nodenames := range collisions {
+		if len(nodenames) < 2 {
+			continue
+		}
+		slices.Sort(nodenames)
+		h.logger.WarnContext(ctx, "Repairing bmc-secret name collision", "secret", name, "nodes", nodenames)
+
+		owner := nodenames[0]
+		secret := &corev1.Secret{}
+		if err := h.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: h.namespace}, secret); err == nil {
+			if recorded := bmcSecretOwner(secret); slices.Contains(nodenames, recorded) {
+				owner = recorded
+			}
+		} else if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get bmc-secret %s: %w", name, err)
+		}
+
+		for _, nodename := range nodenames {
+			secretName := name
+			if nodename != owner {
+				secretName = h.altBMCSecretName(nodename)
+			}
+
+			if err := h.repairBMCSecret(ctx, nodename, secretName, resources.Nodes[nodename].BMC); err != nil {
+				return nil, err
+			}
+			repaired = append(repaired, nodename)
+		}
+	}
+
+	slices.Sort(repaired)
+	return repaired, nil
+}
+
+// repairBMCSecret rewrites the bmc-secret of a node with the specified name, and points the status of its Node CR at
+// it, if the Node CR belongs to the node
+func (h *HwMgrService) repairBMCSecret(ctx context.Context, nodename, secretName string, bmc *cmBmcInfo) error {
+	username, password, err := bmcCredentials(nodename, bmc)
+	if err != nil {
+		return err
+	}
+
+	if err := h.putBMCSecret(ctx, nodename, secretName, username, password); err != nil {
+		return err
+	}
+
+	node := &hwmgmtv1alpha1.Node{}
+	if err := h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get Node: %w", err)
+	}
+
+	if inventoryKey(node) != nodename || node.Status.BMC == nil || node.Status.BMC.CredentialsName == secretName {
+		return nil
+	}
+
+	node.Status.BMC.CredentialsName = secretName
+	if err := utils.UpdateK8sCRStatus(ctx, h.Client, node); err != nil {
+		return fmt.Errorf("failed to update status for node %s: %w", nodename, err)
+	}
+
+	return nil
+}
+
+// ReconcileBMCSecrets rewrites the bmc-secrets of the nodes allocated to a NodePool whose credentials no longer match
+// those in the inventory, or that are missing, such as after the credentials of a node are rotated. It returns the
+// nodes whose bmc-secrets were rewritten.
+func (h *HwMgrService) ReconcileBMCSecrets(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) ([]string, error) {
+	// Hold the lock so that the bmc-secrets of allocations in progress, or being released, are left alone
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	_, resources, allocations, err := h.GetCurrentResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	var reconciled []string
+	for _, cloud := range allocations.Clouds {
+		if cloud.CloudID != nodepool.Spec.CloudID {
+			continue
+		}
+		for _, nodenames := range cloud.Nodegroups {
+			for _, nodename := range nodenames {
+				// The referenced credentials secrets are managed by the operator, not rewritten from the inventory
+				nodeinfo, exists := resources.Nodes[nodename]
+				if !exists || nodeinfo.BMC == nil || nodeinfo.BMC.CredentialsSecretRef != "" {
+					continue
+				}
+
+				updated, err := h.reconcileBMCSecret(ctx, nodename, nodeinfo.BMC)
+				if err != nil {
+					return nil, err
+				}
+				if updated {
+					reconciled = append(reconciled, nodename)
+				}
+			}
+		}
+	}
+
+	slices.Sort(reconciled)
+	return reconciled, nil
+}
+
+// reconcileBMCSecret rewrites the bmc-secret of a node if its credentials differ from the specified BMC info, returning
+// whether it was rewritten
+func (h *HwMgrService) reconcileBMCSecret(ctx context.Context, nodename string, bmc *cmBmcInfo) (bool, error) {
+	username, password, err := bmcCredentials(nodename, bmc)
+	if err != nil {
+		return false, err
+	}
+
+	secretName, err := h.resolveBMCSecretName(ctx, nodename)
+	if err != nil {
+		return false, err
+	}
+
+	secret := &corev1.Secret{}
+	err = h.Client.Get(ctx, types.NamespacedName{Name: secretName, Namespace: h.namespace}, secret)
+	if err == nil && bytes.Equal(secret.Data["username"], username) && bytes.Equal(secret.Data["password"], password) {
+		return false, nil
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get bmc-secret %s: %w", secretName, err)
+	}
+
+	h.logger.InfoContext(ctx, "Reconciling bmc-secret with inventory credentials", "nodename", nodename, "secret", secretName)
+	if err := h.putBMCSecret(ctx, nodename, secretName, username, password); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+// BootInterfaceLabel is the label of the interface of a node from which it is provisioned, as used by the O-Cloud
+// Manager to find its boot MAC address
+const BootInterfaceLabel = "bootable-interface"
+
+// bootMACAddress gets the MAC address of the interface of a node from which it is provisioned. This is the interface
+// labelled as the boot interface, if any, and otherwise the only interface of a node that has just one, as in
+// inventories written before nodes had more than one interface. It is empty if the boot interface is ambiguous.
+func bootMACAddress(interfaces []*hwmgmtv1alpha1.Interface) string {
+	var mac string
+	for _, iface := range interfaces {
+		if iface == nil || iface.Label != BootInterfaceLabel {
+			continue
+		}
+		if mac != "" {
+			return ""
+		}
+		mac = iface.MACAddress
+	}
+
+	if mac == "" && len(interfaces) == 1 && interfaces[0] != nil {
+		mac = interfaces[0].MACAddress
+	}
+
+	return mac
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// CredentialVerifier checks that the credentials of an allocated node are still accepted by its BMC, as they may be
+// rotated out-of-band
+type CredentialVerifier interface {
+	// VerifyCredentials reports whether the BMC accepts the credentials held in the bmc-secret of the node
+	VerifyCredentials(ctx context.Context, nodename, bmcAddress string, username, password []byte) (bool, error)
+}
+
+// CheckCredentials verifies the bmc-secret credentials of all allocated nodes with the credential verifier, setting the
+// CredentialsValid condition of their Node CRs to the result. It returns the nodes whose credentials were rejected. If
+// no verifier is configured, nothing is checked.
+func (h *HwMgrService) CheckCredentials(ctx context.Context) ([]string, error) {
+	if h.credentialVerifier == nil {
+		return nil, nil
+	}
+
+	// The lock is not held while the BMCs are queried, so a node released in the meantime is simply skipped
+	_, _, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	var invalid []string
+	for nodename := range getNodesInUse(allocations) {
+		valid, checked, err := h.checkNodeCredentials(ctx, nodename)
+		if err != nil {
+			return nil, err
+		}
+		if checked && !valid {
+			invalid = append(invalid, nodename)
+		}
+	}
+
+	slices.Sort(invalid)
+	return invalid, nil
+}
+
+// checkNodeCredentials verifies the bmc-secret credentials of an allocated node and records the result in the status
+// of its Node CR, reporting whether they are valid, and whether they were checked at all, as a node without a Node CR
+// or bmc-secret has no credentials to check
+func (h *HwMgrService) checkNodeCredentials(ctx context.Context, nodename string) (valid, checked bool, err error) {
+	node := &hwmgmtv1alpha1.Node{}
+	if err := h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("failed to get Node: %w", err)
+	}
+	if inventoryKey(node) != nodename || node.Status.BMC == nil || node.Status.BMC.CredentialsName == "" {
+		return false, false, nil
+	}
+
+	secret := &corev1.Secret{}
+	err = h.Client.Get(ctx, types.NamespacedName{Name: node.Status.BMC.CredentialsName, Namespace: h.namespace}, secret)
+	if apierrors.IsNotFound(err) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to get bmc-secret %s: %w", node.Status.BMC.CredentialsName, err)
+	}
+
+	valid, err = h.credentialVerifier.VerifyCredentials(ctx, nodename, node.Status.BMC.Address,
+		secret.Data["username"], secret.Data["password"])
+	if err != nil {
+		return false, false, fmt.Errorf("failed to verify credentials of node %s: %w", nodename, err)
+	}
+
+	reason, status, message := hwmgmtv1alpha1.Completed, metav1.ConditionTrue, "BMC credentials verified"
+	if !valid {
+		h.logger.WarnContext(ctx, "BMC credentials rejected", "nodename", nodename,
+			"secret", node.Status.BMC.CredentialsName)
+		reason, status, message = utils.InvalidCredentials, metav1.ConditionFalse, "BMC credentials rejected by the BMC"
+	}
+
+	if condition := meta.FindStatusCondition(node.Status.Conditions, string(utils.CredentialsValid)); condition != nil &&
+		condition.Status == status && condition.Reason == string(reason) {
+		return valid, true, nil
+	}
+
+	utils.SetStatusCondition(&node.Status.Conditions, utils.CredentialsValid, reason, status, message)
+	if err := utils.UpdateK8sCRStatus(ctx, h.Client, node); err != nil {
+		return false, false, fmt.Errorf("failed to update status for node %s: %w", nodename, err)
+	}
+
+	return valid, true, nil
+}
+
+// CredentialCheckManager periodically verifies the credentials of the allocated nodes as a manager Runnable
+type CredentialCheckManager struct {
+	HwMgr *HwMgrService
+
+	// Interval is the period between checks of the credentials
+	Interval time.Duration
+}
+
+// Start checks the credentials at each interval until the context is cancelled
+func (m *CredentialCheckManager) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if invalid, err := m.HwMgr.CheckCredentials(ctx); err != nil {
+			m.HwMgr.logger.ErrorContext(ctx, "failed to check credentials", "error", err)
+		} else if len(invalid) != 0 {
+			m.HwMgr.logger.WarnContext(ctx, "Nodes with invalid BMC credentials", "nodes", invalid)
+		}
+	}, m.Interval)
+
+	return nil
+}
+
+// NeedLeaderElection restricts the credential checks to the leader, as they update the status of the Node CRs
+func (m *CredentialCheckManager) NeedLeaderElection() bool {
+	return true
+}
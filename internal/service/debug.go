@@ -0,0 +1,59 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DebugAllocationsPath is the path of the allocation snapshot served by the debug handler
+const DebugAllocationsPath = "/debug/allocations"
+
+// AllocationSnapshot is the current view of the allocations and capacity, as read from the nodelist configmap
+type AllocationSnapshot struct {
+	ResourceVersion string                         `json:"resourceVersion"`
+	Allocations     map[string]map[string][]string `json:"allocations"`
+	Capacity        map[string]ProfileCapacity     `json:"capacity"`
+	Warm            []string                       `json:"warm,omitempty"`
+	Released        map[string]metav1.Time         `json:"released,omitempty"`
+}
+
+// NewDebugHandler creates a handler serving the allocation snapshot, for troubleshooting. It is meant to be registered
+// as an extra handler on the metrics server, which is responsible for protecting it.
+func NewDebugHandler(hwmgr *HwMgrService) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DebugAllocationsPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Read the resources and allocations together, so the snapshot is consistent
+		cm, resources, allocations, err := hwmgr.GetCurrentResources(r.Context())
+		if err != nil {
+			hwmgr.logger.ErrorContext(r.Context(), "debug request failed", "path", r.URL.Path, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		snapshot := AllocationSnapshot{
+			ResourceVersion: cm.ResourceVersion,
+			Allocations:     make(map[string]map[string][]string),
+			Capacity:        computeCapacity(resources, allocations),
+			Warm:            allocations.Warm,
+			Released:        allocations.Released,
+		}
+		for _, cloud := range allocations.Clouds {
+			snapshot.Allocations[cloud.CloudID] = cloud.Nodegroups
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+			hwmgr.logger.ErrorContext(r.Context(), "failed to encode debug response", "path", r.URL.Path, "error", err)
+		}
+	})
+
+	return mux
+}
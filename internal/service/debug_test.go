@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DebugHandler", func() {
+	get := func(handler http.Handler, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, DebugAllocationsPath, nil).WithContext(context.Background())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("returns the allocation snapshot", func() {
+		allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+      worker:
+        - node-b-1
+warm:
+  - node-a-1
+`
+		c := newFakeClientBuilder(newNodelistConfigMap(testResources, allocations)).Build()
+		handler := NewDebugHandler(newTestService(c))
+
+		rec := get(handler, http.MethodGet)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var snapshot AllocationSnapshot
+		Expect(json.Unmarshal(rec.Body.Bytes(), &snapshot)).To(Succeed())
+		Expect(snapshot.ResourceVersion).ToNot(BeEmpty())
+		Expect(snapshot.Allocations).To(Equal(map[string]map[string][]string{
+			"cloud-1": {"master": {"node-a-0"}, "worker": {"node-b-1"}},
+		}))
+		Expect(snapshot.Warm).To(Equal([]string{"node-a-1"}))
+		Expect(snapshot.Capacity).To(Equal(map[string]ProfileCapacity{
+			"profile-a": {Total: 4, Allocated: 1, Free: 3},
+			"profile-b": {Total: 2, Allocated: 1, Free: 1},
+		}))
+	})
+
+	It("rejects methods other than GET", func() {
+		c := newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build()
+		Expect(get(NewDebugHandler(newTestService(c)), http.MethodPost).Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Actions recorded in the allocation history
+const (
+	historyActionAllocated = "allocated"
+	historyActionReleased  = "released"
+)
+
+// cmHistoryEntry records an allocation or release of a node in the allocation history of the nodelist configmap
+type cmHistoryEntry struct {
+	Time      metav1.Time `json:"time" yaml:"time"`
+	Action    string      `json:"action" yaml:"action"`
+	CloudID   string      `json:"cloudID" yaml:"cloudID"`
+	Nodegroup string      `json:"nodegroup" yaml:"nodegroup"`
+	Node      string      `json:"node" yaml:"node"`
+}
+
+// historyEnabled reports whether the allocation history is recorded, which is the case if any retention is configured
+func (h *HwMgrService) historyEnabled() bool {
+	return h.historyLimit > 0 || h.historyMaxAge > 0
+}
+
+// recordHistory appends an entry to the allocation history, if it is enabled
+func (h *HwMgrService) recordHistory(allocations *cmAllocations, action, cloudID, nodegroup, nodename string) {
+	if !h.historyEnabled() {
+		return
+	}
+
+	allocations.History = append(allocations.History, cmHistoryEntry{
+		Time:      metav1.NewTime(h.clock.Now()),
+		Action:    action,
+		CloudID:   cloudID,
+		Nodegroup: nodegroup,
+		Node:      nodename,
+	})
+}
+
+// pruneHistory drops the entries of the allocation history that are older than the maximum age, then the oldest of the
+// remaining entries beyond the limit on their number
+func (h *HwMgrService) pruneHistory(allocations *cmAllocations) {
+	if h.historyMaxAge > 0 {
+		cutoff := h.clock.Now().Add(-h.historyMaxAge)
+		allocations.History = slices.DeleteFunc(allocations.History, func(entry cmHistoryEntry) bool {
+			return entry.Time.Time.Before(cutoff)
+		})
+	}
+
+	if h.historyLimit > 0 && len(allocations.History) > h.historyLimit {
+		allocations.History = slices.Delete(allocations.History, 0, len(allocations.History)-h.historyLimit)
+	}
+
+	if len(allocations.History) == 0 {
+		allocations.History = nil
+	}
+}
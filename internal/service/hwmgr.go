@@ -1,21 +1,32 @@
 package service
 
 import (
+	"cmp"
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
 	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/clock"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/yaml"
 )
 
@@ -24,6 +35,33 @@ type cmBmcInfo struct {
 	Address        string `json:"address,omitempty"`
 	UsernameBase64 string `json:"username-base64,omitempty"`
 	PasswordBase64 string `json:"password-base64,omitempty"`
+
+	// Username and Password, if set, are plaintext credentials that are used as-is, rather than the base64 encoded ones.
+	// They are meant for lab inventories, where encoding the credentials is an inconvenience.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// CredentialsSecretRef, if set, is the name of an existing Secret in the plugin namespace that holds the credentials,
+	// which is referenced by the Node CR as-is, rather than the plugin writing its own bmc-secret from the inventory
+	CredentialsSecretRef string `json:"credentialsSecretRef,omitempty"`
+
+	// Protocol is the protocol spoken by the BMC, either redfish or ipmi. It defaults to redfish, as assumed before it
+	// could be set.
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// Protocols spoken by the BMCs of the nodes in the inventory
+const (
+	BMCProtocolRedfish = "redfish"
+	BMCProtocolIPMI    = "ipmi"
+)
+
+// protocol gets the protocol spoken by the BMC, defaulting to redfish if unspecified
+func (b *cmBmcInfo) protocol() string {
+	if b.Protocol == "" {
+		return BMCProtocolRedfish
+	}
+	return b.Protocol
 }
 
 type cmNodeInfo struct {
@@ -31,11 +69,43 @@ type cmNodeInfo struct {
 	BMC        *cmBmcInfo                  `json:"bmc,omitempty"`
 	Interfaces []*hwmgmtv1alpha1.Interface `json:"interfaces,omitempty"`
 	Hostname   string                      `json:"hostname,omitempty"`
+	Serial     string                      `json:"serial,omitempty"`
+	Rack       string                      `json:"rack,omitempty"`
+
+	// Accelerators is the number of GPUs or other accelerators in the node, for nodegroups that request a number of
+	// accelerators rather than nodes
+	Accelerators int `json:"accelerators,omitempty"`
+
+	// FirmwareCompliant indicates that the firmware of the node is at the required level, for NodePools that require
+	// compliant nodes
+	FirmwareCompliant bool `json:"firmwareCompliant,omitempty"`
+
+	// ProvisionTime, if set, is the estimated time taken to provision the node after it is allocated, overriding the
+	// default allocation delay
+	ProvisionTime *metav1.Duration `json:"provisionTime,omitempty"`
+
+	// PowerDraw is the power drawn by the node while powered on, in watts, counted against the power cap of its rack
+	PowerDraw int `json:"powerDraw,omitempty"`
+
+	// PoweredOn indicates that the node is currently powered on, so that it is provisioned faster than a node that must
+	// first be powered up, for allocations that prefer powered-on nodes
+	PoweredOn bool `json:"poweredOn,omitempty"`
+
+	// Labels are set on the Node CR of the node, such as to identify its site or model. Labels under the oran-hwmgr/
+	// prefix are reserved for the plugin.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type cmResources struct {
 	HwProfiles []string              `json:"hwprofiles" yaml:"hwprofiles"`
 	Nodes      map[string]cmNodeInfo `json:"nodes" yaml:"nodes"`
+
+	// CordonedProfiles lists the hardware profiles from which no further nodes are allocated, such as while their
+	// hardware is under maintenance
+	CordonedProfiles []string `json:"cordonedProfiles,omitempty" yaml:"cordonedProfiles,omitempty"`
+
+	// RackPowerCaps is the power budget of each rack, in watts, which the nodes in use in the rack may not exceed
+	RackPowerCaps map[string]int `json:"rackPowerCaps,omitempty" yaml:"rackPowerCaps,omitempty"`
 }
 
 type cmAllocatedCloud struct {
@@ -45,232 +115,1849 @@ type cmAllocatedCloud struct {
 
 type cmAllocations struct {
 	Clouds []cmAllocatedCloud `json:"clouds" yaml:"clouds"`
+
+	// Released records when nodes were last released, while they are within the release cooldown
+	Released map[string]metav1.Time `json:"released,omitempty" yaml:"released,omitempty"`
+
+	// Warm lists the free nodes that have been pre-provisioned for the warm pool of their hardware profile
+	Warm []string `json:"warm,omitempty" yaml:"warm,omitempty"`
+
+	// History records the allocations and releases of nodes, oldest first, within the configured retention
+	History []cmHistoryEntry `json:"history,omitempty" yaml:"history,omitempty"`
+}
+
+// NodeFinalizer is set on the Node CRs created by the plugin, so that a deletion by anyone else can be handled by
+// releasing the node, and so that a Node CR is never gone while its bmc-secret remains
+const NodeFinalizer = "oran-hwmgr-plugin-test.oran.openshift.io/node-finalizer"
+
+// Reasons for the events emitted on NodePool CRs
+const (
+	EventReasonAllocationPlanned = "AllocationPlanned"
+	EventReasonNodeAllocated     = "NodeAllocated"
+	EventReasonAllocationFailed  = "AllocationFailed"
+)
+
+// Defaults for the name of the nodelist configmap and the keys of its node inventory and allocations data
+const (
+	defaultResourcesKey   = "resources"
+	defaultAllocationsKey = "allocations"
+	defaultCMName         = "nodelist"
+)
+
+// defaultAllocationDelay is the time taken to provision a node after it is allocated
+const defaultAllocationDelay = 10 * time.Second
+
+// defaultAllocationTimeout bounds the apiserver operations that allocate a node
+const defaultAllocationTimeout = 30 * time.Second
+
+// defaultInventoryReadBackoff bounds the retries of transient errors when reading the nodelist configmap
+var defaultInventoryReadBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: 100 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
+// defaultConflictRetries bounds the retries of an allocation whose update of the nodelist configmap conflicts with a
+// concurrent modification
+const defaultConflictRetries = 4
+
+// defaultMaxConfigMapSize is the default limit on the data size of the nodelist configmap, leaving headroom below the
+// 1MiB object size limit enforced by the apiserver
+const defaultMaxConfigMapSize = 900 * 1024
+
+// Define the HwMgrService structures
+type HwMgrServiceBuilder struct {
+	client.Client
+	reader             client.Reader
+	logger             *slog.Logger
+	maxConfigMapSize   int
+	releaseCooldown    time.Duration
+	clock              clock.PassiveClock
+	validateInventory  bool
+	strictInventory    bool
+	inventoryBackoff   *wait.Backoff
+	conflictRetries    *int
+	allocationDelay    *time.Duration
+	allocationTimeout  *time.Duration
+	nodeNameFunc       func(string) string
+	recorder           record.EventRecorder
+	nodeSortKeys       []string
+	preferAdjacent     bool
+	preferPoweredOn    bool
+	maxProvisions      int
+	releaseVerifier    ReleaseVerifier
+	credentialVerifier CredentialVerifier
+	historyLimit       int
+	historyMaxAge      time.Duration
+	allocator          NodeAllocator
+	cmName             string
+	resourcesKey       string
+	allocationsKey     string
+	inventoryCacheTTL  time.Duration
+}
+
+type HwMgrService struct {
+	client.Client
+	logger    *slog.Logger
+	namespace string
+
+	// reader is used by the read-only queries of the nodelist configmap, such as from a cache, while the reads that
+	// lead to an update of the configmap go through the client
+	reader client.Reader
+
+	// cmName is the name of the nodelist configmap, and resourcesKey and allocationsKey are the keys of its node
+	// inventory and allocations data, so that separate plugin instances can manage separate inventories
+	cmName         string
+	resourcesKey   string
+	allocationsKey string
+
+	// allocationDelay is the simulated time taken to provision a node after it is allocated. Rather than blocking the
+	// reconcile, the NodePool is requeued to complete the provisioning once the delay has elapsed.
+	allocationDelay time.Duration
+
+	// allocationTimeout bounds the creation of the Node CR and bmc-secret of each allocated node, independently of the
+	// allocation delay, so that a slow apiserver fails the allocation rather than stalling the reconcile
+	allocationTimeout time.Duration
+
+	// maxConfigMapSize is the limit, in bytes, on the data size of the nodelist configmap
+	maxConfigMapSize int
+
+	// releaseCooldown is the period after a node is released during which it is not allocated again
+	releaseCooldown time.Duration
+	clock           clock.PassiveClock
+
+	// validateInventory enables checking the nodelist configmap against the bundled schema whenever it is read
+	validateInventory bool
+
+	// strictInventory enables rejecting unknown fields in the inventory data whenever it is read
+	strictInventory bool
+
+	// inventoryBackoff bounds the retries of transient errors when reading the nodelist configmap
+	inventoryBackoff wait.Backoff
+
+	// conflictBackoff bounds the retries of an update of the nodelist configmap, such as an allocation or release, that
+	// conflicts with a concurrent modification
+	conflictBackoff wait.Backoff
+
+	// nodeNameFunc maps the inventory key of a node to the name of its Node CR
+	nodeNameFunc func(string) string
+
+	// recorder, if set, is used to emit events on the NodePool CRs as nodes are allocated
+	recorder record.EventRecorder
+
+	// nodeSortKeys are the keys by which ties between candidate nodes are broken, after the warm pool preference
+	nodeSortKeys []string
+
+	// preferAdjacent orders the candidate nodes for a NodePool by their distance, in name order, from the nodes already
+	// allocated to it, ahead of the sort keys
+	preferAdjacent bool
+
+	// preferPoweredOn orders the candidate nodes that are already powered on ahead of those that are powered off, after
+	// the warm pool preference
+	preferPoweredOn bool
+
+	// provisionSlots, if set, bounds the number of nodes being provisioned at the same time across all NodePools
+	provisionSlots chan struct{}
+
+	// releaseVerifier, if set, must confirm that the nodes of a released NodePool are powered off before they are freed
+	releaseVerifier ReleaseVerifier
+
+	// credentialVerifier, if set, checks that the bmc-secret credentials of the allocated nodes are still valid
+	credentialVerifier CredentialVerifier
+
+	// historyLimit and historyMaxAge bound the number and age of the entries in the allocation history. The history is
+	// only recorded if either is set.
+	historyLimit  int
+	historyMaxAge time.Duration
+
+	// allocator selects which of the candidate free nodes is allocated to a nodegroup
+	allocator NodeAllocator
+
+	// inventoryCacheTTL, if set, is the time for which the nodelist configmap is reused by read-only queries, which
+	// are served from inventoryCache
+	inventoryCacheTTL time.Duration
+	inventoryCache    inventoryCache
+
+	// allocationLock serializes the read-modify-write of the allocations data in the nodelist configmap, so that
+	// concurrent reconciles of different NodePools do not overwrite each other's allocations
+	allocationLock sync.Mutex
+}
+
+// Functions for creating a new HwMgrService
+func NewHwMgrService() *HwMgrServiceBuilder {
+	return &HwMgrServiceBuilder{}
+}
+
+func (b *HwMgrServiceBuilder) SetClient(
+	value client.Client) *HwMgrServiceBuilder {
+	b.Client = value
+	return b
+}
+
+// SetReadClient sets the client used by the read-only queries of the nodelist configmap, such as a cached client, while
+// the client set by SetClient is used for the updates and the reads leading to them, so it should not be cached. If not
+// set, the client set by SetClient is used for all reads.
+func (b *HwMgrServiceBuilder) SetReadClient(
+	value client.Reader) *HwMgrServiceBuilder {
+	b.reader = value
+	return b
+}
+
+func (b *HwMgrServiceBuilder) SetLogger(
+	value *slog.Logger) *HwMgrServiceBuilder {
+	b.logger = value
+	return b
+}
+
+// SetMaxConfigMapSize sets the limit, in bytes, on the data size of the nodelist configmap. Updates that would exceed
+// it are rejected. If not set, a default slightly below the apiserver object size limit is used.
+func (b *HwMgrServiceBuilder) SetMaxConfigMapSize(
+	value int) *HwMgrServiceBuilder {
+	b.maxConfigMapSize = value
+	return b
+}
+
+// SetReleaseCooldown sets the period after a node is released during which it is not allocated again, to allow for
+// deprovisioning to complete. If not set, released nodes are immediately available.
+func (b *HwMgrServiceBuilder) SetReleaseCooldown(
+	value time.Duration) *HwMgrServiceBuilder {
+	b.releaseCooldown = value
+	return b
+}
+
+// SetClock sets the clock used for time-based decisions. If not set, the real clock is used.
+func (b *HwMgrServiceBuilder) SetClock(
+	value clock.PassiveClock) *HwMgrServiceBuilder {
+	b.clock = value
+	return b
+}
+
+// SetValidateInventory enables checking the nodelist configmap against the bundled schema whenever it is read, so that
+// inventory mistakes are reported with the path to the offending field
+func (b *HwMgrServiceBuilder) SetValidateInventory(
+	value bool) *HwMgrServiceBuilder {
+	b.validateInventory = value
+	return b
+}
+
+// SetStrictInventory enables rejecting the inventory data whenever it is read if it has fields that are not known to the
+// plugin, such as a misspelled field name, which would otherwise be silently ignored
+func (b *HwMgrServiceBuilder) SetStrictInventory(
+	value bool) *HwMgrServiceBuilder {
+	b.strictInventory = value
+	return b
+}
+
+// SetInventoryCacheTTL sets the time for which the nodelist configmap, once read, is reused by read-only queries, such
+// as those made repeatedly within a reconcile. The cache is invalidated whenever the plugin updates the configmap, and
+// reads that lead to an update always read it afresh. If not set, the configmap is read on every query.
+func (b *HwMgrServiceBuilder) SetInventoryCacheTTL(
+	value time.Duration) *HwMgrServiceBuilder {
+	b.inventoryCacheTTL = value
+	return b
+}
+
+// SetInventoryBackoff sets the backoff for retrying transient apiserver errors when reading the nodelist configmap to
+// admit a new NodePool. If not set, a default of a few retries within a few seconds is used.
+func (b *HwMgrServiceBuilder) SetInventoryBackoff(
+	value wait.Backoff) *HwMgrServiceBuilder {
+	b.inventoryBackoff = &value
+	return b
+}
+
+// SetConflictRetries sets the number of times an update of the nodelist configmap, such as an allocation or release, is
+// retried, re-reading the configmap, when it conflicts with a concurrent modification of the configmap. Zero disables
+// the retries. If not set, a default of a few retries is used.
+func (b *HwMgrServiceBuilder) SetConflictRetries(
+	value int) *HwMgrServiceBuilder {
+	b.conflictRetries = &value
+	return b
+}
+
+// SetAllocationDelay sets the simulated time taken to provision a node after it is allocated, unless the node gives its
+// own estimate in the inventory. Zero provisions the nodes immediately. If not set, a default of 10 seconds is used.
+func (b *HwMgrServiceBuilder) SetAllocationDelay(
+	value time.Duration) *HwMgrServiceBuilder {
+	b.allocationDelay = &value
+	return b
+}
+
+// SetAllocationTimeout sets the time allowed for the apiserver operations that create the Node CR and bmc-secret of
+// each allocated node, separately from the simulated allocation delay. Zero disables the timeout. If not set, a default
+// of 30 seconds is used.
+func (b *HwMgrServiceBuilder) SetAllocationTimeout(
+	value time.Duration) *HwMgrServiceBuilder {
+	b.allocationTimeout = &value
+	return b
+}
+
+// SetNodeNameFunc sets the mapping from the inventory key of a node to the name of its Node CR and bmc-secret. The
+// mapping must produce valid, unique object names. If not set, SanitizeNodeName is used.
+func (b *HwMgrServiceBuilder) SetNodeNameFunc(
+	value func(string) string) *HwMgrServiceBuilder {
+	b.nodeNameFunc = value
+	return b
+}
+
+// SetEventRecorder sets the recorder used to emit events on NodePool CRs. If not set, no events are emitted.
+func (b *HwMgrServiceBuilder) SetEventRecorder(
+	value record.EventRecorder) *HwMgrServiceBuilder {
+	b.recorder = value
+	return b
+}
+
+// SetNodeSortKeys sets the keys by which ties between the candidate nodes for a nodegroup are broken, such as rack then
+// name. The node name is always the final key. If not set, the candidates are ordered by name.
+func (b *HwMgrServiceBuilder) SetNodeSortKeys(
+	value []string) *HwMgrServiceBuilder {
+	b.nodeSortKeys = value
+	return b
+}
+
+// SetConfigMapName sets the name of the nodelist configmap holding the node inventory and allocations. If not set, it
+// is "nodelist".
+func (b *HwMgrServiceBuilder) SetConfigMapName(
+	value string) *HwMgrServiceBuilder {
+	b.cmName = value
+	return b
 }
 
-const (
-	resourcesKey   = "resources"
-	allocationsKey = "allocations"
-	cmName         = "nodelist"
-)
+// SetResourcesKey sets the key of the node inventory data in the nodelist configmap. If not set, it is "resources".
+func (b *HwMgrServiceBuilder) SetResourcesKey(
+	value string) *HwMgrServiceBuilder {
+	b.resourcesKey = value
+	return b
+}
+
+// SetAllocationsKey sets the key of the allocations data in the nodelist configmap. If not set, it is "allocations".
+func (b *HwMgrServiceBuilder) SetAllocationsKey(
+	value string) *HwMgrServiceBuilder {
+	b.allocationsKey = value
+	return b
+}
+
+// SetPreferAdjacentNodes sets whether the candidate nodes closest, in name order, to the nodes already allocated to a
+// NodePool are preferred when it is scaled up, keeping its nodes contiguous. If not set, the sort keys alone apply.
+func (b *HwMgrServiceBuilder) SetPreferAdjacentNodes(
+	value bool) *HwMgrServiceBuilder {
+	b.preferAdjacent = value
+	return b
+}
+
+// SetPreferPoweredOnNodes sets whether the candidate nodes already powered on are preferred over those that are
+// powered off, reducing the provisioning time. If not set, the power state of the nodes is ignored.
+func (b *HwMgrServiceBuilder) SetPreferPoweredOnNodes(
+	value bool) *HwMgrServiceBuilder {
+	b.preferPoweredOn = value
+	return b
+}
+
+// SetMaxConcurrentProvisions sets the maximum number of nodes that are provisioned at the same time across all
+// NodePools, for external systems that can only handle a limited number at once. If not set, there is no limit.
+func (b *HwMgrServiceBuilder) SetMaxConcurrentProvisions(
+	value int) *HwMgrServiceBuilder {
+	b.maxProvisions = value
+	return b
+}
+
+// SetReleaseVerifier sets the verifier that must confirm that the nodes of a released NodePool are powered off before
+// they are freed for allocation. If not set, released nodes are freed immediately.
+func (b *HwMgrServiceBuilder) SetReleaseVerifier(
+	value ReleaseVerifier) *HwMgrServiceBuilder {
+	b.releaseVerifier = value
+	return b
+}
+
+// SetCredentialVerifier sets the verifier used by CheckCredentials to check that the bmc-secret credentials of the
+// allocated nodes are still accepted by their BMCs. If not set, credentials are not checked.
+func (b *HwMgrServiceBuilder) SetCredentialVerifier(
+	value CredentialVerifier) *HwMgrServiceBuilder {
+	b.credentialVerifier = value
+	return b
+}
+
+// SetHistoryLimit sets the maximum number of entries kept in the allocation history of the nodelist configmap, dropping
+// the oldest ones beyond it. If neither this nor the maximum age is set, no history is recorded.
+func (b *HwMgrServiceBuilder) SetHistoryLimit(
+	value int) *HwMgrServiceBuilder {
+	b.historyLimit = value
+	return b
+}
+
+// SetHistoryMaxAge sets the age after which entries are dropped from the allocation history of the nodelist configmap.
+// If neither this nor the limit on the number of entries is set, no history is recorded.
+func (b *HwMgrServiceBuilder) SetHistoryMaxAge(
+	value time.Duration) *HwMgrServiceBuilder {
+	b.historyMaxAge = value
+	return b
+}
+
+// SetAllocator sets the strategy for selecting which of the candidate free nodes is allocated to a nodegroup. If not
+// set, the most preferred candidate is selected.
+func (b *HwMgrServiceBuilder) SetAllocator(
+	value NodeAllocator) *HwMgrServiceBuilder {
+	b.allocator = value
+	return b
+}
+
+func (b *HwMgrServiceBuilder) Build(ctx context.Context) (
+	result *HwMgrService, err error) {
+	if b.logger == nil {
+		err = errors.New("logger is mandatory")
+		return
+	}
+
+	if b.maxConfigMapSize < 0 {
+		err = errors.New("max configmap size must not be negative")
+		return
+	}
+
+	if b.releaseCooldown < 0 {
+		err = errors.New("release cooldown must not be negative")
+		return
+	}
+
+	if b.inventoryCacheTTL < 0 {
+		err = errors.New("inventory cache TTL must not be negative")
+		return
+	}
+
+	if b.conflictRetries != nil && *b.conflictRetries < 0 {
+		err = errors.New("conflict retries must not be negative")
+		return
+	}
+
+	if b.allocationDelay != nil && *b.allocationDelay < 0 {
+		err = errors.New("allocation delay must not be negative")
+		return
+	}
+
+	if b.allocationTimeout != nil && *b.allocationTimeout < 0 {
+		err = errors.New("allocation timeout must not be negative")
+		return
+	}
+
+	if b.historyLimit < 0 {
+		err = errors.New("history limit must not be negative")
+		return
+	}
+
+	if b.historyMaxAge < 0 {
+		err = errors.New("history max age must not be negative")
+		return
+	}
+
+	if b.maxProvisions < 0 {
+		err = errors.New("max concurrent provisions must not be negative")
+		return
+	}
+
+	for _, key := range b.nodeSortKeys {
+		if _, exists := nodeSortFields[key]; !exists {
+			err = fmt.Errorf("invalid node sort key %q", key)
+			return
+		}
+	}
+
+	service := &HwMgrService{
+		Client:             b.Client,
+		logger:             b.logger,
+		namespace:          os.Getenv("MY_POD_NAMESPACE"),
+		reader:             b.reader,
+		cmName:             b.cmName,
+		resourcesKey:       b.resourcesKey,
+		allocationsKey:     b.allocationsKey,
+		allocationDelay:    defaultAllocationDelay,
+		allocationTimeout:  defaultAllocationTimeout,
+		maxConfigMapSize:   b.maxConfigMapSize,
+		releaseCooldown:    b.releaseCooldown,
+		clock:              b.clock,
+		validateInventory:  b.validateInventory,
+		strictInventory:    b.strictInventory,
+		inventoryBackoff:   defaultInventoryReadBackoff,
+		conflictBackoff:    retry.DefaultRetry,
+		nodeNameFunc:       b.nodeNameFunc,
+		recorder:           b.recorder,
+		nodeSortKeys:       b.nodeSortKeys,
+		preferAdjacent:     b.preferAdjacent,
+		preferPoweredOn:    b.preferPoweredOn,
+		releaseVerifier:    b.releaseVerifier,
+		credentialVerifier: b.credentialVerifier,
+		historyLimit:       b.historyLimit,
+		historyMaxAge:      b.historyMaxAge,
+		allocator:          b.allocator,
+		inventoryCacheTTL:  b.inventoryCacheTTL,
+	}
+	if service.reader == nil {
+		service.reader = b.Client
+	}
+	if service.cmName == "" {
+		service.cmName = defaultCMName
+	}
+	if service.resourcesKey == "" {
+		service.resourcesKey = defaultResourcesKey
+	}
+	if service.allocationsKey == "" {
+		service.allocationsKey = defaultAllocationsKey
+	}
+	if service.resourcesKey == service.allocationsKey {
+		err = fmt.Errorf("resources and allocations keys must differ, both are %q", service.resourcesKey)
+		return
+	}
+	if service.maxConfigMapSize == 0 {
+		service.maxConfigMapSize = defaultMaxConfigMapSize
+	}
+	if service.clock == nil {
+		service.clock = clock.RealClock{}
+	}
+	if b.inventoryBackoff != nil {
+		service.inventoryBackoff = *b.inventoryBackoff
+	}
+	service.conflictBackoff.Steps = defaultConflictRetries + 1
+	if b.conflictRetries != nil {
+		service.conflictBackoff.Steps = *b.conflictRetries + 1
+	}
+	if b.allocationDelay != nil {
+		service.allocationDelay = *b.allocationDelay
+	}
+	if b.allocationTimeout != nil {
+		service.allocationTimeout = *b.allocationTimeout
+	}
+	if service.allocator == nil {
+		service.allocator = firstAvailableAllocator{}
+	}
+	if service.nodeNameFunc == nil {
+		service.nodeNameFunc = SanitizeNodeName
+	}
+	if b.maxProvisions > 0 {
+		service.provisionSlots = make(chan struct{}, b.maxProvisions)
+	}
+
+	result = service
+	return
+}
+
+// Namespace returns the namespace in which the service manages the nodelist configmap, Node CRs, and bmc-secrets
+func (h *HwMgrService) Namespace() string {
+	return h.namespace
+}
+
+// nodeFilter reports whether a free node is a candidate for allocation
+type nodeFilter func(nodename string, node cmNodeInfo) bool
+
+// excludeNodes returns a nodeFilter that rejects the specified nodes
+func excludeNodes(nodenames []string) nodeFilter {
+	return func(nodename string, _ cmNodeInfo) bool {
+		return !slices.Contains(nodenames, nodename)
+	}
+}
+
+// requireSerials returns a nodeFilter that accepts only the nodes with the specified serial numbers
+func requireSerials(serials []string) nodeFilter {
+	return func(_ string, node cmNodeInfo) bool {
+		return node.Serial != "" && slices.Contains(serials, node.Serial)
+	}
+}
+
+// requireFirmwareCompliance is a nodeFilter that accepts only the nodes whose firmware is compliant
+func requireFirmwareCompliance(_ string, node cmNodeInfo) bool {
+	return node.FirmwareCompliant
+}
+
+// requireBMC is a nodeFilter that accepts only the nodes with a BMC address
+func requireBMC(_ string, node cmNodeInfo) bool {
+	return node.BMC != nil && node.BMC.Address != ""
+}
+
+// releaseCooldown returns a nodeFilter that rejects nodes released less than the cooldown period before now
+func releaseCooldown(released map[string]metav1.Time, now time.Time, cooldown time.Duration) nodeFilter {
+	return func(nodename string, _ cmNodeInfo) bool {
+		releaseTime, exists := released[nodename]
+		return !exists || !now.Before(releaseTime.Add(cooldown))
+	}
+}
+
+// candidateFilter is a nodeFilter labelled with the reason recorded in the allocation metrics for the nodes it rejects
+type candidateFilter struct {
+	reason string
+	accept nodeFilter
+}
+
+// inventoryFilters gets the filters to be applied to the candidate nodes for any NodePool
+func (h *HwMgrService) inventoryFilters(allocations cmAllocations) (filters []candidateFilter) {
+	if h.releaseCooldown > 0 && len(allocations.Released) > 0 {
+		filters = append(filters, candidateFilter{filteredReasonCooldown,
+			releaseCooldown(allocations.Released, h.clock.Now(), h.releaseCooldown)})
+	}
+
+	return
+}
+
+// nodegroupFilters gets the filters requested by a NodePool for the candidate nodes of the specified nodegroup
+func nodegroupFilters(nodepool *hwmgmtv1alpha1.NodePool, nodegroup hwmgmtv1alpha1.NodeGroup) (filters []candidateFilter) {
+	if excluded := utils.GetListAnnotation(nodepool, utils.ExcludeNodesAnnotationPrefix+nodegroup.Name); len(excluded) > 0 {
+		filters = append(filters, candidateFilter{filteredReasonExcluded, excludeNodes(excluded)})
+	}
+
+	if serials := utils.GetListAnnotation(nodepool, utils.SerialsAnnotationPrefix+nodegroup.Name); len(serials) > 0 {
+		filters = append(filters, candidateFilter{filteredReasonSerial, requireSerials(serials)})
+	}
+
+	if acceleratorTarget(nodepool, nodegroup) > 0 {
+		filters = append(filters, candidateFilter{filteredReasonAccelerators, requireAccelerators})
+	}
+
+	if utils.IsAnnotationTrue(nodepool, utils.RequireFirmwareComplianceAnnotation) {
+		filters = append(filters, candidateFilter{filteredReasonFirmware, requireFirmwareCompliance})
+	}
+
+	if utils.IsAnnotationTrue(nodepool, utils.RequireBMCAnnotation) {
+		filters = append(filters, candidateFilter{filteredReasonBMC, requireBMC})
+	}
+
+	return
+}
+
+// nodepoolFilters gets the filters requested by a NodePool for the candidate nodes of all of its nodegroups, given the
+// nodes already allocated to it
+func nodepoolFilters(resources cmResources, allocations cmAllocations, nodepool *hwmgmtv1alpha1.NodePool) (filters []candidateFilter) {
+	if limit := maxPerRack(nodepool); limit > 0 {
+		filters = append(filters, candidateFilter{filteredReasonRack,
+			rackLimit(rackCounts(resources, allocations, nodepool.Spec.CloudID), limit)})
+	}
+
+	return
+}
+
+// nodeFilters gets all filters to be applied to the candidate nodes for the specified nodegroup of a NodePool
+func (h *HwMgrService) nodeFilters(resources cmResources, allocations cmAllocations,
+	nodepool *hwmgmtv1alpha1.NodePool, nodegroup hwmgmtv1alpha1.NodeGroup) []candidateFilter {
+	filters := append(h.inventoryFilters(allocations), powerFilters(resources, allocations)...)
+	filters = append(filters, nodepoolFilters(resources, allocations, nodepool)...)
+	return append(filters, nodegroupFilters(nodepool, nodegroup)...)
+}
+
+// getNodesInUse gets the set of nodes allocated to any cloud
+func getNodesInUse(allocations cmAllocations) map[string]bool {
+	inuse := make(map[string]bool)
+	for _, cloud := range allocations.Clouds {
+		for groupname := range cloud.Nodegroups {
+			for _, nodename := range cloud.Nodegroups[groupname] {
+				inuse[nodename] = true
+			}
+		}
+	}
+	return inuse
+}
+
+// getFreeNodesInProfile compares the parsed configmap data to get the list of free nodes for a given hardware profile,
+// sorted by name. Nodes rejected by any of the specified filters are omitted.
+func getFreeNodesInProfile(resources cmResources, allocations cmAllocations, profname string, filters ...candidateFilter) (freenodes []string) {
+	inuse := getNodesInUse(allocations)
+
+	for nodename, node := range resources.Nodes {
+		if node.HwProfile != profname {
+			continue
+		}
+
+		if _, used := inuse[nodename]; used {
+			continue
+		}
+
+		if rejectedBy(nodename, node, filters) == "" {
+			freenodes = append(freenodes, nodename)
+		}
+	}
+
+	slices.Sort(freenodes)
+	return
+}
+
+// rejectedBy gets the reason of the first of the filters to reject a node, or an empty string if it is accepted by all
+func rejectedBy(nodename string, node cmNodeInfo, filters []candidateFilter) string {
+	for _, filter := range filters {
+		if !filter.accept(nodename, node) {
+			return filter.reason
+		}
+	}
+	return ""
+}
+
+// insufficientResourcesError reports a shortfall of free nodes for a nodegroup, with hints on how it can be resolved
+// based on the current inventory
+func (h *HwMgrService) insufficientResourcesError(resources cmResources, allocations cmAllocations,
+	nodepool *hwmgmtv1alpha1.NodePool, nodegroup hwmgmtv1alpha1.NodeGroup, needed int) *InsufficientResourcesError {
+	freenodes := len(capRackPower(resources, allocations, capPerRack(resources, allocations, nodepool,
+		getFreeNodesInProfile(resources, allocations, nodegroup.HwProfile,
+			h.nodeFilters(resources, allocations, nodepool, nodegroup)...))))
+	unpoweredFilters := append(h.inventoryFilters(allocations), nodepoolFilters(resources, allocations, nodepool)...)
+	unpoweredFilters = append(unpoweredFilters, nodegroupFilters(nodepool, nodegroup)...)
+	unpowered := len(capPerRack(resources, allocations, nodepool,
+		getFreeNodesInProfile(resources, allocations, nodegroup.HwProfile, unpoweredFilters...)))
+	unlimited := len(getFreeNodesInProfile(resources, allocations, nodegroup.HwProfile,
+		append(h.inventoryFilters(allocations), nodegroupFilters(nodepool, nodegroup)...)...))
+	available := len(getFreeNodesInProfile(resources, allocations, nodegroup.HwProfile, h.inventoryFilters(allocations)...))
+	unallocated := len(getFreeNodesInProfile(resources, allocations, nodegroup.HwProfile))
+	shortfall := needed - freenodes
+
+	hints := []string{fmt.Sprintf("add %d node(s) to hardware profile %s", shortfall, nodegroup.HwProfile)}
+	if coolingDown := unallocated - available; coolingDown > 0 {
+		hints = append(hints, fmt.Sprintf("wait for %d released node(s) to complete the %s release cooldown",
+			min(shortfall, coolingDown), h.releaseCooldown))
+	}
+	if excluded := available - unlimited; excluded > 0 {
+		hints = append(hints, fmt.Sprintf("allow %d of the %d free node(s) excluded from nodegroup %s",
+			min(shortfall, excluded), excluded, nodegroup.Name))
+	}
+	if limit := maxPerRack(nodepool); limit > 0 && unlimited > unpowered {
+		hints = append(hints, fmt.Sprintf("raise the limit of %d node(s) per rack", limit))
+	}
+	if overBudget := unpowered - freenodes; overBudget > 0 {
+		hints = append(hints, fmt.Sprintf("raise the power caps of the racks of %d free node(s)", min(shortfall, overBudget)))
+	}
+	if size := nodegroup.Size - shortfall; size > 0 && acceleratorTarget(nodepool, nodegroup) == 0 {
+		hints = append(hints, fmt.Sprintf("reduce the size of nodegroup %s to %d", nodegroup.Name, size))
+	}
+
+	return &InsufficientResourcesError{
+		NodeGroup: nodegroup.Name,
+		HwProfile: nodegroup.HwProfile,
+		FreeNodes: freenodes,
+		Needed:    needed,
+		Hints:     hints,
+	}
+}
+
+// InsufficientResourcesError reports that there are too few free nodes to allocate a nodegroup, which cannot be
+// resolved by retrying until the inventory or the NodePool changes
+type InsufficientResourcesError struct {
+	NodeGroup string
+	HwProfile string
+	FreeNodes int
+	Needed    int
+	Hints     []string
+}
+
+func (e *InsufficientResourcesError) Error() string {
+	return fmt.Sprintf("not enough free resources in group %s: freenodes=%d, needed=%d: %s",
+		e.HwProfile, e.FreeNodes, e.Needed, strings.Join(e.Hints, ", or "))
+}
+
+// ExceedsCapacityError reports that a nodegroup requests more nodes than its hardware profile has in the inventory,
+// allocated or not, so that it cannot be satisfied however long it waits, until the inventory or the NodePool changes
+type ExceedsCapacityError struct {
+	NodeGroup string
+	HwProfile string
+	Capacity  int
+	Size      int
+}
+
+func (e *ExceedsCapacityError) Error() string {
+	return fmt.Sprintf("nodegroup %s requests %d node(s), but hardware profile %s has only %d node(s) in the inventory",
+		e.NodeGroup, e.Size, e.HwProfile, e.Capacity)
+}
+
+// checkProfileCapacity returns an ExceedsCapacityError if a nodegroup requests more nodes than its hardware profile has
+// in the inventory. A nodegroup requesting a number of accelerators is checked against its free candidates instead.
+func checkProfileCapacity(resources cmResources, nodepool *hwmgmtv1alpha1.NodePool, nodegroup hwmgmtv1alpha1.NodeGroup) error {
+	if acceleratorTarget(nodepool, nodegroup) > 0 {
+		return nil
+	}
+
+	capacity := 0
+	for _, node := range resources.Nodes {
+		if node.HwProfile == nodegroup.HwProfile {
+			capacity++
+		}
+	}
+
+	if nodegroup.Size > capacity {
+		return &ExceedsCapacityError{
+			NodeGroup: nodegroup.Name,
+			HwProfile: nodegroup.HwProfile,
+			Capacity:  capacity,
+			Size:      nodegroup.Size,
+		}
+	}
+	return nil
+}
+
+// InvalidSpecError reports that the nodegroups of a NodePool are malformed, such as a nodegroup without a name, which
+// cannot be resolved by retrying until the NodePool changes
+type InvalidSpecError struct {
+	Reason string
+}
+
+func (e *InvalidSpecError) Error() string {
+	return e.Reason
+}
+
+// UnknownProfileError reports that a nodegroup requests a hardware profile that is not listed in the inventory, which
+// cannot be resolved by retrying until the profile is added or the NodePool changes
+type UnknownProfileError struct {
+	NodeGroup string
+	HwProfile string
+}
+
+func (e *UnknownProfileError) Error() string {
+	return fmt.Sprintf("nodegroup %s requests unknown hardware profile %q", e.NodeGroup, e.HwProfile)
+}
+
+// checkKnownProfile returns an UnknownProfileError if the hardware profile of a nodegroup is not in the inventory
+func checkKnownProfile(resources cmResources, nodegroup hwmgmtv1alpha1.NodeGroup) error {
+	if !slices.Contains(resources.HwProfiles, nodegroup.HwProfile) {
+		return &UnknownProfileError{NodeGroup: nodegroup.Name, HwProfile: nodegroup.HwProfile}
+	}
+	return nil
+}
+
+// ProfileCordonedError reports that a nodegroup requests a hardware profile that is cordoned, which cannot be resolved
+// by retrying until the profile is uncordoned or the NodePool changes
+type ProfileCordonedError struct {
+	NodeGroup string
+	HwProfile string
+}
+
+func (e *ProfileCordonedError) Error() string {
+	return fmt.Sprintf("hardware profile %s requested by nodegroup %s is cordoned", e.HwProfile, e.NodeGroup)
+}
+
+// checkCordonedProfile returns a ProfileCordonedError if the hardware profile of a nodegroup is cordoned
+func checkCordonedProfile(resources cmResources, nodegroup hwmgmtv1alpha1.NodeGroup) error {
+	if slices.Contains(resources.CordonedProfiles, nodegroup.HwProfile) {
+		return &ProfileCordonedError{NodeGroup: nodegroup.Name, HwProfile: nodegroup.HwProfile}
+	}
+	return nil
+}
+
+// ReleasePendingError reports that nodes being released are left allocated until their release can complete, such as
+// while their Node CRs are held by the finalizers of others, so the release must be repeated
+type ReleasePendingError struct {
+	Reason string
+	Nodes  []string
+}
+
+func (e *ReleasePendingError) Error() string {
+	return fmt.Sprintf("release pending, %s: %s", e.Reason, strings.Join(e.Nodes, ", "))
+}
+
+// GetCurrentResources parses the nodelist configmap to get the current available and allocated resource lists. The
+// configmap is always read afresh through the write client, rather than from the inventory cache or the read client, so
+// that it can be updated from the result.
+func (h *HwMgrService) GetCurrentResources(ctx context.Context) (
+	cm *corev1.ConfigMap, resources cmResources, allocations cmAllocations, err error) {
+	if cm, err = h.readConfigMap(ctx, h.Client); err != nil {
+		return
+	}
+
+	resources, allocations, err = h.parseConfigMap(ctx, cm)
+	return
+}
+
+// parseConfigMap parses the available and allocated resource lists from the nodelist configmap
+func (h *HwMgrService) parseConfigMap(ctx context.Context, cm *corev1.ConfigMap) (
+	resources cmResources, allocations cmAllocations, err error) {
+	if h.validateInventory {
+		if err = h.validateConfigMap(cm); err != nil {
+			return
+		}
+	}
+
+	extractResources := utils.ExtractDataFromConfigMap[cmResources]
+	if h.strictInventory {
+		extractResources = utils.ExtractDataFromConfigMapStrict[cmResources]
+	}
+	resources, err = extractResources(cm, h.resourcesKey)
+	if err != nil {
+		err = fmt.Errorf("invalid inventory: %w", err)
+		return
+	}
+
+	// Report malformed credentials as soon as the inventory is read, rather than when their nodes are allocated, without
+	// holding up the nodes whose credentials are fine
+	if credErr := checkBMCCredentials(resources); credErr != nil {
+		h.logger.WarnContext(ctx, "Inventory has malformed BMC credentials", "error", credErr)
+	}
+
+	allocations, err = utils.ExtractDataFromConfigMap[cmAllocations](cm, h.allocationsKey)
+	if err != nil {
+		// Allocated node field may not be present
+		h.logger.InfoContext(ctx, "unable to parse allocations from configmap")
+		err = nil
+		return
+	}
+
+	// Report allocations that double-book a node, such as after a bad manual edit, without failing the read, so that
+	// the releases that repair them can proceed. Further allocations are refused when written.
+	if subErr := checkOverSubscription(allocations); subErr != nil {
+		h.logger.WarnContext(ctx, "Inconsistent allocations in nodelist configmap", "error", subErr)
+	}
+
+	return
+}
+
+// InventoryChanged reports whether an update to a configmap changed the node inventory of the nodelist configmap, as
+// opposed to the allocations and counters maintained by the plugin
+func (h *HwMgrService) InventoryChanged(oldObj, newObj client.Object) bool {
+	oldCM, ok := oldObj.(*corev1.ConfigMap)
+	if !ok || oldCM.Name != h.cmName {
+		return false
+	}
+	newCM, ok := newObj.(*corev1.ConfigMap)
+	if !ok {
+		return false
+	}
+
+	return oldCM.Data[h.resourcesKey] != newCM.Data[h.resourcesKey]
+}
+
+// isTransientError checks whether an apiserver error is likely to be resolved by retrying the request
+func isTransientError(err error) bool {
+	return apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err)
+}
+
+// getCurrentResourcesWithRetry wraps GetCurrentResources, retrying transient apiserver errors with a bounded backoff.
+// Other errors, such as a missing or malformed configmap, are returned immediately.
+func (h *HwMgrService) getCurrentResourcesWithRetry(ctx context.Context) (
+	cm *corev1.ConfigMap, resources cmResources, allocations cmAllocations, err error) {
+	err = retry.OnError(h.inventoryBackoff, isTransientError, func() (err error) {
+		cm, resources, allocations, err = h.GetCurrentResources(ctx)
+		if err != nil && isTransientError(err) {
+			h.logger.InfoContext(ctx, "transient error reading the nodelist configmap, retrying", "err", err)
+		}
+		return
+	})
+	return
+}
+
+// ProfileCapacity summarizes the node usage for a hardware profile
+type ProfileCapacity struct {
+	Total     int `json:"total"`
+	Allocated int `json:"allocated"`
+	Free      int `json:"free"`
+}
+
+// NodeInventory describes a node from the nodelist configmap, excluding its credentials
+type NodeInventory struct {
+	HwProfile  string                      `json:"hwprofile"`
+	Hostname   string                      `json:"hostname,omitempty"`
+	Serial     string                      `json:"serial,omitempty"`
+	Rack       string                      `json:"rack,omitempty"`
+	BMCAddress string                      `json:"bmcAddress,omitempty"`
+	BMC        *BMCAddress                 `json:"bmc,omitempty"`
+	Interfaces []*hwmgmtv1alpha1.Interface `json:"interfaces,omitempty"`
+	CloudID    string                      `json:"cloudID,omitempty"`
+	GroupName  string                      `json:"groupName,omitempty"`
+}
+
+// configMapDataSize gets the size, in bytes, of the data stored in a configmap
+func configMapDataSize(cm *corev1.ConfigMap) (size int) {
+	for key, value := range cm.Data {
+		size += len(key) + len(value)
+	}
+	for key, value := range cm.BinaryData {
+		size += len(key) + len(value)
+	}
+	return
+}
+
+// retryOnConflict repeats an update of the nodelist configmap for as long as it conflicts with a concurrent
+// modification of the configmap, up to the configured number of retries. Each attempt must re-read the configmap and
+// reapply its change to the current allocations.
+func (h *HwMgrService) retryOnConflict(ctx context.Context, attempt func() error, args ...any) error {
+	return retry.RetryOnConflict(h.conflictBackoff, func() error {
+		err := attempt()
+		if apierrors.IsConflict(err) {
+			h.logger.InfoContext(ctx, "conflict updating the nodelist configmap, retrying", args...)
+		}
+		return err
+	})
+}
+
+// updateAllocations writes the allocations data to the nodelist configmap. The write is skipped if the data is
+// unchanged, and rejected if it would grow the configmap beyond the configured size limit, or if it would leave a node
+// over-subscribed while allocating any node.
+func (h *HwMgrService) updateAllocations(ctx context.Context, cm *corev1.ConfigMap, allocations cmAllocations) error {
+	if err := checkOverSubscription(allocations); err != nil {
+		// Allocations that were already inconsistent may still be repaired by releasing nodes
+		previous, _ := utils.ExtractDataFromConfigMap[cmAllocations](cm, h.allocationsKey)
+		if allocatesNodes(previous, allocations) {
+			return fmt.Errorf("refusing to update %s configmap: %w", h.cmName, err)
+		}
+	}
+
+	h.pruneHistory(&allocations)
+
+	yamlString, err := yaml.Marshal(&allocations)
+	if err != nil {
+		return fmt.Errorf("unable to marshal allocated data: %w", err)
+	}
+
+	if current, exists := cm.Data[h.allocationsKey]; exists && current == string(yamlString) {
+		h.logger.DebugContext(ctx, "allocations unchanged, skipping configmap update")
+		return nil
+	}
+
+	updated := cm.DeepCopy()
+	updated.Data[h.allocationsKey] = string(yamlString)
+	if size := configMapDataSize(updated); size > h.maxConfigMapSize {
+		return fmt.Errorf("%s configmap size of %d bytes would exceed the limit of %d bytes: "+
+			"consider sharding the inventory across multiple plugin instances", h.cmName, size, h.maxConfigMapSize)
+	}
+
+	// The cached configmap is dropped once the update is made, or even if it fails, such as on a conflict that shows
+	// the configmap to have been modified since it was read
+	err = h.Client.Update(ctx, updated)
+	h.invalidateInventoryCache()
+	if err != nil {
+		return fmt.Errorf("failed to update configmap: %w", err)
+	}
+
+	*cm = *updated
+	return nil
+}
+
+// checkOverSubscription verifies that no node is allocated more than once across all clouds and nodegroups, or is
+// both allocated and in a warm pool. Each node provides a single unit of capacity, so any repeated allocation
+// over-subscribes it.
+func checkOverSubscription(allocations cmAllocations) error {
+	owners := make(map[string]string)
+	for _, nodename := range allocations.Warm {
+		if _, exists := owners[nodename]; exists {
+			return fmt.Errorf("node %s is listed more than once in the warm pool", nodename)
+		}
+		owners[nodename] = "the warm pool"
+	}
+	for _, cloud := range allocations.Clouds {
+		for groupname, nodes := range cloud.Nodegroups {
+			for _, nodename := range nodes {
+				owner := fmt.Sprintf("%s/%s", cloud.CloudID, groupname)
+				if previous, exists := owners[nodename]; exists {
+					return fmt.Errorf("node %s is over-subscribed: allocated to both %s and %s",
+						nodename, previous, owner)
+				}
+				owners[nodename] = owner
+			}
+		}
+	}
+	return nil
+}
+
+// allocatesNodes reports whether the updated allocations add any node to a nodegroup or to the warm pool, as opposed to
+// only removing nodes from the previous allocations
+func allocatesNodes(previous, updated cmAllocations) bool {
+	assigned := make(map[string]bool)
+	for _, cloud := range previous.Clouds {
+		for groupname, nodes := range cloud.Nodegroups {
+			for _, nodename := range nodes {
+				assigned[cloud.CloudID+"/"+groupname+"/"+nodename] = true
+			}
+		}
+	}
+	for _, cloud := range updated.Clouds {
+		for groupname, nodes := range cloud.Nodegroups {
+			for _, nodename := range nodes {
+				if !assigned[cloud.CloudID+"/"+groupname+"/"+nodename] {
+					return true
+				}
+			}
+		}
+	}
+
+	return slices.ContainsFunc(updated.Warm, func(nodename string) bool {
+		return !slices.Contains(previous.Warm, nodename)
+	})
+}
+
+// GetCapacity returns the total, allocated, and free node counts for each hardware profile
+func (h *HwMgrService) GetCapacity(ctx context.Context) (map[string]ProfileCapacity, error) {
+	_, resources, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	return computeCapacity(resources, allocations), nil
+}
+
+// GetFreeCapacity returns the number of nodes that are currently available for allocation in each hardware profile.
+// Unlike the free count of GetCapacity, nodes still within their release cooldown are not counted.
+func (h *HwMgrService) GetFreeCapacity(ctx context.Context) (map[string]int, error) {
+	_, resources, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	filters := h.inventoryFilters(allocations)
+	free := make(map[string]int, len(resources.HwProfiles))
+	for _, profname := range resources.HwProfiles {
+		free[profname] = len(getFreeNodesInProfile(resources, allocations, profname, filters...))
+	}
+
+	return free, nil
+}
+
+// computeCapacity counts the total, allocated, and free nodes for each hardware profile
+func computeCapacity(resources cmResources, allocations cmAllocations) map[string]ProfileCapacity {
+	capacity := make(map[string]ProfileCapacity)
+	for _, profname := range resources.HwProfiles {
+		capacity[profname] = ProfileCapacity{}
+	}
+
+	for _, node := range resources.Nodes {
+		profile := capacity[node.HwProfile]
+		profile.Total++
+		capacity[node.HwProfile] = profile
+	}
+
+	for profname, profile := range capacity {
+		profile.Free = len(getFreeNodesInProfile(resources, allocations, profname))
+		profile.Allocated = profile.Total - profile.Free
+		capacity[profname] = profile
+	}
+
+	return capacity
+}
+
+// GetAllAllocations returns the allocated nodes for each nodegroup, keyed by cloudID
+func (h *HwMgrService) GetAllAllocations(ctx context.Context) (map[string]map[string][]string, error) {
+	_, _, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	result := make(map[string]map[string][]string)
+	for _, cloud := range allocations.Clouds {
+		result[cloud.CloudID] = cloud.Nodegroups
+	}
+
+	return result, nil
+}
+
+// GetIdleNodes returns the nodes that are not allocated to any cloud, sorted by hardware profile and then by name, so
+// that operators can identify hardware that can be powered down. Nodes in a warm pool are kept ready for allocation,
+// so are not considered idle.
+func (h *HwMgrService) GetIdleNodes(ctx context.Context) ([]string, error) {
+	_, resources, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	inuse := getNodesInUse(allocations)
+	idle := []string{}
+	for nodename := range resources.Nodes {
+		if !inuse[nodename] && !slices.Contains(allocations.Warm, nodename) {
+			idle = append(idle, nodename)
+		}
+	}
+
+	slices.SortFunc(idle, func(a, b string) int {
+		if c := cmp.Compare(resources.Nodes[a].HwProfile, resources.Nodes[b].HwProfile); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	return idle, nil
+}
+
+// GetNodeInventory returns the inventory of all nodes in the nodelist configmap, along with their current allocation
+func (h *HwMgrService) GetNodeInventory(ctx context.Context) (map[string]NodeInventory, error) {
+	_, resources, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	inventory := make(map[string]NodeInventory)
+	for nodename, node := range resources.Nodes {
+		info := NodeInventory{
+			HwProfile:  node.HwProfile,
+			Hostname:   node.Hostname,
+			Serial:     node.Serial,
+			Rack:       node.Rack,
+			Interfaces: node.Interfaces,
+		}
+		if node.BMC != nil {
+			info.BMCAddress = node.BMC.Address
+			if bmc, err := ParseBMCAddress(node.BMC.Address); err == nil {
+				info.BMC = &bmc
+			}
+		}
+		inventory[nodename] = info
+	}
+
+	for _, cloud := range allocations.Clouds {
+		for groupname, nodes := range cloud.Nodegroups {
+			for _, nodename := range nodes {
+				if info, exists := inventory[nodename]; exists {
+					info.CloudID = cloud.CloudID
+					info.GroupName = groupname
+					inventory[nodename] = info
+				}
+			}
+		}
+	}
+
+	return inventory, nil
+}
+
+// ProcessNewNodePool processes a new NodePool CR, verifying that there are enough free resources to satisfy the request
+func (h *HwMgrService) ProcessNewNodePool(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+	cloudID := nodepool.Spec.CloudID
+
+	h.logger.InfoContext(ctx, "Processing ProcessNewNodePool request:",
+		"cloudID", cloudID,
+	)
+
+	if err := checkNodePoolRequest(nodepool); err != nil {
+		return err
+	}
+
+	_, resources, allocations, err := h.getCurrentResourcesWithRetry(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	err = h.validateNodePool(resources, allocations, nodepool)
+	countInsufficientResources(operationAdmission, err)
+	return err
+}
+
+// checkNodePoolRequest verifies that the nodegroups and annotations of a NodePool are valid, independently of the
+// resources available to it
+func checkNodePoolRequest(nodepool *hwmgmtv1alpha1.NodePool) error {
+	if err := checkNodeGroups(nodepool); err != nil {
+		return err
+	}
+
+	for _, nodegroup := range nodepool.Spec.NodeGroup {
+		if _, err := utils.GetIntAnnotation(nodepool, utils.AcceleratorsAnnotationPrefix+nodegroup.Name); err != nil {
+			return err
+		}
+		if _, err := utils.GetIntAnnotation(nodepool, utils.TeardownPriorityAnnotationPrefix+nodegroup.Name); err != nil {
+			return err
+		}
+	}
+	if _, err := utils.GetIntAnnotation(nodepool, utils.MaxPerRackAnnotation); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkNodeGroups returns an InvalidSpecError if a NodePool has no nodegroups, or a nodegroup without a unique name,
+// without a hardware profile, or without a positive size. The size of a nodegroup requesting a number of accelerators
+// is ignored, so it is not checked.
+func checkNodeGroups(nodepool *hwmgmtv1alpha1.NodePool) error {
+	if len(nodepool.Spec.NodeGroup) == 0 {
+		return &InvalidSpecError{Reason: "no nodegroups are specified"}
+	}
+
+	names := make(map[string]bool, len(nodepool.Spec.NodeGroup))
+	for i, nodegroup := range nodepool.Spec.NodeGroup {
+		switch {
+		case nodegroup.Name == "":
+			return &InvalidSpecError{Reason: fmt.Sprintf("nodegroup %d does not specify a name", i)}
+		case names[nodegroup.Name]:
+			return &InvalidSpecError{Reason: fmt.Sprintf("nodegroup %s is specified more than once", nodegroup.Name)}
+		case nodegroup.HwProfile == "":
+			return &InvalidSpecError{Reason: fmt.Sprintf("nodegroup %s does not specify a hardware profile", nodegroup.Name)}
+		case nodegroup.Size <= 0 && acceleratorTarget(nodepool, nodegroup) == 0:
+			return &InvalidSpecError{
+				Reason: fmt.Sprintf("nodegroup %s has size %d, which must be positive", nodegroup.Name, nodegroup.Size),
+			}
+		}
+		names[nodegroup.Name] = true
+	}
+
+	return nil
+}
+
+// validateNodePool verifies that there are enough free resources to complete the allocation of a NodePool, on top of
+// any nodes already allocated to it
+func (h *HwMgrService) validateNodePool(resources cmResources, allocations cmAllocations,
+	nodepool *hwmgmtv1alpha1.NodePool) error {
+	var allocated map[string][]string
+	if cloud := allocations.findCloud(nodepool.Spec.CloudID); cloud != nil {
+		allocated = cloud.Nodegroups
+	}
+
+	for _, nodegroup := range nodepool.Spec.NodeGroup {
+		freenodes := capRackPower(resources, allocations, capPerRack(resources, allocations, nodepool,
+			getFreeNodesInProfile(resources, allocations, nodegroup.HwProfile,
+				h.nodeFilters(resources, allocations, nodepool, nodegroup)...)))
+		needed := nodesNeeded(resources, nodepool, nodegroup, allocated[nodegroup.Name], freenodes)
+		if needed <= 0 {
+			continue
+		}
+
+		if err := checkKnownProfile(resources, nodegroup); err != nil {
+			return err
+		}
+
+		if err := checkCordonedProfile(resources, nodegroup); err != nil {
+			return err
+		}
+
+		if needed > len(freenodes) {
+			if err := checkProfileCapacity(resources, nodepool, nodegroup); err != nil {
+				return err
+			}
+			return h.insufficientResourcesError(resources, allocations, nodepool, nodegroup, needed)
+		}
+	}
+
+	return nil
+}
+
+// AllocationPick is a free node selected for allocation to a nodegroup
+type AllocationPick struct {
+	NodeGroup string `json:"nodegroup"`
+	NodeName  string `json:"nodename"`
+}
+
+// deepCopy returns a copy of the allocations that can be modified independently
+func (a cmAllocations) deepCopy() (result cmAllocations) {
+	for _, cloud := range a.Clouds {
+		nodegroups := make(map[string][]string, len(cloud.Nodegroups))
+		for groupname, nodes := range cloud.Nodegroups {
+			nodegroups[groupname] = slices.Clone(nodes)
+		}
+		result.Clouds = append(result.Clouds, cmAllocatedCloud{CloudID: cloud.CloudID, Nodegroups: nodegroups})
+	}
+
+	if a.Released != nil {
+		result.Released = make(map[string]metav1.Time, len(a.Released))
+		for nodename, releaseTime := range a.Released {
+			result.Released[nodename] = releaseTime
+		}
+	}
+
+	result.Warm = slices.Clone(a.Warm)
+	return
+}
+
+// addCloud gets the allocations of a cloud, first adding an empty entry for it if nothing is allocated to it
+func (a *cmAllocations) addCloud(cloudID string) *cmAllocatedCloud {
+	if cloud := a.findCloud(cloudID); cloud != nil {
+		return cloud
+	}
+	a.Clouds = append(a.Clouds, cmAllocatedCloud{CloudID: cloudID, Nodegroups: make(map[string][]string)})
+	return &a.Clouds[len(a.Clouds)-1]
+}
+
+// findCloud gets the allocations of a cloud, or nil if nothing is allocated to it
+func (a *cmAllocations) findCloud(cloudID string) *cmAllocatedCloud {
+	index := slices.IndexFunc(a.Clouds, func(cloud cmAllocatedCloud) bool { return cloud.CloudID == cloudID })
+	if index == -1 {
+		return nil
+	}
+	return &a.Clouds[index]
+}
+
+// nodegroupCandidates gets the free nodes that could be allocated to a nodegroup of a NodePool from the planned
+// allocations, in the order in which they are drawn, along with the number of nodes the nodegroup still needs
+func (h *HwMgrService) nodegroupCandidates(resources cmResources, planned *cmAllocations,
+	nodepool *hwmgmtv1alpha1.NodePool, nodegroup hwmgmtv1alpha1.NodeGroup) (freenodes []string, remaining int) {
+	cloud := planned.addCloud(nodepool.Spec.CloudID)
+	freenodes = getFreeNodesInProfile(resources, *planned, nodegroup.HwProfile,
+		h.nodeFilters(resources, *planned, nodepool, nodegroup)...)
+	remaining = nodesNeeded(resources, nodepool, nodegroup, cloud.Nodegroups[nodegroup.Name], freenodes)
+
+	// Draw from the warm pool before cold nodes, then from powered-on nodes if preferred, breaking ties by the
+	// configured sort keys, or by adjacency to the nodes already allocated to the NodePool if preferred. Nodes with
+	// the most accelerators are preferred for a nodegroup requesting a number of accelerators.
+	freenodes = h.sortCandidates(resources, freenodes)
+	if h.preferAdjacent {
+		freenodes = adjacentFirst(resources, nodegroup.HwProfile, *cloud, freenodes)
+	}
+	if acceleratorTarget(nodepool, nodegroup) > 0 {
+		freenodes = mostAcceleratorsFirst(resources, freenodes)
+	}
+	if h.preferPoweredOn {
+		freenodes = poweredOnFirst(resources, freenodes)
+	}
+	freenodes = warmFirst(freenodes, planned.Warm)
+	return
+}
+
+// planNodegroup picks the next free node for a nodegroup of a NodePool and adds it to the planned allocations,
+// returning an empty name if the nodegroup is fully allocated. It fails if the hardware profile of the nodegroup is
+// cordoned, or if there are fewer candidate nodes than the nodegroup needs. Nothing is written, so that the allocation
+// and its dry run follow the same plan.
+func (h *HwMgrService) planNodegroup(ctx context.Context, resources cmResources, planned *cmAllocations,
+	nodepool *hwmgmtv1alpha1.NodePool, nodegroup hwmgmtv1alpha1.NodeGroup, tally *candidateTally) (string, error) {
+	freenodes, remaining := h.nodegroupCandidates(resources, planned, nodepool, nodegroup)
+	if remaining <= 0 {
+		// This group is allocated
+		h.trace(ctx, nodepool, "nodegroup is fully allocated", "nodegroup", nodegroup.Name, "size", nodegroup.Size)
+		return "", nil
+	}
+
+	if err := checkCordonedProfile(resources, nodegroup); err != nil {
+		h.trace(ctx, nodepool, "hardware profile of nodegroup is cordoned", "nodegroup", nodegroup.Name)
+		return "", err
+	}
+
+	tally.consider(resources, *planned, nodegroup.HwProfile, h.nodeFilters(resources, *planned, nodepool, nodegroup))
+	h.trace(ctx, nodepool, "candidate nodes for nodegroup",
+		"nodegroup", nodegroup.Name,
+		"hwprofile", nodegroup.HwProfile,
+		"remaining", remaining,
+		"unfiltered", len(getFreeNodesInProfile(resources, *planned, nodegroup.HwProfile)),
+		"candidates", freenodes,
+		"warm", planned.Warm)
+	if remaining > len(freenodes) {
+		err := h.insufficientResourcesError(resources, *planned, nodepool, nodegroup, remaining)
+		h.trace(ctx, nodepool, "insufficient candidate nodes for nodegroup", "nodegroup", nodegroup.Name, "error", err)
+		return "", err
+	}
+
+	nodename, err := h.selectNode(nodegroup.HwProfile, freenodes, resources)
+	if err != nil {
+		return "", err
+	}
+	h.trace(ctx, nodepool, "picked node for nodegroup", "nodegroup", nodegroup.Name, "nodename", nodename)
+	tally.pick()
 
-// Define the HwMgrService structures
-type HwMgrServiceBuilder struct {
-	client.Client
-	logger *slog.Logger
+	cloud := planned.addCloud(nodepool.Spec.CloudID)
+	cloud.Nodegroups[nodegroup.Name] = append(cloud.Nodegroups[nodegroup.Name], nodename)
+	planned.Warm = slices.DeleteFunc(planned.Warm, func(warmnode string) bool { return warmnode == nodename })
+	return nodename, nil
 }
 
-type HwMgrService struct {
-	client.Client
-	logger    *slog.Logger
-	namespace string
-}
+// planAllocation selects the next free node for each nodegroup of a NodePool that is not yet fully allocated, as would
+// be done by AllocateNode, without modifying the allocations
+func (h *HwMgrService) planAllocation(ctx context.Context, resources cmResources, allocations cmAllocations,
+	nodepool *hwmgmtv1alpha1.NodePool, tally *candidateTally) (picks []AllocationPick, err error) {
+	planned := allocations.deepCopy()
+	for _, nodegroup := range nodepool.Spec.NodeGroup {
+		var nodename string
+		if nodename, err = h.planNodegroup(ctx, resources, &planned, nodepool, nodegroup, tally); err != nil {
+			return
+		}
+		if nodename != "" {
+			picks = append(picks, AllocationPick{NodeGroup: nodegroup.Name, NodeName: nodename})
+		}
+	}
 
-// Functions for creating a new HwMgrService
-func NewHwMgrService() *HwMgrServiceBuilder {
-	return &HwMgrServiceBuilder{}
+	return
 }
 
-func (b *HwMgrServiceBuilder) SetClient(
-	value client.Client) *HwMgrServiceBuilder {
-	b.Client = value
-	return b
-}
+// PlanAllocation reports the nodes that the next call to AllocateNode would select for a NodePool CR, without
+// allocating them
+func (h *HwMgrService) PlanAllocation(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) ([]AllocationPick, error) {
+	_, resources, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
 
-func (b *HwMgrServiceBuilder) SetLogger(
-	value *slog.Logger) *HwMgrServiceBuilder {
-	b.logger = value
-	return b
+	return h.planAllocation(ctx, resources, allocations, nodepool, nil)
 }
 
-func (b *HwMgrServiceBuilder) Build(ctx context.Context) (
-	result *HwMgrService, err error) {
-	if b.logger == nil {
-		err = errors.New("logger is mandatory")
-		return
+// DryRunAllocate reports whether a NodePool CR could be fully allocated from the current free resources, on top of any
+// nodes already allocated to it, without modifying the allocations or creating any Node CRs or bmc-secrets. The nodes
+// are planned pass by pass as by AllocateNode, so that nodegroups sharing a hardware profile do not count the same
+// free nodes, with the nodegroups that cannot be filled left out of the later passes. It returns the number of nodes
+// short for each nodegroup that could not be filled.
+func (h *HwMgrService) DryRunAllocate(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (bool, map[string]int, error) {
+	if err := checkNodePoolRequest(nodepool); err != nil {
+		return false, nil, err
 	}
 
-	service := &HwMgrService{
-		Client:    b.Client,
-		logger:    b.logger,
-		namespace: os.Getenv("MY_POD_NAMESPACE"),
+	_, resources, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return false, nil, fmt.Errorf("unable to get current resources: %w", err)
 	}
 
-	result = service
-	return
-}
-
-// getFreeNodesInProfile compares the parsed configmap data to get the list of free nodes for a given hardware profile
-func getFreeNodesInProfile(resources cmResources, allocations cmAllocations, profname string) (freenodes []string) {
-	inuse := make(map[string]bool)
-	for _, cloud := range allocations.Clouds {
-		for groupname := range cloud.Nodegroups {
-			for _, nodename := range cloud.Nodegroups[groupname] {
-				inuse[nodename] = true
+	planned := allocations.deepCopy()
+	unfilled := make(map[string]bool)
+	for progress := true; progress; {
+		progress = false
+		for _, nodegroup := range nodepool.Spec.NodeGroup {
+			if unfilled[nodegroup.Name] {
+				continue
+			}
+			nodename, err := h.planNodegroup(ctx, resources, &planned, nodepool, nodegroup, nil)
+			var insufficient *InsufficientResourcesError
+			var cordoned *ProfileCordonedError
+			switch {
+			case errors.As(err, &insufficient) || errors.As(err, &cordoned):
+				unfilled[nodegroup.Name] = true
+			case err != nil:
+				return false, nil, err
+			case nodename != "":
+				progress = true
 			}
 		}
 	}
 
-	for nodename, node := range resources.Nodes {
-		if node.HwProfile != profname {
+	// The shortfall of each nodegroup is counted once the others have taken the nodes planned for them
+	shortfall := make(map[string]int)
+	for _, nodegroup := range nodepool.Spec.NodeGroup {
+		if !unfilled[nodegroup.Name] {
 			continue
 		}
-
-		if _, used := inuse[nodename]; !used {
-			freenodes = append(freenodes, nodename)
+		freenodes, remaining := h.nodegroupCandidates(resources, &planned, nodepool, nodegroup)
+		if checkKnownProfile(resources, nodegroup) != nil || checkCordonedProfile(resources, nodegroup) != nil {
+			shortfall[nodegroup.Name] = remaining
+		} else {
+			shortfall[nodegroup.Name] = remaining -
+				len(capRackPower(resources, planned, capPerRack(resources, planned, nodepool, freenodes)))
 		}
 	}
 
-	return
+	return len(shortfall) == 0, shortfall, nil
 }
 
-// GetCurrentResources parses the nodelist configmap to get the current available and allocated resource lists
-func (h *HwMgrService) GetCurrentResources(ctx context.Context) (
-	cm *corev1.ConfigMap, resources cmResources, allocations cmAllocations, err error) {
-	cm, err = utils.GetConfigmap(ctx, h.Client, cmName, h.namespace)
-	if err != nil {
-		err = fmt.Errorf("unable to get configmap: %w", err)
-		return
+// trace logs a detailed allocation decision for a NodePool CR, only if its debug annotation is set, so that deep
+// logging can be enabled for one pool without the noise of tracing every pool
+func (h *HwMgrService) trace(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool, msg string, args ...any) {
+	if utils.IsAnnotationTrue(nodepool, utils.DebugAnnotation) {
+		h.logger.InfoContext(ctx, "allocation trace: "+msg, append([]any{"nodepool", nodepool.Name}, args...)...)
 	}
+}
 
-	resources, err = utils.ExtractDataFromConfigMap[cmResources](cm, resourcesKey)
-	if err != nil {
-		err = fmt.Errorf("unable to parse resources from configmap: %w", err)
-		return
+// event emits an event on the specified object, if an event recorder is configured
+func (h *HwMgrService) event(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if h.recorder != nil {
+		h.recorder.Eventf(object, eventtype, reason, messageFmt, args...)
 	}
+}
+
+// AllocateNode processes a NodePool CR, allocating a free node for each specified nodegroup as needed
+func (h *HwMgrService) AllocateNode(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (err error) {
+	cloudID := nodepool.Spec.CloudID
 
-	allocations, err = utils.ExtractDataFromConfigMap[cmAllocations](cm, allocationsKey)
+	tentativeTTL, err := utils.GetDurationAnnotation(nodepool, utils.TentativeAllocationTTLAnnotation)
 	if err != nil {
-		// Allocated node field may not be present
-		h.logger.InfoContext(ctx, "unable to parse allocations from configmap")
-		err = nil
+		return err
 	}
 
-	return
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	// The allocations are re-read and re-planned on each attempt, so that a configmap modified since it was read,
+	// such as by an administrator, does not fail the allocation
+	var planned bool
+	var tally candidateTally
+	err = h.retryOnConflict(ctx, func() (err error) {
+		tally = candidateTally{}
+		planned, err = h.allocateNodeOnce(ctx, nodepool, tentativeTTL, &tally)
+		return err
+	}, "cloudID", cloudID)
+	tally.record()
+	countInsufficientResources(operationAllocation, err)
+	if err != nil && planned {
+		h.event(nodepool, corev1.EventTypeWarning, EventReasonAllocationFailed, "Node allocation failed: %s", err.Error())
+	}
+	return err
 }
 
-// ProcessNewNodePool processes a new NodePool CR, verifying that there are enough free resources to satisfy the request
-func (h *HwMgrService) ProcessNewNodePool(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+// allocateNodeOnce makes a single attempt to allocate the nodes needed by a NodePool CR from the current allocations,
+// reporting whether an allocation was planned and tallying the candidate nodes considered for it. It must be called
+// with the allocation lock held.
+func (h *HwMgrService) allocateNodeOnce(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool,
+	tentativeTTL time.Duration, tally *candidateTally) (bool, error) {
 	cloudID := nodepool.Spec.CloudID
 
-	h.logger.InfoContext(ctx, "Processing ProcessNewNodePool request:",
-		"cloudID", cloudID,
-	)
+	cm, resources, allocations, err := h.GetCurrentResources(ctx)
+	if err != nil {
+		return false, fmt.Errorf("unable to get current resources: %w", err)
+	}
 
-	_, resources, allocations, err := h.GetCurrentResources(ctx)
+	picks, err := h.planAllocation(ctx, resources, allocations, nodepool, tally)
 	if err != nil {
-		return fmt.Errorf("unable to get current resources: %w", err)
+		return false, err
+	}
+	if len(picks) == 0 {
+		h.logger.InfoContext(ctx, "nodepool is fully allocated", "cloudID", cloudID)
+		return false, nil
 	}
 
-	for _, nodegroup := range nodepool.Spec.NodeGroup {
-		freenodes := getFreeNodesInProfile(resources, allocations, nodegroup.HwProfile)
-		if nodegroup.Size > len(freenodes) {
-			return fmt.Errorf("not enough free resources in group %s: freenodes=%d", nodegroup.HwProfile, len(freenodes))
-		}
+	// The NodePool may have been deleted since it was read, in which case its finalizer may already have released its
+	// nodes, so nothing is allocated to it. A deletion after this check is caught by the release, which re-reads the
+	// allocations once the allocation lock is free.
+	deleted, err := h.nodePoolDeleted(ctx, nodepool)
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		h.logger.InfoContext(ctx, "nodepool is being deleted, skipping allocation", "cloudID", cloudID)
+		return false, nil
 	}
 
-	return nil
-}
+	// Report the plan before committing it, so it is visible even if the commit fails
+	planned := make([]string, 0, len(picks))
+	for _, pick := range picks {
+		planned = append(planned, pick.NodeGroup+"="+pick.NodeName)
+	}
+	h.event(nodepool, corev1.EventTypeNormal, EventReasonAllocationPlanned, "Planned node allocation: %s", strings.Join(planned, ", "))
+
+	cloud := allocations.addCloud(cloudID)
+
+	// The bmc-secrets and Node CRs of the picked nodes are created before the allocations of all nodegroups are
+	// committed in a single write, so that the configmap never claims a node whose Node CR failed to be created. A warm
+	// node already has its bmc-secret and Node CR, which is claimed once committed.
+	var prepared []AllocationPick
+	warm := make(map[string]bool)
+	selections := make(map[string]NodeSelection)
+	var prepareErr error
+	for _, pick := range picks {
+		nodegroup := nodepool.Spec.NodeGroup[slices.IndexFunc(nodepool.Spec.NodeGroup,
+			func(group hwmgmtv1alpha1.NodeGroup) bool { return group.Name == pick.NodeGroup })]
+
+		nodeinfo, exists := resources.Nodes[pick.NodeName]
+		if !exists {
+			prepareErr = fmt.Errorf("unable to find nodeinfo for %s", pick.NodeName)
+			break
+		}
 
-// AllocateNode processes a NodePool CR, allocating a free node for each specified nodegroup as needed
-func (h *HwMgrService) AllocateNode(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
-	cloudID := nodepool.Spec.CloudID
+		// A warm node keeps its provisioning status, so it is not subject to the allocation delay again
+		warm[pick.NodeName] = slices.Contains(allocations.Warm, pick.NodeName)
+		selections[pick.NodeName] = h.nodeSelection(resources, allocations, nodepool, nodegroup, pick.NodeName,
+			warm[pick.NodeName])
+		if !warm[pick.NodeName] {
+			prepareErr = h.createAllocatedNode(ctx, cloudID, nodegroup, pick.NodeName, nodeinfo, selections[pick.NodeName],
+				tentativeTTL > 0)
+			if prepareErr != nil {
+				break
+			}
+		}
 
-	// Inject a delay before allocating node
-	time.Sleep(10 * time.Second)
+		prepared = append(prepared, pick)
+		cloud.Nodegroups[pick.NodeGroup] = append(cloud.Nodegroups[pick.NodeGroup], pick.NodeName)
+		h.recordHistory(&allocations, historyActionAllocated, cloudID, pick.NodeGroup, pick.NodeName)
+		allocations.Warm = slices.DeleteFunc(allocations.Warm, func(warmnode string) bool { return warmnode == pick.NodeName })
+		delete(allocations.Released, pick.NodeName)
+		utils.IncrementCounterAnnotation(cm, utils.AllocatedCountAnnotationPrefix+nodeinfo.HwProfile, 1)
+	}
 
-	cm, resources, allocations, err := h.GetCurrentResources(ctx)
-	if err != nil {
-		return fmt.Errorf("unable to get current resources: %w", err)
+	if len(prepared) == 0 {
+		return true, prepareErr
 	}
 
-	var cloud *cmAllocatedCloud
-	for i, iter := range allocations.Clouds {
-		if iter.CloudID == cloudID {
-			cloud = &allocations.Clouds[i]
-			break
+	// Update the configmap
+	if err := h.updateAllocations(ctx, cm, allocations); err != nil {
+		for _, pick := range prepared {
+			if !warm[pick.NodeName] {
+				h.removeAllocatedNode(ctx, pick.NodeName)
+			}
 		}
+		return true, err
 	}
-	if cloud == nil {
-		// The cloud wasn't found in the list, so create a new entry
-		allocations.Clouds = append(allocations.Clouds, cmAllocatedCloud{CloudID: cloudID, Nodegroups: make(map[string][]string)})
-		cloud = &allocations.Clouds[len(allocations.Clouds)-1]
+	nodesAllocated.Add(float64(len(prepared)))
+
+	// A warm node is only claimed once committed, so its allocation is rolled back if it cannot be claimed
+	var claimErr error
+	for _, pick := range prepared {
+		if warm[pick.NodeName] {
+			if err := h.claimAllocatedWarmNode(ctx, cloudID, pick, selections[pick.NodeName], tentativeTTL > 0); err != nil {
+				h.rollbackAllocatedNode(ctx, cloudID, pick.NodeGroup, pick.NodeName)
+				claimErr = errors.Join(claimErr, err)
+				continue
+			}
+		}
+
+		h.event(nodepool, corev1.EventTypeNormal, EventReasonNodeAllocated, "Allocated node %s to nodegroup %s", pick.NodeName, pick.NodeGroup)
 	}
 
-	// Check available resources
-	for _, nodegroup := range nodepool.Spec.NodeGroup {
-		used := cloud.Nodegroups[nodegroup.Name]
-		remaining := nodegroup.Size - len(used)
-		if remaining <= 0 {
-			// This group is allocated
-			h.logger.InfoContext(ctx, "nodegroup is fully allocated", "nodegroup", nodegroup.Name)
-			continue
+	return true, errors.Join(prepareErr, claimErr)
+}
+
+// nodePoolDeleted checks whether a NodePool has been deleted, or marked for deletion, since it was read. A NodePool that
+// was never stored, such as one built only to plan an allocation, has no UID, so it is not taken as deleted when it is
+// not found.
+func (h *HwMgrService) nodePoolDeleted(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (bool, error) {
+	current := &hwmgmtv1alpha1.NodePool{}
+	if err := h.Client.Get(ctx, client.ObjectKeyFromObject(nodepool), current); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nodepool.UID != "", nil
 		}
+		return false, fmt.Errorf("failed to get NodePool %s: %w", nodepool.Name, err)
+	}
 
-		freenodes := getFreeNodesInProfile(resources, allocations, nodegroup.HwProfile)
-		if remaining > len(freenodes) {
-			return fmt.Errorf("not enough free resources remaining in group %s", nodegroup.HwProfile)
+	return current.GetDeletionTimestamp() != nil, nil
+}
+
+// claimAllocatedWarmNode claims a warm node whose allocation to a nodegroup was committed, marking it as tentative if
+// requested
+func (h *HwMgrService) claimAllocatedWarmNode(ctx context.Context, cloudID string, pick AllocationPick,
+	selection NodeSelection, tentative bool) error {
+	if err := h.claimWarmNode(ctx, cloudID, pick.NodeName, pick.NodeGroup, selection); err != nil {
+		return fmt.Errorf("failed to claim warm node (%s): %w", pick.NodeName, err)
+	}
+
+	if tentative {
+		if err := h.setNodeTentative(ctx, pick.NodeName, true); err != nil {
+			return fmt.Errorf("failed to mark allocation of node %s as tentative: %w", pick.NodeName, err)
 		}
+	}
 
-		// Grab the first node
-		nodename := freenodes[0]
+	return nil
+}
 
-		nodeinfo, exists := resources.Nodes[nodename]
-		if !exists {
-			return fmt.Errorf("unable to find nodeinfo for %s", nodename)
+// rollbackAllocatedNode undoes the committed allocation of a node whose setup failed, removing it from its nodegroup in
+// the configmap and deleting its Node CR and bmc-secret, so that the configmap never claims a node that is not fully
+// set up. The node is released without a cooldown. Failures are logged rather than returned, as the allocation has
+// already failed. It must be called with the allocation lock held.
+func (h *HwMgrService) rollbackAllocatedNode(ctx context.Context, cloudID, groupname, nodename string) {
+	h.logger.WarnContext(ctx, "Rolling back allocation of node", "cloudID", cloudID, "nodegroup name", groupname,
+		"nodename", nodename)
+
+	err := h.retryOnConflict(ctx, func() error {
+		cm, resources, allocations, err := h.GetCurrentResources(ctx)
+		if err != nil {
+			return err
 		}
 
-		if err := h.CreateBMCSecret(ctx, nodename, nodeinfo.BMC.UsernameBase64, nodeinfo.BMC.PasswordBase64); err != nil {
-			return fmt.Errorf("failed to create bmc-secret when allocating node %s: %w", nodename, err)
+		index := slices.IndexFunc(allocations.Clouds, func(cloud cmAllocatedCloud) bool { return cloud.CloudID == cloudID })
+		if index == -1 || !slices.Contains(allocations.Clouds[index].Nodegroups[groupname], nodename) {
+			return nil
 		}
 
-		cloud.Nodegroups[nodegroup.Name] = append(cloud.Nodegroups[nodegroup.Name], nodename)
+		cloud := &allocations.Clouds[index]
+		cloud.Nodegroups[groupname] = slices.DeleteFunc(cloud.Nodegroups[groupname],
+			func(allocated string) bool { return allocated == nodename })
+		if len(cloud.Nodegroups[groupname]) == 0 {
+			delete(cloud.Nodegroups, groupname)
+		}
+		if len(cloud.Nodegroups) == 0 {
+			allocations.Clouds = slices.Delete(allocations.Clouds, index, index+1)
+		}
 
-		// Update the configmap
-		yamlString, err := yaml.Marshal(&allocations)
-		if err != nil {
-			return fmt.Errorf("unable to marshal allocated data: %w", err)
+		if nodeinfo, exists := resources.Nodes[nodename]; exists {
+			utils.IncrementCounterAnnotation(cm, utils.ReleasedCountAnnotationPrefix+nodeinfo.HwProfile, 1)
 		}
-		cm.Data[allocationsKey] = string(yamlString)
-		if err := h.Client.Update(ctx, cm); err != nil {
-			return fmt.Errorf("failed to update configmap: %w", err)
+		h.recordHistory(&allocations, historyActionReleased, cloudID, groupname, nodename)
+
+		if err := h.updateAllocations(ctx, cm, allocations); err != nil {
+			return err
 		}
+		nodesReleased.Inc()
+		return nil
+	}, "cloudID", cloudID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "failed to roll back allocation", "nodename", nodename, "err", err)
+		return
+	}
+
+	h.removeAllocatedNode(ctx, nodename)
+}
 
-		if err := h.CreateNode(ctx, cloudID, nodename, nodegroup.Name, nodegroup.HwProfile); err != nil {
-			return fmt.Errorf("failed to create allocated node (%s): %w", nodename, err)
+// createAllocatedNode creates the bmc-secret and Node CR for a node being allocated to a nodegroup, marking it as
+// allocated pending provisioning. On failure, whatever was created is removed again.
+func (h *HwMgrService) createAllocatedNode(ctx context.Context, cloudID string, nodegroup hwmgmtv1alpha1.NodeGroup,
+	nodename string, nodeinfo cmNodeInfo, selection NodeSelection, tentative bool) (err error) {
+	defer func() {
+		if err != nil {
+			h.removeAllocatedNode(ctx, nodename)
 		}
+	}()
+
+	// The cleanup above keeps the parent context, so that it is not cut short once the allocation has timed out
+	allocCtx := ctx
+	if h.allocationTimeout > 0 {
+		var cancel context.CancelFunc
+		allocCtx, cancel = context.WithTimeout(ctx, h.allocationTimeout)
+		defer cancel()
+	}
+
+	if err = h.createNodeBMCSecret(allocCtx, nodename, nodeinfo.BMC); err != nil {
+		return fmt.Errorf("failed to create bmc-secret when allocating node %s: %w", nodename, err)
+	}
 
-		if err := h.UpdateNodeStatus(ctx, nodename, nodeinfo); err != nil {
-			return fmt.Errorf("failed to update node status (%s): %w", nodename, err)
+	if err = h.CreateNode(allocCtx, cloudID, nodename, nodegroup.Name, nodegroup.HwProfile, nodeinfo.Labels,
+		selection); err != nil {
+		return fmt.Errorf("failed to create allocated node (%s): %w", nodename, err)
+	}
+
+	if tentative {
+		if err = h.setNodeTentative(allocCtx, nodename, true); err != nil {
+			return fmt.Errorf("failed to mark allocation of node %s as tentative: %w", nodename, err)
 		}
 	}
 
+	if err = h.SetNodeAllocated(allocCtx, nodename); err != nil {
+		return fmt.Errorf("failed to update node status (%s): %w", nodename, err)
+	}
+
 	return nil
 }
 
-func bmcSecretName(nodename string) string {
-	return fmt.Sprintf("%s-bmc-secret", nodename)
+// removeAllocatedNode deletes the Node CR and bmc-secret of a node whose allocation is being undone. Failures are
+// logged rather than returned, as the allocation has already failed.
+func (h *HwMgrService) removeAllocatedNode(ctx context.Context, nodename string) {
+	if err := h.DeleteNode(ctx, nodename); err != nil {
+		h.logger.ErrorContext(ctx, "failed to remove Node of failed allocation", "nodename", nodename, "err", err)
+	}
+	if err := h.DeleteBMCSecret(ctx, nodename); err != nil {
+		h.logger.ErrorContext(ctx, "failed to remove bmc-secret of failed allocation", "nodename", nodename, "err", err)
+	}
+}
+
+func (h *HwMgrService) bmcSecretName(nodename string) string {
+	return h.nodeName(nodename) + bmcSecretSuffix
+}
+
+// createNodeBMCSecret creates the bmc-secret for a node from its BMC info in the nodelist configmap, using the
+// plaintext credentials if set, and otherwise the base64 encoded ones. A node without BMC info has no bmc-secret, and
+// a node referencing an existing Secret has that Secret verified instead.
+func (h *HwMgrService) createNodeBMCSecret(ctx context.Context, nodename string, bmc *cmBmcInfo) error {
+	if bmc == nil {
+		h.logger.WarnContext(ctx, "No bmc info for node, skipping bmc-secret", "nodename", nodename)
+		return nil
+	}
+
+	if bmc.CredentialsSecretRef != "" {
+		return h.verifyReferencedBMCSecret(ctx, nodename, bmc.CredentialsSecretRef)
+	}
+
+	if bmc.Username != "" || bmc.Password != "" {
+		h.logger.InfoContext(ctx, "Creating bmc-secret:", "nodename", nodename, "credentials", "plaintext")
+		return h.writeBMCSecret(ctx, nodename, []byte(bmc.Username), []byte(bmc.Password))
+	}
+
+	return h.CreateBMCSecret(ctx, nodename, bmc.UsernameBase64, bmc.PasswordBase64)
 }
 
 // CreateBMCSecret creates the bmc-secret for a node
 func (h *HwMgrService) CreateBMCSecret(ctx context.Context, nodename, usernameBase64, passwordBase64 string) error {
 	h.logger.InfoContext(ctx, "Creating bmc-secret:", "nodename", nodename)
 
-	secretName := bmcSecretName(nodename)
-
-	username, err := base64.StdEncoding.DecodeString(usernameBase64)
+	// The credentials are decoded as for the inventory, without their values in any error
+	username, password, err := bmcCredentials(nodename,
+		&cmBmcInfo{UsernameBase64: usernameBase64, PasswordBase64: passwordBase64})
 	if err != nil {
-		return fmt.Errorf("failed to decode usernameBase64 string (%s) for node %s: %w", usernameBase64, nodename, err)
+		return err
 	}
 
-	password, err := base64.StdEncoding.DecodeString(passwordBase64)
+	return h.writeBMCSecret(ctx, nodename, username, password)
+}
+
+// writeBMCSecret creates or updates the bmc-secret for a node with the given credentials
+func (h *HwMgrService) writeBMCSecret(ctx context.Context, nodename string, username, password []byte) error {
+	secretName, err := h.resolveBMCSecretName(ctx, nodename)
 	if err != nil {
-		return fmt.Errorf("failed to decode usernameBase64 string (%s) for node %s: %w", passwordBase64, nodename, err)
+		return err
 	}
 
+	return h.putBMCSecret(ctx, nodename, secretName, username, password)
+}
+
+// putBMCSecret creates or updates the named bmc-secret for a node with the given credentials, recording the node as its
+// owner
+func (h *HwMgrService) putBMCSecret(ctx context.Context, nodename, secretName string, username, password []byte) error {
 	bmcSecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      secretName,
 			Namespace: h.namespace,
+			Annotations: map[string]string{
+				utils.InventoryKeyLabel: nodename,
+			},
 		},
 		Data: map[string][]byte{
 			"username": username,
@@ -278,7 +1965,7 @@ func (h *HwMgrService) CreateBMCSecret(ctx context.Context, nodename, usernameBa
 		},
 	}
 
-	if err = utils.CreateK8sCR(ctx, h.Client, bmcSecret, nil, utils.UPDATE); err != nil {
+	if err := utils.CreateK8sCR(ctx, h.Client, bmcSecret, nil, utils.UPDATE); err != nil {
 		return fmt.Errorf("failed to create bmc-secret for node %s: %w", nodename, err)
 	}
 
@@ -289,7 +1976,10 @@ func (h *HwMgrService) CreateBMCSecret(ctx context.Context, nodename, usernameBa
 func (h *HwMgrService) DeleteBMCSecret(ctx context.Context, nodename string) error {
 	h.logger.InfoContext(ctx, "Deleting bmc-secret:", "nodename", nodename)
 
-	secretName := bmcSecretName(nodename)
+	secretName, err := h.resolveBMCSecretName(ctx, nodename)
+	if err != nil {
+		return err
+	}
 
 	bmcSecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
@@ -305,8 +1995,10 @@ func (h *HwMgrService) DeleteBMCSecret(ctx context.Context, nodename string) err
 	return nil
 }
 
-// CreateNode creates a Node CR with specified attributes
-func (h *HwMgrService) CreateNode(ctx context.Context, cloudID, nodename, groupname, hwprofile string) error {
+// CreateNode creates a Node CR with specified attributes and the labels given to the node in the inventory, annotated
+// with the reasons for which the node was selected
+func (h *HwMgrService) CreateNode(ctx context.Context, cloudID, nodename, groupname, hwprofile string,
+	labels map[string]string, selection NodeSelection) error {
 
 	h.logger.InfoContext(ctx, "Creating node:",
 		"cloudID", cloudID,
@@ -314,10 +2006,27 @@ func (h *HwMgrService) CreateNode(ctx context.Context, cloudID, nodename, groupn
 		"nodename", nodename,
 	)
 
+	node := h.newNode(cloudID, nodename, groupname, hwprofile)
+	applyInventoryLabels(node, labels)
+	annotateSelection(node, selection)
+	if err := h.Client.Create(ctx, node); err != nil {
+		return fmt.Errorf("failed to create Node: %w", err)
+	}
+
+	return nil
+}
+
+// newNode builds the Node CR for a node, timestamped with its allocation time
+func (h *HwMgrService) newNode(cloudID, nodename, groupname, hwprofile string) *hwmgmtv1alpha1.Node {
 	node := &hwmgmtv1alpha1.Node{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      nodename,
-			Namespace: h.namespace,
+			Name:       h.nodeName(nodename),
+			Namespace:  h.namespace,
+			Finalizers: []string{NodeFinalizer},
+			Labels:     h.nodeLabels(nodename),
+			Annotations: map[string]string{
+				utils.AllocatedAtAnnotation: h.clock.Now().UTC().Format(time.RFC3339Nano),
+			},
 		},
 		Spec: hwmgmtv1alpha1.NodeSpec{
 			NodePool:  cloudID,
@@ -326,13 +2035,49 @@ func (h *HwMgrService) CreateNode(ctx context.Context, cloudID, nodename, groupn
 		},
 	}
 
-	if err := h.Client.Create(ctx, node); err != nil {
-		return fmt.Errorf("failed to create Node: %w", err)
+	if node.Name != nodename {
+		node.Annotations[utils.InventoryKeyLabel] = nodename
+	}
+
+	return node
+}
+
+// SetNodeAllocated marks a newly created Node CR as allocated, pending provisioning
+func (h *HwMgrService) SetNodeAllocated(ctx context.Context, nodename string) error {
+	node := &hwmgmtv1alpha1.Node{}
+
+	if err := h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
+		return fmt.Errorf("failed to get Node: %w", err)
+	}
+
+	utils.SetStatusCondition(&node.Status.Conditions,
+		hwmgmtv1alpha1.Provisioned,
+		utils.Allocated,
+		metav1.ConditionFalse,
+		"Allocated")
+
+	if err := utils.UpdateK8sCRStatus(ctx, h.Client, node); err != nil {
+		return fmt.Errorf("failed to update status for node %s: %w", nodename, err)
 	}
 
 	return nil
 }
 
+// provisionNode completes the provisioning of a node, waiting for a provisioning slot if the number of concurrent
+// provisions is limited
+func (h *HwMgrService) provisionNode(ctx context.Context, nodename string, info cmNodeInfo) error {
+	if h.provisionSlots != nil {
+		select {
+		case h.provisionSlots <- struct{}{}:
+			defer func() { <-h.provisionSlots }()
+		case <-ctx.Done():
+			return fmt.Errorf("failed waiting to provision node %s: %w", nodename, ctx.Err())
+		}
+	}
+
+	return h.UpdateNodeStatus(ctx, nodename, info)
+}
+
 // UpdateNodeStatus updates a Node CR status field with additional node information from the nodelist configmap
 func (h *HwMgrService) UpdateNodeStatus(ctx context.Context, nodename string, info cmNodeInfo) error {
 
@@ -342,14 +2087,54 @@ func (h *HwMgrService) UpdateNodeStatus(ctx context.Context, nodename string, in
 
 	node := &hwmgmtv1alpha1.Node{}
 
-	if err := h.Client.Get(ctx, types.NamespacedName{Name: nodename, Namespace: h.namespace}, node); err != nil {
+	if err := h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
 		return fmt.Errorf("failed to create Node: %w", err)
 	}
 
 	h.logger.InfoContext(ctx, "Adding info to node", "nodename", nodename, "info", info)
-	node.Status.BMC = &hwmgmtv1alpha1.BMC{
-		Address:         info.BMC.Address,
-		CredentialsName: bmcSecretName(nodename),
+
+	// The status only holds the raw BMC address, so its protocol and components are published as annotations
+	if info.BMC == nil {
+		h.logger.WarnContext(ctx, "No bmc info for node, leaving its BMC status unset", "nodename", nodename)
+	} else {
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string)
+		}
+		node.Annotations[utils.BMCProtocolAnnotation] = info.BMC.protocol()
+		if bmc, err := ParseBMCAddress(info.BMC.Address); err != nil {
+			h.logger.WarnContext(ctx, "Unable to parse BMC address", "nodename", nodename, "error", err)
+		} else {
+			node.Annotations[utils.BMCSchemeAnnotation] = bmc.Scheme
+			node.Annotations[utils.BMCHostAnnotation] = bmc.Host
+			node.Annotations[utils.BMCPortAnnotation] = strconv.Itoa(bmc.Port)
+		}
+	}
+
+	bootMAC := bootMACAddress(info.Interfaces)
+	if bootMAC != "" {
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string)
+		}
+		node.Annotations[utils.BootMACAddressAnnotation] = bootMAC
+	}
+
+	// The labels from the inventory are applied again, so that changes to them are picked up on each provisioning
+	applyInventoryLabels(node, info.Labels)
+	if info.BMC != nil || len(info.Labels) != 0 || bootMAC != "" {
+		if err := h.Client.Update(ctx, node); err != nil {
+			return fmt.Errorf("failed to update labels and annotations for node %s: %w", nodename, err)
+		}
+	}
+
+	if info.BMC != nil {
+		secretName, err := h.credentialsName(ctx, nodename, info.BMC)
+		if err != nil {
+			return err
+		}
+		node.Status.BMC = &hwmgmtv1alpha1.BMC{
+			Address:         info.BMC.Address,
+			CredentialsName: secretName,
+		}
 	}
 	node.Status.Hostname = info.Hostname
 	node.Status.Interfaces = info.Interfaces
@@ -367,18 +2152,32 @@ func (h *HwMgrService) UpdateNodeStatus(ctx context.Context, nodename string, in
 	return nil
 }
 
-// DeleteNode deletes a Node CR
+// DeleteNode deletes a Node CR, along with its bmc-secret
 func (h *HwMgrService) DeleteNode(ctx context.Context, nodename string) error {
 
 	h.logger.InfoContext(ctx, "Deleting node:",
 		"nodename", nodename,
 	)
 
-	node := &hwmgmtv1alpha1.Node{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      nodename,
-			Namespace: h.namespace,
-		},
+	node := &hwmgmtv1alpha1.Node{}
+	if err := h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get Node: %w", err)
+	}
+
+	// The bmc-secret is deleted while the finalizer is still held, so that it cannot be orphaned by a failure between the
+	// two deletions, as the deletion of the Node CR is then retried
+	if err := h.DeleteBMCSecret(ctx, nodename); err != nil {
+		return err
+	}
+
+	// The finalizer otherwise only guards against deletion by others, so it is removed before the Node CR is deleted
+	if controllerutil.RemoveFinalizer(node, NodeFinalizer) {
+		if err := h.Client.Update(ctx, node); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to remove finalizer from Node: %w", err)
+		}
 	}
 
 	if err := h.Client.Delete(ctx, node); client.IgnoreNotFound(err) != nil {
@@ -388,22 +2187,92 @@ func (h *HwMgrService) DeleteNode(ctx context.Context, nodename string) error {
 	return nil
 }
 
+// HandleNodeDeletion releases a node whose Node CR has been deleted by someone other than the plugin, removing it
+// from the allocations so that its NodePool can be reallocated, and then deletes its bmc-secret and removes the
+// finalizer to let the deletion complete. The bmc-secret is deleted even if the node is no longer allocated, such as
+// when a release was interrupted after updating the allocations.
+func (h *HwMgrService) HandleNodeDeletion(ctx context.Context, node *hwmgmtv1alpha1.Node) error {
+	if !controllerutil.ContainsFinalizer(node, NodeFinalizer) {
+		return nil
+	}
+
+	key := inventoryKey(node)
+
+	// The node is only released once the plugin finalizer is the last one left, as the Node CR could otherwise outlive
+	// the release, leaving the node to be reallocated while its old Node CR remains
+	if len(node.Finalizers) > 1 {
+		return &ReleasePendingError{Reason: "waiting for the finalizers of others", Nodes: []string{key}}
+	}
+
+	h.logger.InfoContext(ctx, "Node deleted externally, releasing it", "nodename", key, "cloudID", node.Spec.NodePool)
+
+	if utils.IsAnnotationTrue(node, utils.WarmAnnotation) {
+		if err := h.removeWarmNode(ctx, key); err != nil {
+			return err
+		}
+	} else if err := h.releaseNodes(ctx, node.Spec.NodePool, []string{key}); err != nil {
+		return fmt.Errorf("failed to release deleted node %s: %w", key, err)
+	}
+
+	// releaseNodes removes the finalizer, but a warm node, or one that was no longer allocated, still has it
+	if err := h.DeleteNode(ctx, key); err != nil {
+		return fmt.Errorf("failed to complete deletion of node %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// GetNodePoolNodes returns the names of the Node CRs that still exist for a NodePool CR, including any being deleted
+func (h *HwMgrService) GetNodePoolNodes(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (nodenames []string, err error) {
+	nodes := &hwmgmtv1alpha1.NodeList{}
+	if err = h.Client.List(ctx, nodes, client.InNamespace(h.namespace)); err != nil {
+		err = fmt.Errorf("failed to list nodes: %w", err)
+		return
+	}
+
+	for _, node := range nodes.Items {
+		if node.Spec.NodePool == nodepool.Spec.CloudID {
+			nodenames = append(nodenames, node.Name)
+		}
+	}
+
+	slices.Sort(nodenames)
+	return
+}
+
+// UnderAllocatedNodeGroups gets the nodegroups of a NodePool CR that request more than is currently allocated to them,
+// such as after their size is increased
+func (h *HwMgrService) UnderAllocatedNodeGroups(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) ([]string, error) {
+	_, resources, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	var allocated map[string][]string
+	if cloud := allocations.findCloud(nodepool.Spec.CloudID); cloud != nil {
+		allocated = cloud.Nodegroups
+	}
+
+	var groupnames []string
+	for _, nodegroup := range nodepool.Spec.NodeGroup {
+		if nodesNeeded(resources, nodepool, nodegroup, allocated[nodegroup.Name], nil) > 0 {
+			groupnames = append(groupnames, nodegroup.Name)
+		}
+	}
+
+	return groupnames, nil
+}
+
 // IsNodeFullyAllocated checks to see if a NodePool CR has been fully allocated
 func (h *HwMgrService) IsNodeFullyAllocated(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (bool, error) {
 	cloudID := nodepool.Spec.CloudID
 
-	_, resources, allocations, err := h.GetCurrentResources(ctx)
+	_, resources, allocations, err := h.getCachedResources(ctx)
 	if err != nil {
 		return false, fmt.Errorf("unable to get current resources: %w", err)
 	}
 
-	var cloud *cmAllocatedCloud
-	for i, iter := range allocations.Clouds {
-		if iter.CloudID == cloudID {
-			cloud = &allocations.Clouds[i]
-			break
-		}
-	}
+	cloud := allocations.findCloud(cloudID)
 	if cloud == nil {
 		// Cloud has not been allocated yet
 		return false, nil
@@ -411,17 +2280,18 @@ func (h *HwMgrService) IsNodeFullyAllocated(ctx context.Context, nodepool *hwmgm
 
 	// Check allocated resources
 	for _, nodegroup := range nodepool.Spec.NodeGroup {
-		used := cloud.Nodegroups[nodegroup.Name]
-		remaining := nodegroup.Size - len(used)
+		freenodes := capRackPower(resources, allocations, capPerRack(resources, allocations, nodepool,
+			getFreeNodesInProfile(resources, allocations, nodegroup.HwProfile,
+				h.nodeFilters(resources, allocations, nodepool, nodegroup)...)))
+		remaining := nodesNeeded(resources, nodepool, nodegroup, cloud.Nodegroups[nodegroup.Name], freenodes)
 		if remaining <= 0 {
 			// This group is allocated
 			h.logger.InfoContext(ctx, "nodegroup is fully allocated", "nodegroup", nodegroup.Name)
 			continue
 		}
 
-		freenodes := getFreeNodesInProfile(resources, allocations, nodegroup.HwProfile)
 		if remaining > len(freenodes) {
-			return false, fmt.Errorf("not enough free resources remaining in group %s", nodegroup.HwProfile)
+			return false, h.insufficientResourcesError(resources, allocations, nodepool, nodegroup, remaining)
 		}
 
 		// Cloud is not fully allocated, and there are resources available
@@ -431,23 +2301,32 @@ func (h *HwMgrService) IsNodeFullyAllocated(ctx context.Context, nodepool *hwmgm
 	return true, nil
 }
 
-// GetAllocatedNodes gets a list of nodes allocated for the specified NodePool CR
+// GetAllocatedNodes gets a list of the Node CR names allocated for the specified NodePool CR
 func (h *HwMgrService) GetAllocatedNodes(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (allocatedNodes []string, err error) {
+	keys, err := h.getAllocatedNodeKeys(ctx, nodepool)
+	if err != nil {
+		return
+	}
+
+	for _, key := range keys {
+		allocatedNodes = append(allocatedNodes, h.nodeName(key))
+	}
+
+	slices.Sort(allocatedNodes)
+	return
+}
+
+// getAllocatedNodeKeys gets a list of the inventory keys of the nodes allocated for the specified NodePool CR
+func (h *HwMgrService) getAllocatedNodeKeys(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (allocatedNodes []string, err error) {
 	cloudID := nodepool.Spec.CloudID
 
-	_, _, allocations, err := h.GetCurrentResources(ctx)
+	_, _, allocations, err := h.getCachedResources(ctx)
 	if err != nil {
 		err = fmt.Errorf("unable to get current resources: %w", err)
 		return
 	}
 
-	var cloud *cmAllocatedCloud
-	for i, iter := range allocations.Clouds {
-		if iter.CloudID == cloudID {
-			cloud = &allocations.Clouds[i]
-			break
-		}
-	}
+	cloud := allocations.findCloud(cloudID)
 	if cloud == nil {
 		// Cloud has not been allocated yet
 		return
@@ -462,10 +2341,135 @@ func (h *HwMgrService) GetAllocatedNodes(ctx context.Context, nodepool *hwmgmtv1
 	return
 }
 
+// IsNodePoolProvisioned checks to see if all nodes allocated to a NodePool CR have been provisioned
+func (h *HwMgrService) IsNodePoolProvisioned(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (bool, error) {
+	allocatedNodes, err := h.getAllocatedNodeKeys(ctx, nodepool)
+	if err != nil {
+		return false, fmt.Errorf("failed to get allocated nodes for %s: %w", nodepool.Name, err)
+	}
+
+	for _, nodename := range allocatedNodes {
+		node := &hwmgmtv1alpha1.Node{}
+		if err := h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
+			return false, fmt.Errorf("failed to get Node %s: %w", nodename, err)
+		}
+
+		if !meta.IsStatusConditionTrue(node.Status.Conditions, string(hwmgmtv1alpha1.Provisioned)) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// provisionDelay returns the time taken to provision a node after it is allocated, which is the estimate from its
+// inventory data if given, or the allocation delay otherwise
+func (h *HwMgrService) provisionDelay(node cmNodeInfo) time.Duration {
+	if node.ProvisionTime != nil {
+		return node.ProvisionTime.Duration
+	}
+	return h.allocationDelay
+}
+
+// ProvisionAllocatedNodes completes the provisioning of the nodes allocated to a NodePool CR once the allocation delay
+// has elapsed, returning the time remaining until the next pending node is due, or zero if none are pending
+func (h *HwMgrService) ProvisionAllocatedNodes(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (requeueAfter time.Duration, err error) {
+	_, resources, _, err := h.getCachedResources(ctx)
+	if err != nil {
+		err = fmt.Errorf("unable to get current resources: %w", err)
+		return
+	}
+
+	allocatedNodes, err := h.getAllocatedNodeKeys(ctx, nodepool)
+	if err != nil {
+		err = fmt.Errorf("failed to get allocated nodes for %s: %w", nodepool.Name, err)
+		return
+	}
+
+	now := h.clock.Now()
+	for _, nodename := range allocatedNodes {
+		node := &hwmgmtv1alpha1.Node{}
+		if err = h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
+			err = fmt.Errorf("failed to get Node %s: %w", nodename, err)
+			return
+		}
+
+		if meta.IsStatusConditionTrue(node.Status.Conditions, string(hwmgmtv1alpha1.Provisioned)) {
+			continue
+		}
+
+		nodeinfo, exists := resources.Nodes[nodename]
+		if !exists {
+			err = fmt.Errorf("unable to find nodeinfo for %s", nodename)
+			return
+		}
+
+		// Nodes without a valid allocation time are provisioned immediately
+		if allocatedAt, parseErr := time.Parse(time.RFC3339, node.Annotations[utils.AllocatedAtAnnotation]); parseErr == nil {
+			if remaining := allocatedAt.Add(h.provisionDelay(nodeinfo)).Sub(now); remaining > 0 {
+				if requeueAfter == 0 || remaining < requeueAfter {
+					requeueAfter = remaining
+				}
+				continue
+			}
+		}
+
+		if err = h.provisionNode(ctx, nodename, nodeinfo); err != nil {
+			err = fmt.Errorf("failed to update node status (%s): %w", nodename, err)
+			return
+		}
+	}
+
+	return
+}
+
+// ReconcileNodePoolSize releases the nodes allocated to the nodegroups of a NodePool CR beyond their requested size,
+// such as after their size is reduced, returning the released nodes. The most recently allocated nodes of a nodegroup
+// are released first.
+func (h *HwMgrService) ReconcileNodePoolSize(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) ([]string, error) {
+	_, _, allocations, err := h.GetCurrentResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	cloud := allocations.findCloud(nodepool.Spec.CloudID)
+	if cloud == nil {
+		return nil, nil
+	}
+
+	var surplus []string
+	for _, nodegroup := range nodepool.Spec.NodeGroup {
+		// A nodegroup requesting a number of accelerators is not sized by node count
+		if acceleratorTarget(nodepool, nodegroup) > 0 {
+			continue
+		}
+
+		// The nodes of a nodegroup are recorded in the order they were allocated
+		if allocated := cloud.Nodegroups[nodegroup.Name]; len(allocated) > nodegroup.Size {
+			surplus = append(surplus, allocated[nodegroup.Size:]...)
+		}
+	}
+	if len(surplus) == 0 {
+		return nil, nil
+	}
+
+	h.logger.InfoContext(ctx, "Releasing surplus nodes of scaled down nodegroups", "cloudID", nodepool.Spec.CloudID, "nodes", surplus)
+	if err := h.releaseNodes(ctx, nodepool.Spec.CloudID, surplus); err != nil {
+		return nil, fmt.Errorf("failed to release surplus nodes: %w", err)
+	}
+
+	slices.Sort(surplus)
+	return surplus, nil
+}
+
 // CheckNodePoolProgress checks to see if a NodePool is fully allocated, allocating additional resources as needed
 func (h *HwMgrService) CheckNodePoolProgress(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (full bool, err error) {
 	cloudID := nodepool.Spec.CloudID
 
+	if _, err = h.ReconcileNodePoolSize(ctx, nodepool); err != nil {
+		return
+	}
+
 	if full, err = h.IsNodeFullyAllocated(ctx, nodepool); err != nil {
 		err = fmt.Errorf("failed to check nodepool allocation: %w", err)
 		return
@@ -486,10 +2490,161 @@ func (h *HwMgrService) CheckNodePoolProgress(ctx context.Context, nodepool *hwmg
 		}
 	}
 
+	if full, err = h.IsNodeFullyAllocated(ctx, nodepool); err != nil {
+		err = fmt.Errorf("failed to check nodepool allocation: %w", err)
+	}
+
+	return
+}
+
+// RecoverAllocations rebuilds the allocations data in the nodelist configmap from the existing Node CRs, in the event
+// that it has been lost. Nothing is done if the configmap has any allocations recorded.
+func (h *HwMgrService) RecoverAllocations(ctx context.Context) (recovered bool, err error) {
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	err = h.retryOnConflict(ctx, func() (err error) {
+		recovered, err = h.recoverAllocationsOnce(ctx)
+		return err
+	})
+	return
+}
+
+// recoverAllocationsOnce makes a single attempt to rebuild the allocations data from the existing Node CRs. It must be
+// called with the allocation lock held.
+func (h *HwMgrService) recoverAllocationsOnce(ctx context.Context) (recovered bool, err error) {
+	cm, resources, allocations, err := h.GetCurrentResources(ctx)
+	if err != nil {
+		err = fmt.Errorf("unable to get current resources: %w", err)
+		return
+	}
+
+	if len(allocations.Clouds) != 0 || len(allocations.Warm) != 0 {
+		return
+	}
+
+	nodes := &hwmgmtv1alpha1.NodeList{}
+	if err = h.Client.List(ctx, nodes, client.InNamespace(h.namespace)); err != nil {
+		err = fmt.Errorf("failed to list nodes: %w", err)
+		return
+	}
+
+	for _, node := range nodes.Items {
+		if node.DeletionTimestamp != nil {
+			continue
+		}
+
+		key := inventoryKey(&node)
+		if _, exists := resources.Nodes[key]; !exists {
+			h.logger.InfoContext(ctx, "skipping node not found in inventory", "nodename", node.Name)
+			continue
+		}
+
+		if utils.IsAnnotationTrue(&node, utils.WarmAnnotation) {
+			allocations.Warm = append(allocations.Warm, key)
+			continue
+		}
+
+		cloud := allocations.addCloud(node.Spec.NodePool)
+		cloud.Nodegroups[node.Spec.GroupName] = append(cloud.Nodegroups[node.Spec.GroupName], key)
+	}
+
+	if len(allocations.Clouds) == 0 && len(allocations.Warm) == 0 {
+		return
+	}
+
+	slices.Sort(allocations.Warm)
+	for _, cloud := range allocations.Clouds {
+		for groupname := range cloud.Nodegroups {
+			slices.Sort(cloud.Nodegroups[groupname])
+		}
+	}
+
+	h.logger.InfoContext(ctx, "Recovering allocations from Node CRs",
+		"clouds", len(allocations.Clouds), "warm", len(allocations.Warm))
+
+	if err = h.updateAllocations(ctx, cm, allocations); err != nil {
+		return
+	}
+
+	recovered = true
+	return
+}
+
+// RestoreMissingNodes recreates the bmc-secrets and Node CRs of the nodes allocated to a NodePool that have no Node CR,
+// such as when the controller was restarted after recording an allocation but before creating its Node CR. The restored
+// nodes are marked as allocated, pending provisioning, and their inventory keys are returned.
+func (h *HwMgrService) RestoreMissingNodes(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (restored []string, err error) {
+	cloudID := nodepool.Spec.CloudID
+
+	// Hold the lock so that allocations in progress, or being released, are not seen as missing their Node CRs
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	_, resources, allocations, err := h.GetCurrentResources(ctx)
+	if err != nil {
+		err = fmt.Errorf("unable to get current resources: %w", err)
+		return
+	}
+
+	cloud := allocations.findCloud(cloudID)
+	if cloud == nil {
+		return
+	}
+
+	groupnames := make([]string, 0, len(cloud.Nodegroups))
+	for groupname := range cloud.Nodegroups {
+		groupnames = append(groupnames, groupname)
+	}
+	slices.Sort(groupnames)
+
+	for _, groupname := range groupnames {
+		for _, nodename := range cloud.Nodegroups[groupname] {
+			err = h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, &hwmgmtv1alpha1.Node{})
+			if err == nil {
+				continue
+			}
+			if !apierrors.IsNotFound(err) {
+				err = fmt.Errorf("failed to get Node %s: %w", nodename, err)
+				return
+			}
+			err = nil
+
+			nodeinfo, exists := resources.Nodes[nodename]
+			if !exists {
+				err = fmt.Errorf("unable to find nodeinfo for %s", nodename)
+				return
+			}
+
+			h.logger.InfoContext(ctx, "Restoring missing Node CR for allocated node",
+				"cloudID", cloudID, "nodegroup name", groupname, "nodename", nodename)
+
+			if err = h.createNodeBMCSecret(ctx, nodename, nodeinfo.BMC); err != nil {
+				err = fmt.Errorf("failed to restore bmc-secret for node %s: %w", nodename, err)
+				return
+			}
+
+			err = h.CreateNode(ctx, cloudID, nodename, groupname, nodeinfo.HwProfile, nodeinfo.Labels,
+				NodeSelection{Strategy: SelectionStrategyRestore})
+			if err != nil {
+				err = fmt.Errorf("failed to restore node %s: %w", nodename, err)
+				return
+			}
+
+			if err = h.SetNodeAllocated(ctx, nodename); err != nil {
+				err = fmt.Errorf("failed to update node status (%s): %w", nodename, err)
+				return
+			}
+
+			restored = append(restored, nodename)
+		}
+	}
+
 	return
 }
 
-// ReleaseNodePool frees resources allocated to a NodePool
+// ReleaseNodePool frees resources allocated to a NodePool. The nodes are only freed once they are verified to be powered
+// off and their Node CRs are gone, with a ReleasePendingError returned until then, so that the release is repeated.
 func (h *HwMgrService) ReleaseNodePool(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
 	cloudID := nodepool.Spec.CloudID
 
@@ -497,32 +2652,94 @@ func (h *HwMgrService) ReleaseNodePool(ctx context.Context, nodepool *hwmgmtv1al
 		"cloudID", cloudID,
 	)
 
-	cm, _, allocations, err := h.GetCurrentResources(ctx)
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	// The deletions of the Node CRs and bmc-secrets are idempotent, so a release is simply repeated from the current
+	// allocations if the configmap was modified since it was read
+	return h.retryOnConflict(ctx, func() error {
+		return h.releaseNodePoolOnce(ctx, nodepool)
+	}, "cloudID", cloudID)
+}
+
+// releaseNodePoolOnce makes a single attempt to release the nodes allocated to a NodePool from the current allocations.
+// It must be called with the allocation lock held.
+func (h *HwMgrService) releaseNodePoolOnce(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) error {
+	cloudID := nodepool.Spec.CloudID
+
+	cm, resources, allocations, err := h.GetCurrentResources(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to get current resources: %w", err)
 	}
 
-	index := -1
-	for i, cloud := range allocations.Clouds {
-		if cloud.CloudID == cloudID {
-			index = i
-			break
-		}
-	}
-
+	index := slices.IndexFunc(allocations.Clouds, func(cloud cmAllocatedCloud) bool { return cloud.CloudID == cloudID })
 	if index == -1 {
 		h.logger.InfoContext(ctx, "no allocated nodes found", "cloudID", cloudID)
 		return nil
 	}
 
-	for groupname := range allocations.Clouds[index].Nodegroups {
-		for _, nodename := range allocations.Clouds[index].Nodegroups[groupname] {
-			if err := h.DeleteBMCSecret(ctx, nodename); err != nil {
-				return fmt.Errorf("failed to delete bmc-secret for %s: %w", nodename, err)
+	// Leave the nodes allocated, along with their Node CRs, until they are all verified to be powered off. The
+	// finalizer waits for the Node CRs to be deleted, so the release is retried until then.
+	pending, err := h.unverifiedNodes(ctx, resources, allocations.Clouds[index])
+	if err != nil {
+		return err
+	}
+	if len(pending) != 0 {
+		h.logger.InfoContext(ctx, "Waiting for nodes to be powered off before release", "cloudID", cloudID, "nodes", pending)
+		return &ReleasePendingError{Reason: "waiting for nodes to be powered off", Nodes: pending}
+	}
+
+	// The nodegroups are torn down in tiers by their teardown priority, with the Node CRs of a tier deleted only once
+	// those of the tiers before it are gone
+	released := 0
+	for _, groupnames := range teardownTiers(nodepool, allocations.Clouds[index].Nodegroups) {
+		var tier []string
+		for _, groupname := range groupnames {
+			for _, nodename := range allocations.Clouds[index].Nodegroups[groupname] {
+				released++
+				if err := h.DeleteBMCSecret(ctx, nodename); err != nil {
+					return fmt.Errorf("failed to delete bmc-secret for %s: %w", nodename, err)
+				}
+
+				if err := h.DeleteNode(ctx, nodename); err != nil {
+					return fmt.Errorf("failed to delete node %s: %w", nodename, err)
+				}
+
+				if nodeinfo, exists := resources.Nodes[nodename]; exists {
+					utils.IncrementCounterAnnotation(cm, utils.ReleasedCountAnnotationPrefix+nodeinfo.HwProfile, 1)
+				}
+				h.recordHistory(&allocations, historyActionReleased, cloudID, groupname, nodename)
+				tier = append(tier, nodename)
 			}
+		}
+
+		// A Node CR held by another finalizer is only marked for deletion, so the nodes are left allocated until all
+		// of their Node CRs are gone, with the release retried until then
+		pending, err = h.pendingNodeDeletions(ctx, tier)
+		if err != nil {
+			return err
+		}
+		if len(pending) != 0 {
+			h.logger.InfoContext(ctx, "Waiting for Node CRs to be deleted before release",
+				"cloudID", cloudID, "nodegroups", groupnames, "nodes", pending)
+			return &ReleasePendingError{Reason: "waiting for Node CRs to be deleted", Nodes: pending}
+		}
+	}
 
-			if err := h.DeleteNode(ctx, nodename); err != nil {
-				return fmt.Errorf("failed to delete node %s: %w", nodename, err)
+	if h.releaseCooldown > 0 {
+		// Record the release times for the cooldown, dropping any that have already expired
+		now := h.clock.Now()
+		for nodename, releaseTime := range allocations.Released {
+			if !now.Before(releaseTime.Add(h.releaseCooldown)) {
+				delete(allocations.Released, nodename)
+			}
+		}
+		if allocations.Released == nil {
+			allocations.Released = make(map[string]metav1.Time)
+		}
+		for groupname := range allocations.Clouds[index].Nodegroups {
+			for _, nodename := range allocations.Clouds[index].Nodegroups[groupname] {
+				allocations.Released[nodename] = metav1.NewTime(now)
 			}
 		}
 	}
@@ -530,14 +2747,185 @@ func (h *HwMgrService) ReleaseNodePool(ctx context.Context, nodepool *hwmgmtv1al
 	allocations.Clouds = slices.Delete[[]cmAllocatedCloud](allocations.Clouds, index, index+1)
 
 	// Update the configmap
-	yamlString, err := yaml.Marshal(&allocations)
+	if err := h.updateAllocations(ctx, cm, allocations); err != nil {
+		return err
+	}
+	nodesReleased.Add(float64(released))
+
+	return nil
+}
+
+// pendingNodeDeletions returns the specified nodes whose Node CRs still exist, such as while they are held by the
+// finalizers of others after being deleted
+func (h *HwMgrService) pendingNodeDeletions(ctx context.Context, nodenames []string) ([]string, error) {
+	var pending []string
+	for _, nodename := range nodenames {
+		err := h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace},
+			&hwmgmtv1alpha1.Node{})
+		if err == nil {
+			pending = append(pending, nodename)
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get Node %s: %w", nodename, err)
+		}
+	}
+
+	slices.Sort(pending)
+	return pending, nil
+}
+
+// SwapNode replaces an allocated node in a nodegroup of a NodePool with a free node of the same hardware profile, which
+// must satisfy the same criteria as any node allocated to the nodegroup. The configmap is updated in a single write, so
+// the nodegroup is never seen as under-allocated. The Node CR and bmc-secret for the new node are created before the
+// update, and those of the old node are deleted after it. The new node is left allocated, to be provisioned by the
+// controller as any newly allocated node is.
+func (h *HwMgrService) SwapNode(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool, groupname, oldNode,
+	newNode string) error {
+	cloudID := nodepool.Spec.CloudID
+	h.logger.InfoContext(ctx, "Processing SwapNode request:",
+		"cloudID", cloudID,
+		"nodegroup name", groupname,
+		"old node", oldNode,
+		"new node", newNode,
+	)
+
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	_, resources, allocations, err := h.GetCurrentResources(ctx)
 	if err != nil {
-		return fmt.Errorf("unable to marshal allocated data: %w", err)
+		return fmt.Errorf("unable to get current resources: %w", err)
 	}
-	cm.Data[allocationsKey] = string(yamlString)
-	if err := h.Client.Update(ctx, cm); err != nil {
-		return fmt.Errorf("failed to update configmap: %w", err)
+
+	_, _, filters, err := h.checkSwap(resources, allocations, nodepool, groupname, oldNode, newNode)
+	if err != nil {
+		return err
+	}
+	nodeinfo := resources.Nodes[newNode]
+
+	// Set up the new node before it is recorded in the configmap
+	if err := h.createNodeBMCSecret(ctx, newNode, nodeinfo.BMC); err != nil {
+		return fmt.Errorf("failed to create bmc-secret when swapping in node %s: %w", newNode, err)
+	}
+
+	cleanup := func() {
+		if err := h.DeleteNode(ctx, newNode); err != nil {
+			h.logger.ErrorContext(ctx, "failed to clean up node after failed swap", "nodename", newNode, "err", err)
+		}
+		if err := h.DeleteBMCSecret(ctx, newNode); err != nil {
+			h.logger.ErrorContext(ctx, "failed to clean up bmc-secret after failed swap", "nodename", newNode, "err", err)
+		}
+	}
+
+	selection := NodeSelection{Strategy: SelectionStrategySwap}
+	for _, filter := range filters {
+		selection.Criteria = append(selection.Criteria, filter.reason)
+	}
+	if err := h.CreateNode(ctx, cloudID, newNode, groupname, nodeinfo.HwProfile, nodeinfo.Labels, selection); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to create swapped in node (%s): %w", newNode, err)
+	}
+
+	if err := h.SetNodeAllocated(ctx, newNode); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to update node status (%s): %w", newNode, err)
+	}
+
+	// Replace the node in a single configmap update, checking the swap again against the current allocations if the
+	// configmap was modified since it was read
+	err = h.retryOnConflict(ctx, func() error {
+		cm, resources, allocations, err := h.GetCurrentResources(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to get current resources: %w", err)
+		}
+
+		cloud, index, _, err := h.checkSwap(resources, allocations, nodepool, groupname, oldNode, newNode)
+		if err != nil {
+			return err
+		}
+
+		cloud.Nodegroups[groupname][index] = newNode
+		delete(allocations.Released, newNode)
+		utils.IncrementCounterAnnotation(cm, utils.AllocatedCountAnnotationPrefix+nodeinfo.HwProfile, 1)
+		utils.IncrementCounterAnnotation(cm, utils.ReleasedCountAnnotationPrefix+resources.Nodes[oldNode].HwProfile, 1)
+		h.recordHistory(&allocations, historyActionReleased, cloudID, groupname, oldNode)
+		h.recordHistory(&allocations, historyActionAllocated, cloudID, groupname, newNode)
+		if h.releaseCooldown > 0 {
+			if allocations.Released == nil {
+				allocations.Released = make(map[string]metav1.Time)
+			}
+			allocations.Released[oldNode] = metav1.NewTime(h.clock.Now())
+		}
+
+		return h.updateAllocations(ctx, cm, allocations)
+	}, "cloudID", cloudID)
+	if err != nil {
+		cleanup()
+		return err
+	}
+
+	// The old node is no longer allocated, so clean up its resources
+	if err := h.DeleteBMCSecret(ctx, oldNode); err != nil {
+		return fmt.Errorf("failed to delete bmc-secret for %s: %w", oldNode, err)
+	}
+
+	if err := h.DeleteNode(ctx, oldNode); err != nil {
+		return fmt.Errorf("failed to delete node %s: %w", oldNode, err)
 	}
 
 	return nil
 }
+
+// checkSwap verifies that a node is allocated to a nodegroup of a NodePool and can be replaced by a free node of the
+// same hardware profile that passes the filters applied to the candidates for the nodegroup, returning the cloud, the
+// position of the old node in its nodegroup, and the filters
+func (h *HwMgrService) checkSwap(resources cmResources, allocations cmAllocations, nodepool *hwmgmtv1alpha1.NodePool,
+	groupname, oldNode, newNode string) (*cmAllocatedCloud, int, []candidateFilter, error) {
+	cloudID := nodepool.Spec.CloudID
+	cloud := allocations.findCloud(cloudID)
+	if cloud == nil {
+		return nil, -1, nil, fmt.Errorf("no nodes allocated to cloud %s", cloudID)
+	}
+
+	index := slices.Index(cloud.Nodegroups[groupname], oldNode)
+	if index == -1 {
+		return nil, -1, nil, fmt.Errorf("node %s is not allocated to nodegroup %s of cloud %s", oldNode, groupname, cloudID)
+	}
+
+	groupIndex := slices.IndexFunc(nodepool.Spec.NodeGroup, func(nodegroup hwmgmtv1alpha1.NodeGroup) bool {
+		return nodegroup.Name == groupname
+	})
+	if groupIndex == -1 {
+		return nil, -1, nil, fmt.Errorf("nodegroup %s not found in NodePool %s", groupname, nodepool.Name)
+	}
+
+	oldinfo := resources.Nodes[oldNode]
+	nodeinfo, exists := resources.Nodes[newNode]
+	if !exists {
+		return nil, -1, nil, fmt.Errorf("unable to find nodeinfo for %s", newNode)
+	}
+	if nodeinfo.HwProfile != oldinfo.HwProfile {
+		return nil, -1, nil, fmt.Errorf("node %s has hardware profile %s, expected %s", newNode, nodeinfo.HwProfile,
+			oldinfo.HwProfile)
+	}
+	if getNodesInUse(allocations)[newNode] {
+		return nil, -1, nil, fmt.Errorf("node %s is not free", newNode)
+	}
+	if slices.Contains(allocations.Warm, newNode) {
+		return nil, -1, nil, fmt.Errorf("node %s is in the warm pool", newNode)
+	}
+
+	// The filters are applied as if the old node were already released, so that it does not count against the limits,
+	// such as those of its rack, that the new node is checked against
+	remaining := allocations.deepCopy()
+	remainingCloud := remaining.findCloud(cloudID)
+	remainingCloud.Nodegroups[groupname] = slices.Delete(remainingCloud.Nodegroups[groupname], index, index+1)
+	filters := h.nodeFilters(resources, remaining, nodepool, nodepool.Spec.NodeGroup[groupIndex])
+	if reason := rejectedBy(newNode, nodeinfo, filters); reason != "" {
+		return nil, -1, nil, fmt.Errorf("node %s cannot be allocated to nodegroup %s of cloud %s: rejected by %s filter",
+			newNode, groupname, cloudID, reason)
+	}
+
+	return cloud, index, filters, nil
+}
@@ -0,0 +1,3122 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+const testResources = `
+hwprofiles:
+  - profile-a
+  - profile-b
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    bmc:
+      address: "redfish+https://192.168.1.0/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+    hostname: node-a-0.localhost
+  node-a-1:
+    hwprofile: profile-a
+    bmc:
+      address: "redfish+https://192.168.1.1/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+    hostname: node-a-1.localhost
+  node-a-2:
+    hwprofile: profile-a
+    bmc:
+      address: "redfish+https://192.168.1.2/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+    hostname: node-a-2.localhost
+    serial: SN-A2
+  node-a-3:
+    hwprofile: profile-a
+    bmc:
+      address: "redfish+https://192.168.1.3/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+    hostname: node-a-3.localhost
+    serial: SN-A3
+  node-b-0:
+    hwprofile: profile-b
+    bmc:
+      address: "redfish+https://192.168.2.0/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+    hostname: node-b-0.localhost
+  node-b-1:
+    hwprofile: profile-b
+    bmc:
+      address: "redfish+https://192.168.2.1/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+    hostname: node-b-1.localhost
+`
+
+func newTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(hwmgmtv1alpha1.AddToScheme(scheme)).To(Succeed())
+	return scheme
+}
+
+func newNodelistConfigMap(resources, allocations string) *corev1.ConfigMap {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      defaultCMName,
+			Namespace: testNamespace,
+		},
+		Data: map[string]string{
+			defaultResourcesKey: resources,
+		},
+	}
+	if allocations != "" {
+		cm.Data[defaultAllocationsKey] = allocations
+	}
+	return cm
+}
+
+func newNodePool(name, cloudID string, groups ...hwmgmtv1alpha1.NodeGroup) *hwmgmtv1alpha1.NodePool {
+	return &hwmgmtv1alpha1.NodePool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Spec: hwmgmtv1alpha1.NodePoolSpec{
+			CloudID:   cloudID,
+			NodeGroup: groups,
+		},
+	}
+}
+
+func newFakeClientBuilder(objs ...client.Object) *fake.ClientBuilder {
+	return fake.NewClientBuilder().
+		WithScheme(newTestScheme()).
+		WithObjects(objs...).
+		WithStatusSubresource(&hwmgmtv1alpha1.NodePool{}, &hwmgmtv1alpha1.Node{})
+}
+
+func newTestService(c client.Client) *HwMgrService {
+	hwmgr, err := NewHwMgrService().
+		SetClient(c).
+		SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+		SetAllocationDelay(0).
+		Build(context.Background())
+	Expect(err).ToNot(HaveOccurred())
+	return hwmgr
+}
+
+func getAllocations(ctx context.Context, c client.Client) cmAllocations {
+	cm := &corev1.ConfigMap{}
+	Expect(c.Get(ctx, types.NamespacedName{Name: defaultCMName, Namespace: testNamespace}, cm)).To(Succeed())
+	if _, exists := cm.Data[defaultAllocationsKey]; !exists {
+		return cmAllocations{}
+	}
+	allocations, err := utils.ExtractDataFromConfigMap[cmAllocations](cm, defaultAllocationsKey)
+	Expect(err).ToNot(HaveOccurred())
+	return allocations
+}
+
+// fakeReleaseVerifier reports the nodes in poweredOff as powered off
+type fakeReleaseVerifier struct {
+	poweredOff map[string]bool
+}
+
+func (v *fakeReleaseVerifier) VerifyPoweredOff(_ context.Context, nodename, _ string) (bool, error) {
+	return v.poweredOff[nodename], nil
+}
+
+// fakeCredentialVerifier reports the credentials of the nodes in invalid as rejected by their BMCs
+type fakeCredentialVerifier struct {
+	invalid map[string]bool
+}
+
+func (v *fakeCredentialVerifier) VerifyCredentials(_ context.Context, nodename, _ string, _, _ []byte) (bool, error) {
+	return !v.invalid[nodename], nil
+}
+
+// lastNodeAllocator selects the lexically last of the free nodes, or the node in selected if set
+type lastNodeAllocator struct {
+	selected string
+}
+
+func (a *lastNodeAllocator) SelectNode(_ string, free []string, _ cmResources) (string, error) {
+	if a.selected != "" {
+		return a.selected, nil
+	}
+	return slices.Max(free), nil
+}
+
+// counterValue gets the current value of a counter metric
+func counterValue(counter prometheus.Counter) float64 {
+	metric := &dto.Metric{}
+	Expect(counter.Write(metric)).To(Succeed())
+	return metric.GetCounter().GetValue()
+}
+
+// scrapeMetric gathers the metrics registry, as a scrape of the metrics endpoint would, and returns the value of the
+// counter or gauge with the specified name and labels, or zero if it has not been recorded
+func scrapeMetric(name string, labels map[string]string) float64 {
+	families, err := metrics.Registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			matched := 0
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] == label.GetValue() {
+					matched++
+				}
+			}
+			if matched != len(labels) {
+				continue
+			}
+			if metric.GetCounter() != nil {
+				return metric.GetCounter().GetValue()
+			}
+			return metric.GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+var _ = Describe("HwMgrService", func() {
+	var (
+		ctx   context.Context
+		c     client.Client
+		hwmgr *HwMgrService
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		c = newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build()
+		hwmgr = newTestService(c)
+	})
+
+	Context("when allocating a node", func() {
+		It("marks the Node as Allocated before Provisioned", func() {
+			var reasons []string
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string,
+						obj client.Object, opts ...client.SubResourceUpdateOption) error {
+						if node, ok := obj.(*hwmgmtv1alpha1.Node); ok {
+							condition := meta.FindStatusCondition(node.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))
+							Expect(condition).ToNot(BeNil())
+							reasons = append(reasons, condition.Reason)
+						}
+						return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(reasons).To(Equal([]string{string(utils.Allocated)}))
+
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+			Expect(reasons).To(Equal([]string{string(utils.Allocated), string(hwmgmtv1alpha1.Completed)}))
+
+			provisioned, err := hwmgr.IsNodePoolProvisioned(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(provisioned).To(BeTrue())
+		})
+	})
+
+	Context("when the allocation delay is configured", func() {
+		It("provisions the nodes promptly with a zero delay", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+
+			start := time.Now()
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+			provisioned, err := hwmgr.IsNodePoolProvisioned(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(provisioned).To(BeTrue())
+		})
+
+		It("defaults to 10 seconds", func() {
+			var err error
+			hwmgr, err = NewHwMgrService().
+				SetClient(c).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetClock(clocktesting.NewFakeClock(time.Now())).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(Equal(10 * time.Second))
+		})
+
+		It("rejects a negative delay", func() {
+			_, err := NewHwMgrService().
+				SetClient(c).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetAllocationDelay(-time.Second).
+				Build(ctx)
+			Expect(err).To(MatchError("allocation delay must not be negative"))
+		})
+	})
+
+	Context("when the allocation timeout is configured", func() {
+		It("fails an allocation on a slow apiserver independently of the allocation delay", func() {
+			// The fake client ignores the context, so the slow creation of the Node CR honors it as the apiserver would
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+						if _, ok := obj.(*hwmgmtv1alpha1.Node); ok {
+							select {
+							case <-ctx.Done():
+								return ctx.Err()
+							case <-time.After(time.Minute):
+							}
+						}
+						return c.Create(ctx, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+			hwmgr.allocationDelay = time.Hour
+			hwmgr.allocationTimeout = 100 * time.Millisecond
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 1})
+			start := time.Now()
+			err := hwmgr.AllocateNode(ctx, nodepool)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(time.Since(start)).To(BeNumerically("<", 10*time.Second))
+
+			// The bmc-secret created before the timeout is removed again, and the node is left free
+			secret := &corev1.Secret{}
+			err = c.Get(ctx, types.NamespacedName{Name: hwmgr.bmcSecretName("node-b-0"), Namespace: testNamespace}, secret)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			Expect(getAllocations(ctx, c).Clouds).To(BeEmpty())
+		})
+
+		It("rejects a negative timeout", func() {
+			_, err := NewHwMgrService().
+				SetClient(c).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetAllocationTimeout(-time.Second).
+				Build(ctx)
+			Expect(err).To(MatchError("allocation timeout must not be negative"))
+		})
+	})
+
+	Context("when listing the free nodes of a profile", func() {
+		It("returns them in the same order every time", func() {
+			allocations := cmAllocations{Clouds: []cmAllocatedCloud{
+				{CloudID: "cloud-1", Nodegroups: map[string][]string{"master": {"node-a-1"}}},
+			}}
+			_, resources, _, err := hwmgr.GetCurrentResources(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			// Map iteration order varies between runs, so repeat enough times to expose any dependency on it
+			expected := []string{"node-a-0", "node-a-2", "node-a-3"}
+			for i := 0; i < 100; i++ {
+				Expect(getFreeNodesInProfile(resources, allocations, "profile-a")).To(Equal(expected))
+			}
+		})
+	})
+
+	Context("when a nodegroup has excluded nodes", func() {
+		It("selects a node that is not excluded", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 1})
+
+			// Without the exclusion, node-b-0 would be chosen
+			_, resources, allocations, err := hwmgr.GetCurrentResources(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(getFreeNodesInProfile(resources, allocations, "profile-b")).To(HaveExactElements("node-b-0", "node-b-1"))
+
+			nodepool.Annotations = map[string]string{utils.ExcludeNodesAnnotationPrefix + "master": "node-b-0"}
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			allocatedNodes, err := hwmgr.GetAllocatedNodes(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(allocatedNodes).To(Equal([]string{"node-b-1"}))
+		})
+
+		It("does not admit the NodePool if too few nodes remain", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 2})
+			nodepool.Annotations = map[string]string{utils.ExcludeNodesAnnotationPrefix + "master": "node-b-0, node-b-1"}
+
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).ToNot(Succeed())
+		})
+	})
+
+	Context("when a NodePool has the debug annotation", func() {
+		It("traces the allocation decisions for that NodePool only", func() {
+			var logs bytes.Buffer
+			hwmgr.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+			np1 := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np1)).To(Succeed())
+			Expect(logs.String()).ToNot(ContainSubstring("allocation trace"))
+
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			np2.Annotations = map[string]string{utils.DebugAnnotation: "true"}
+			Expect(hwmgr.AllocateNode(ctx, np2)).To(Succeed())
+			Expect(logs.String()).To(ContainSubstring(`msg="allocation trace: candidate nodes for nodegroup" nodepool=np2`))
+			Expect(logs.String()).To(ContainSubstring(`msg="allocation trace: picked node for nodegroup" nodepool=np2 nodegroup=master nodename=node-a-1`))
+			Expect(logs.String()).ToNot(ContainSubstring("nodepool=np1"))
+		})
+	})
+
+	Context("when selecting nodes from a mixed inventory", func() {
+		It("counts the candidates filtered out of each selection by reason", func() {
+			before := map[string]float64{}
+			for _, reason := range []string{filteredReasonProfile, filteredReasonAllocated, filteredReasonExcluded} {
+				before[reason] = counterValue(allocationFiltered.WithLabelValues(reason))
+			}
+			candidates := counterValue(allocationCandidates)
+			selected := counterValue(allocationSelected)
+
+			np1 := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np1)).To(Succeed())
+
+			// Each of the six nodes is considered, with the two nodes of profile-b filtered out
+			Expect(counterValue(allocationCandidates) - candidates).To(Equal(6.0))
+			Expect(counterValue(allocationFiltered.WithLabelValues(filteredReasonProfile)) - before[filteredReasonProfile]).To(Equal(2.0))
+			Expect(counterValue(allocationSelected) - selected).To(Equal(1.0))
+
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			np2.Annotations = map[string]string{utils.ExcludeNodesAnnotationPrefix + "master": "node-a-1"}
+			Expect(hwmgr.AllocateNode(ctx, np2)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, np2)).To(Equal([]string{"node-a-2"}))
+
+			Expect(counterValue(allocationCandidates) - candidates).To(Equal(12.0))
+			Expect(counterValue(allocationFiltered.WithLabelValues(filteredReasonProfile)) - before[filteredReasonProfile]).To(Equal(4.0))
+			Expect(counterValue(allocationFiltered.WithLabelValues(filteredReasonAllocated)) - before[filteredReasonAllocated]).To(Equal(1.0))
+			Expect(counterValue(allocationFiltered.WithLabelValues(filteredReasonExcluded)) - before[filteredReasonExcluded]).To(Equal(1.0))
+			Expect(counterValue(allocationSelected) - selected).To(Equal(2.0))
+		})
+
+		It("does not count a planned allocation", func() {
+			candidates := counterValue(allocationCandidates)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.PlanAllocation(ctx, nodepool)).To(HaveLen(1))
+			Expect(counterValue(allocationCandidates)).To(Equal(candidates))
+		})
+	})
+
+	Context("when allocations are scraped from the metrics registry", func() {
+		It("counts the allocated and released nodes, and the shortfalls", func() {
+			allocated := scrapeMetric("oran_hwmgr_nodes_allocated_total", nil)
+			released := scrapeMetric("oran_hwmgr_nodes_released_total", nil)
+			admissionShortfalls := scrapeMetric("oran_hwmgr_insufficient_resources_total", map[string]string{"operation": "admission"})
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1})
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.UpdateFreeNodesMetric(ctx)).To(Succeed())
+
+			Expect(scrapeMetric("oran_hwmgr_nodes_allocated_total", nil) - allocated).To(Equal(2.0))
+			Expect(scrapeMetric("oran_hwmgr_free_nodes", map[string]string{"hwprofile": "profile-a"})).To(Equal(3.0))
+			Expect(scrapeMetric("oran_hwmgr_free_nodes", map[string]string{"hwprofile": "profile-b"})).To(Equal(1.0))
+
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 2})
+			Expect(hwmgr.ProcessNewNodePool(ctx, np2)).ToNot(Succeed())
+			Expect(scrapeMetric("oran_hwmgr_insufficient_resources_total", map[string]string{"operation": "admission"}) -
+				admissionShortfalls).To(Equal(1.0))
+
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.UpdateFreeNodesMetric(ctx)).To(Succeed())
+			Expect(scrapeMetric("oran_hwmgr_nodes_released_total", nil) - released).To(Equal(2.0))
+			Expect(scrapeMetric("oran_hwmgr_free_nodes", map[string]string{"hwprofile": "profile-b"})).To(Equal(2.0))
+		})
+	})
+
+	Context("when the fleet utilization is scraped from the metrics registry", func() {
+		It("reports the share of the nodes across all profiles that are allocated", func() {
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+        - node-a-1
+      worker:
+        - node-b-0
+`
+			hwmgr = newTestService(newFakeClientBuilder(newNodelistConfigMap(testResources, allocations)).Build())
+			Expect(hwmgr.UpdateFreeNodesMetric(ctx)).To(Succeed())
+			Expect(scrapeMetric("oran_hwmgr_fleet_utilization_percent", nil)).To(Equal(50.0))
+
+			hwmgr = newTestService(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build())
+			Expect(hwmgr.UpdateFreeNodesMetric(ctx)).To(Succeed())
+			Expect(scrapeMetric("oran_hwmgr_fleet_utilization_percent", nil)).To(BeZero())
+		})
+	})
+
+	Context("when a nodegroup is pinned to serial numbers", func() {
+		It("allocates the node with the requested serial", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			nodepool.Annotations = map[string]string{utils.SerialsAnnotationPrefix + "master": "SN-A3"}
+
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-3"}))
+		})
+
+		It("does not admit the NodePool if no free node has the requested serial", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			nodepool.Annotations = map[string]string{utils.SerialsAnnotationPrefix + "master": "SN-UNKNOWN"}
+
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).ToNot(Succeed())
+		})
+	})
+
+	Context("when a nodegroup does not specify a hardware profile", func() {
+		It("does not admit the NodePool", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", Size: 1})
+
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(MatchError(
+				"nodegroup worker does not specify a hardware profile"))
+		})
+	})
+
+	Context("when the nodegroups of a NodePool are malformed", func() {
+		DescribeTable("does not admit the NodePool",
+			func(message string, groups ...hwmgmtv1alpha1.NodeGroup) {
+				nodepool := newNodePool("np1", "cloud-1", groups...)
+
+				err := hwmgr.ProcessNewNodePool(ctx, nodepool)
+				var invalid *InvalidSpecError
+				Expect(errors.As(err, &invalid)).To(BeTrue())
+				Expect(err).To(MatchError(message))
+				Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+			},
+			Entry("without nodegroups", "no nodegroups are specified"),
+			Entry("with a nodegroup without a name", "nodegroup 1 does not specify a name",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{HwProfile: "profile-b", Size: 1}),
+			Entry("with a nodegroup specified twice", "nodegroup master is specified more than once",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 1}),
+			Entry("with a nodegroup without a hardware profile", "nodegroup worker does not specify a hardware profile",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", Size: 1}),
+			Entry("with a nodegroup of size zero", "nodegroup worker has size 0, which must be positive",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b"}),
+			Entry("with a nodegroup of negative size", "nodegroup worker has size -1, which must be positive",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: -1}),
+		)
+	})
+
+	Context("when the allocation history is recorded", func() {
+		var fakeClock *clocktesting.FakeClock
+
+		BeforeEach(func() {
+			fakeClock = clocktesting.NewFakeClock(time.Now().Truncate(time.Second))
+			hwmgr.clock = fakeClock
+		})
+
+		allocate := func(name string) {
+			nodepool := newNodePool(name, "cloud-"+name, hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+		}
+
+		historyNodes := func() (nodes []string) {
+			for _, entry := range getAllocations(ctx, c).History {
+				nodes = append(nodes, entry.Action+":"+entry.Node)
+			}
+			return
+		}
+
+		It("records allocations and releases", func() {
+			hwmgr.historyLimit = 10
+
+			allocate("np1")
+			Expect(getAllocations(ctx, c).History).To(Equal([]cmHistoryEntry{{
+				Time:      metav1.NewTime(fakeClock.Now()),
+				Action:    historyActionAllocated,
+				CloudID:   "cloud-np1",
+				Nodegroup: "master",
+				Node:      "node-a-0",
+			}}))
+
+			Expect(hwmgr.ReleaseNodePool(ctx, newNodePool("np1", "cloud-np1"))).To(Succeed())
+			Expect(historyNodes()).To(Equal([]string{"allocated:node-a-0", "released:node-a-0"}))
+		})
+
+		It("drops the entries older than the maximum age", func() {
+			hwmgr.historyMaxAge = time.Hour
+
+			allocate("np1")
+			fakeClock.Step(40 * time.Minute)
+			allocate("np2")
+			fakeClock.Step(30 * time.Minute)
+			allocate("np3")
+
+			Expect(historyNodes()).To(Equal([]string{"allocated:node-a-1", "allocated:node-a-2"}))
+		})
+
+		It("drops the oldest entries beyond the limit", func() {
+			hwmgr.historyLimit = 2
+
+			allocate("np1")
+			allocate("np2")
+			allocate("np3")
+
+			Expect(historyNodes()).To(Equal([]string{"allocated:node-a-1", "allocated:node-a-2"}))
+		})
+
+		It("keeps the history valid against the inventory schema", func() {
+			hwmgr.historyLimit = 10
+			hwmgr.validateInventory = true
+
+			allocate("np1")
+			allocate("np2")
+			Expect(hwmgr.ReleaseNodePool(ctx, newNodePool("np1", "cloud-np1"))).To(Succeed())
+
+			_, _, allocations, err := hwmgr.GetCurrentResources(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(allocations.History).To(HaveLen(3))
+		})
+
+		It("records nothing if no retention is set", func() {
+			allocate("np1")
+			Expect(getAllocations(ctx, c).History).To(BeEmpty())
+		})
+	})
+
+	Context("when allocations are tentative", func() {
+		var fakeClock *clocktesting.FakeClock
+
+		BeforeEach(func() {
+			fakeClock = clocktesting.NewFakeClock(time.Now())
+			hwmgr.clock = fakeClock
+		})
+
+		getNode := func(nodename string) *hwmgmtv1alpha1.Node {
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: nodename, Namespace: testNamespace}, node)).To(Succeed())
+			return node
+		}
+
+		It("frees the node if the allocation expires without confirmation", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 1})
+			nodepool.Annotations = map[string]string{utils.TentativeAllocationTTLAnnotation: "5m"}
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(getNode("node-b-0").Annotations).To(HaveKeyWithValue(utils.TentativeAnnotation, "true"))
+
+			fakeClock.Step(3 * time.Minute)
+			heldUntil, expired, err := hwmgr.ReconcileTentativeAllocations(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(heldUntil).To(BeTemporally("~", fakeClock.Now().Add(2*time.Minute), time.Second))
+			Expect(expired).To(BeEmpty())
+
+			// The expiry is absolute, so it does not move with the time of the reconcile
+			fakeClock.Step(time.Minute)
+			laterHeldUntil, _, err := hwmgr.ReconcileTentativeAllocations(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(laterHeldUntil).To(Equal(heldUntil))
+
+			fakeClock.Step(time.Minute)
+			heldUntil, expired, err = hwmgr.ReconcileTentativeAllocations(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(heldUntil).To(BeZero())
+			Expect(expired).To(Equal([]string{"node-b-0"}))
+
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+			err = c.Get(ctx, types.NamespacedName{Name: "node-b-0", Namespace: testNamespace}, &hwmgmtv1alpha1.Node{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			err = c.Get(ctx, types.NamespacedName{Name: "node-b-0-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			capacity, err := hwmgr.GetCapacity(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(capacity["profile-b"].Allocated).To(BeZero())
+		})
+
+		It("keeps the allocation once confirmed", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 1})
+			nodepool.Annotations = map[string]string{utils.TentativeAllocationTTLAnnotation: "5m"}
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			node := getNode("node-b-0")
+			node.Annotations[utils.ConfirmedAnnotation] = "true"
+			Expect(c.Update(ctx, node)).To(Succeed())
+
+			_, expired, err := hwmgr.ReconcileTentativeAllocations(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(expired).To(BeEmpty())
+			Expect(getNode("node-b-0").Annotations).ToNot(HaveKey(utils.TentativeAnnotation))
+
+			fakeClock.Step(10 * time.Minute)
+			_, expired, err = hwmgr.ReconcileTentativeAllocations(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(expired).To(BeEmpty())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-b-0"}))
+		})
+	})
+
+	Context("when a warm pool is configured", func() {
+		It("draws from the warm nodes without incurring the provisioning delay", func() {
+			fakeClock := clocktesting.NewFakeClock(time.Now())
+			hwmgr.clock = fakeClock
+			hwmgr.allocationDelay = 10 * time.Second
+
+			// Fill the warm pool while the first node is allocated, so that the warm node is not the first free node
+			np1 := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np1)).To(Succeed())
+			Expect(hwmgr.ReplenishWarmPool(ctx, map[string]int{"profile-a": 1})).To(Succeed())
+			Expect(hwmgr.ReleaseNodePool(ctx, np1)).To(Succeed())
+			Expect(getAllocations(ctx, c).Warm).To(Equal([]string{"node-a-1"}))
+
+			fakeClock.Step(10 * time.Second)
+			Expect(hwmgr.ReplenishWarmPool(ctx, map[string]int{"profile-a": 1})).To(Succeed())
+			Expect(getAllocations(ctx, c).Warm).To(Equal([]string{"node-a-1"}))
+
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np2)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, np2)).To(Equal([]string{"node-a-1"}))
+			Expect(getAllocations(ctx, c).Warm).To(BeEmpty())
+
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, np2)).To(BeZero())
+			Expect(hwmgr.IsNodePoolProvisioned(ctx, np2)).To(BeTrue())
+
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-1", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Spec.NodePool).To(Equal("cloud-2"))
+			Expect(node.Spec.GroupName).To(Equal("master"))
+			Expect(node.Annotations).ToNot(HaveKey(utils.WarmAnnotation))
+
+			// A cold node is still subject to the provisioning delay
+			np3 := newNodePool("np3", "cloud-3",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np3)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, np3)).To(Equal([]string{"node-a-0"}))
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, np3)).To(Equal(10 * time.Second))
+		})
+
+		It("parses the warm pool sizes", func() {
+			Expect(ParseWarmPoolSizes("profile-a=2, profile-b=1")).To(Equal(map[string]int{"profile-a": 2, "profile-b": 1}))
+			_, err := ParseWarmPoolSizes("profile-a")
+			Expect(err).To(HaveOccurred())
+			_, err = ParseWarmPoolSizes("profile-a=-1")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when some nodes are allocated", func() {
+		It("reports the remaining nodes as idle", func() {
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+        - node-a-2
+      worker:
+        - node-b-1
+warm:
+  - node-a-3
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(testResources, allocations)).Build()
+			hwmgr = newTestService(c)
+
+			Expect(hwmgr.GetIdleNodes(ctx)).To(Equal([]string{"node-a-1", "node-b-0"}))
+		})
+
+		It("reports all nodes as idle when nothing is allocated", func() {
+			Expect(hwmgr.GetIdleNodes(ctx)).To(Equal([]string{
+				"node-a-0", "node-a-1", "node-a-2", "node-a-3", "node-b-0", "node-b-1"}))
+		})
+	})
+
+	Context("when node sort keys are configured", func() {
+		const resources = `
+hwprofiles:
+  - profile-c
+nodes:
+  node-c-0:
+    hwprofile: profile-c
+    rack: rack-2
+  node-c-1:
+    hwprofile: profile-c
+  node-c-2:
+    hwprofile: profile-c
+    rack: rack-1
+  node-c-3:
+    hwprofile: profile-c
+    rack: rack-1
+`
+
+		// plan reports the nodes picked for four single-node nodegroups, which are planned in order
+		plan := func() (nodenames []string) {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "group-0", HwProfile: "profile-c", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "group-1", HwProfile: "profile-c", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "group-2", HwProfile: "profile-c", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "group-3", HwProfile: "profile-c", Size: 1})
+			picks, err := hwmgr.PlanAllocation(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			for _, pick := range picks {
+				nodenames = append(nodenames, pick.NodeName)
+			}
+			return
+		}
+
+		BeforeEach(func() {
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+		})
+
+		It("orders the candidates by name by default", func() {
+			Expect(plan()).To(Equal([]string{"node-c-0", "node-c-1", "node-c-2", "node-c-3"}))
+		})
+
+		It("breaks ties by the configured keys, then by name", func() {
+			hwmgr.nodeSortKeys = []string{NodeSortKeyRack}
+			Expect(plan()).To(Equal([]string{"node-c-2", "node-c-3", "node-c-0", "node-c-1"}))
+		})
+
+		It("parses the node sort keys", func() {
+			Expect(ParseNodeSortKeys("rack, name")).To(Equal([]string{NodeSortKeyRack, NodeSortKeyName}))
+			_, err := ParseNodeSortKeys("rack,location")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when adjacent nodes are preferred", func() {
+		It("scales up with the nodes closest to those already allocated", func() {
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-3
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(testResources, allocations)).Build()
+			hwmgr = newTestService(c)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			Expect(hwmgr.PlanAllocation(ctx, nodepool)).To(Equal(
+				[]AllocationPick{{NodeGroup: "master", NodeName: "node-a-0"}}))
+
+			hwmgr.preferAdjacent = true
+			Expect(hwmgr.PlanAllocation(ctx, nodepool)).To(Equal(
+				[]AllocationPick{{NodeGroup: "master", NodeName: "node-a-2"}}))
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			nodepool.Spec.NodeGroup[0].Size = 3
+			Expect(hwmgr.PlanAllocation(ctx, nodepool)).To(Equal(
+				[]AllocationPick{{NodeGroup: "master", NodeName: "node-a-1"}}))
+		})
+
+		It("leaves the order unchanged for a NodePool without allocated nodes", func() {
+			hwmgr.preferAdjacent = true
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.PlanAllocation(ctx, nodepool)).To(Equal(
+				[]AllocationPick{{NodeGroup: "master", NodeName: "node-a-0"}}))
+		})
+	})
+
+	Context("when powered-on nodes are preferred", func() {
+		const resources = `
+hwprofiles:
+  - profile-c
+nodes:
+  node-c-0:
+    hwprofile: profile-c
+  node-c-1:
+    hwprofile: profile-c
+    poweredOn: true
+`
+
+		BeforeEach(func() {
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+		})
+
+		It("selects a powered-on node over a powered-off one of the same profile", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-c", Size: 1})
+			Expect(hwmgr.PlanAllocation(ctx, nodepool)).To(Equal(
+				[]AllocationPick{{NodeGroup: "master", NodeName: "node-c-0"}}))
+
+			hwmgr.preferPoweredOn = true
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-c-1"}))
+
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-1", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.SelectionStrategyAnnotation, SelectionStrategyPoweredOn))
+		})
+	})
+
+	Context("when a release cooldown is configured", func() {
+		It("does not reselect a released node until the cooldown elapses", func() {
+			fakeClock := clocktesting.NewFakeClock(time.Now())
+			hwmgr.clock = fakeClock
+			hwmgr.releaseCooldown = time.Minute
+
+			np1 := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np1)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, np1)).To(Equal([]string{"node-b-0"}))
+			Expect(hwmgr.ReleaseNodePool(ctx, np1)).To(Succeed())
+
+			// The released node would otherwise be chosen
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np2)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, np2)).To(Equal([]string{"node-b-1"}))
+
+			np3 := newNodePool("np3", "cloud-3",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 1})
+			fakeClock.Step(30 * time.Second)
+			Expect(hwmgr.ProcessNewNodePool(ctx, np3)).To(MatchError(ContainSubstring(
+				"wait for 1 released node(s) to complete the 1m0s release cooldown")))
+
+			fakeClock.Step(30 * time.Second)
+			Expect(hwmgr.ProcessNewNodePool(ctx, np3)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, np3)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, np3)).To(Equal([]string{"node-b-0"}))
+			Expect(getAllocations(ctx, c).Released).To(BeEmpty())
+		})
+	})
+
+	Context("when the inventory cache is enabled", func() {
+		var (
+			reads     int
+			fakeClock *clocktesting.FakeClock
+		)
+
+		BeforeEach(func() {
+			reads = 0
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+						if _, ok := obj.(*corev1.ConfigMap); ok && key.Name == defaultCMName {
+							reads++
+						}
+						return c.Get(ctx, key, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+			fakeClock = clocktesting.NewFakeClock(time.Now())
+			hwmgr.clock = fakeClock
+			hwmgr.inventoryCacheTTL = time.Minute
+		})
+
+		It("serves repeated queries from the cache within the TTL", func() {
+			Expect(hwmgr.GetFreeCapacity(ctx)).To(Equal(map[string]int{"profile-a": 4, "profile-b": 2}))
+			Expect(hwmgr.GetFreeCapacity(ctx)).To(Equal(map[string]int{"profile-a": 4, "profile-b": 2}))
+			Expect(hwmgr.GetAllAllocations(ctx)).To(BeEmpty())
+			Expect(reads).To(Equal(1))
+
+			fakeClock.Step(time.Minute)
+			Expect(hwmgr.GetFreeCapacity(ctx)).To(Equal(map[string]int{"profile-a": 4, "profile-b": 2}))
+			Expect(reads).To(Equal(2))
+		})
+
+		It("reads afresh for updates and invalidates the cache on update", func() {
+			Expect(hwmgr.GetFreeCapacity(ctx)).To(Equal(map[string]int{"profile-a": 4, "profile-b": 2}))
+			Expect(reads).To(Equal(1))
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(reads).To(Equal(2))
+
+			Expect(hwmgr.GetFreeCapacity(ctx)).To(Equal(map[string]int{"profile-a": 3, "profile-b": 2}))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0"}))
+			Expect(reads).To(Equal(3))
+		})
+	})
+
+	Context("when querying the free capacity", func() {
+		It("counts the nodes available for allocation in each hardware profile", func() {
+			fakeClock := clocktesting.NewFakeClock(time.Now())
+			hwmgr.clock = fakeClock
+			hwmgr.releaseCooldown = time.Minute
+
+			free, err := hwmgr.GetFreeCapacity(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(free).To(Equal(map[string]int{"profile-a": 4, "profile-b": 2}))
+
+			np1 := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np1)).To(Succeed())
+			Expect(hwmgr.ReleaseNodePool(ctx, np1)).To(Succeed())
+
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np2)).To(Succeed())
+
+			// The released node is not available until its cooldown elapses
+			free, err = hwmgr.GetFreeCapacity(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(free).To(Equal(map[string]int{"profile-a": 3, "profile-b": 1}))
+
+			fakeClock.Step(time.Minute)
+			free, err = hwmgr.GetFreeCapacity(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(free).To(Equal(map[string]int{"profile-a": 3, "profile-b": 2}))
+		})
+	})
+
+	Context("when auditing the selection of nodes", func() {
+		It("annotates the created Node CRs with the reasons for which the nodes were selected", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1})
+			nodepool.Annotations = map[string]string{
+				utils.SerialsAnnotationPrefix + "master":      "SN-A3",
+				utils.ExcludeNodesAnnotationPrefix + "master": "node-a-0",
+			}
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-3", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.SelectionStrategyAnnotation, SelectionStrategySorted))
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.SelectionProfileAnnotation, "profile-a"))
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.SelectionCriteriaAnnotation, "excluded,serial"))
+
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-b-0", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.SelectionStrategyAnnotation, SelectionStrategySorted))
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.SelectionProfileAnnotation, "profile-b"))
+			Expect(node.Annotations).ToNot(HaveKey(utils.SelectionCriteriaAnnotation))
+		})
+
+		It("records the strategy of a node drawn from the warm pool", func() {
+			Expect(hwmgr.ReplenishWarmPool(ctx, map[string]int{"profile-a": 1})).To(Succeed())
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.SelectionStrategyAnnotation, SelectionStrategyWarm))
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.SelectionProfileAnnotation, "profile-a"))
+		})
+	})
+
+	Context("when dry-running an allocation", func() {
+		// noWrites verifies that nothing was written by the dry run
+		noWrites := func(resourceVersion string) {
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: defaultCMName, Namespace: testNamespace}, cm)).To(Succeed())
+			Expect(cm.ResourceVersion).To(Equal(resourceVersion))
+
+			nodes := &hwmgmtv1alpha1.NodeList{}
+			Expect(c.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(BeEmpty())
+			secrets := &corev1.SecretList{}
+			Expect(c.List(ctx, secrets)).To(Succeed())
+			Expect(secrets.Items).To(BeEmpty())
+		}
+
+		getResourceVersion := func() string {
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: defaultCMName, Namespace: testNamespace}, cm)).To(Succeed())
+			return cm.ResourceVersion
+		}
+
+		It("reports a satisfiable NodePool without allocating it", func() {
+			resourceVersion := getResourceVersion()
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 3},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "storage", HwProfile: "profile-b", Size: 2})
+
+			ok, shortfall, err := hwmgr.DryRunAllocate(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(shortfall).To(BeEmpty())
+			noWrites(resourceVersion)
+		})
+
+		It("reports the shortfall of each nodegroup of an unsatisfiable NodePool", func() {
+			resourceVersion := getResourceVersion()
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 3},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-a", Size: 3},
+				hwmgmtv1alpha1.NodeGroup{Name: "storage", HwProfile: "profile-b", Size: 2},
+				hwmgmtv1alpha1.NodeGroup{Name: "gpu", HwProfile: "profile-z", Size: 1})
+
+			// The nodes of profile-a taken by the master nodegroup are not counted again for the worker nodegroup
+			ok, shortfall, err := hwmgr.DryRunAllocate(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+			Expect(shortfall).To(Equal(map[string]int{"worker": 2, "gpu": 1}))
+			noWrites(resourceVersion)
+
+			_, _, err = hwmgr.DryRunAllocate(ctx, newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", Size: 1}))
+			Expect(err).To(MatchError(ContainSubstring("does not specify a hardware profile")))
+		})
+	})
+
+	Context("when a credential verifier is configured", func() {
+		It("flags the allocated nodes whose credentials are rejected", func() {
+			verifier := &fakeCredentialVerifier{invalid: map[string]bool{}}
+			hwmgr.credentialVerifier = verifier
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+
+			credentialsCondition := func() *metav1.Condition {
+				node := &hwmgmtv1alpha1.Node{}
+				Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, node)).To(Succeed())
+				return meta.FindStatusCondition(node.Status.Conditions, string(utils.CredentialsValid))
+			}
+
+			Expect(hwmgr.CheckCredentials(ctx)).To(BeEmpty())
+			Expect(credentialsCondition().Status).To(Equal(metav1.ConditionTrue))
+
+			verifier.invalid["node-a-0"] = true
+			Expect(hwmgr.CheckCredentials(ctx)).To(Equal([]string{"node-a-0"}))
+			condition := credentialsCondition()
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(string(utils.InvalidCredentials)))
+		})
+
+		It("checks the credentials periodically once started", func() {
+			verifier := &fakeCredentialVerifier{invalid: map[string]bool{"node-a-0": true}}
+			hwmgr.credentialVerifier = verifier
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+
+			checkCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			manager := &CredentialCheckManager{HwMgr: hwmgr, Interval: time.Hour}
+			go func() {
+				defer GinkgoRecover()
+				Expect(manager.Start(checkCtx)).To(Succeed())
+			}()
+
+			Eventually(func() string {
+				node := &hwmgmtv1alpha1.Node{}
+				Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, node)).To(Succeed())
+				if condition := meta.FindStatusCondition(node.Status.Conditions, string(utils.CredentialsValid)); condition != nil {
+					return condition.Reason
+				}
+				return ""
+			}).Should(Equal(string(utils.InvalidCredentials)))
+		})
+	})
+
+	Context("when a custom allocator is configured", func() {
+		var allocator *lastNodeAllocator
+
+		BeforeEach(func() {
+			allocator = &lastNodeAllocator{}
+
+			var err error
+			hwmgr, err = NewHwMgrService().
+				SetClient(c).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetAllocator(allocator).
+				SetAllocationDelay(0).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("allocates the nodes selected by the allocator", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-2", "node-a-3", "node-b-1"}))
+		})
+
+		It("rejects a node that is not free", func() {
+			allocator.selected = "node-b-0"
+
+			nodepool := newNodePool("np1", "cloud-1", hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(MatchError(
+				`allocator selected node "node-b-0", which is not a free node in hardware profile profile-a`))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+		})
+	})
+
+	Context("when a release verifier is configured", func() {
+		It("does not free the nodes until they are all verified to be powered off", func() {
+			verifier := &fakeReleaseVerifier{poweredOff: map[string]bool{}}
+			hwmgr.releaseVerifier = verifier
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			var pending *ReleasePendingError
+			err := hwmgr.ReleaseNodePool(ctx, nodepool)
+			Expect(errors.As(err, &pending)).To(BeTrue())
+			Expect(pending.Nodes).To(Equal([]string{"node-a-0", "node-a-1"}))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0", "node-a-1"}))
+
+			verifier.poweredOff["node-a-0"] = true
+			err = hwmgr.ReleaseNodePool(ctx, nodepool)
+			Expect(errors.As(err, &pending)).To(BeTrue())
+			Expect(pending.Nodes).To(Equal([]string{"node-a-1"}))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0", "node-a-1"}))
+			Expect(hwmgr.GetNodePoolNodes(ctx, nodepool)).To(HaveLen(2))
+
+			verifier.poweredOff["node-a-1"] = true
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+			Expect(hwmgr.GetNodePoolNodes(ctx, nodepool)).To(BeEmpty())
+		})
+	})
+
+	Context("when the number of concurrent provisions is limited", func() {
+		It("provisions no more nodes at a time than the limit", func() {
+			var (
+				lock     sync.Mutex
+				tracking bool
+				inflight int
+				peak     int
+			)
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+						lock.Lock()
+						track := tracking
+						if track {
+							inflight++
+							peak = max(peak, inflight)
+						}
+						lock.Unlock()
+
+						if track {
+							time.Sleep(20 * time.Millisecond)
+							defer func() {
+								lock.Lock()
+								inflight--
+								lock.Unlock()
+							}()
+						}
+						return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+					},
+				})
+
+			var err error
+			hwmgr, err = NewHwMgrService().
+				SetClient(c).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetMaxConcurrentProvisions(2).
+				SetAllocationDelay(0).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			var nodepools []*hwmgmtv1alpha1.NodePool
+			for _, cloudID := range []string{"cloud-1", "cloud-2", "cloud-3", "cloud-4"} {
+				nodepool := newNodePool(cloudID, cloudID,
+					hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+				Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+				nodepools = append(nodepools, nodepool)
+			}
+
+			lock.Lock()
+			tracking = true
+			lock.Unlock()
+
+			var wg sync.WaitGroup
+			for _, nodepool := range nodepools {
+				wg.Add(1)
+				go func(nodepool *hwmgmtv1alpha1.NodePool) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, err := hwmgr.ProvisionAllocatedNodes(ctx, nodepool)
+					Expect(err).ToNot(HaveOccurred())
+				}(nodepool)
+			}
+			wg.Wait()
+
+			Expect(peak).To(BeNumerically("<=", 2))
+			for _, nodepool := range nodepools {
+				Expect(hwmgr.IsNodePoolProvisioned(ctx, nodepool)).To(BeTrue())
+			}
+		})
+
+		It("rejects a negative limit", func() {
+			_, err := NewHwMgrService().
+				SetClient(c).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetMaxConcurrentProvisions(-1).
+				Build(ctx)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when reading the inventory to admit a NodePool", func() {
+		var (
+			reads   int
+			readErr error
+		)
+
+		BeforeEach(func() {
+			reads = 0
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+						if _, ok := obj.(*corev1.ConfigMap); ok {
+							reads++
+							if reads == 1 {
+								return readErr
+							}
+						}
+						return c.Get(ctx, key, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+			hwmgr.inventoryBackoff = wait.Backoff{Steps: 3, Duration: time.Millisecond}
+		})
+
+		It("retries a transient error", func() {
+			readErr = apierrors.NewServiceUnavailable("etcd leader changed")
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(Succeed())
+			Expect(reads).To(Equal(2))
+		})
+
+		It("does not retry a permanent error", func() {
+			readErr = apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, defaultCMName, errors.New("denied"))
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(MatchError(ContainSubstring("denied")))
+			Expect(reads).To(Equal(1))
+		})
+	})
+
+	Context("when there are not enough free nodes", func() {
+		It("suggests how to resolve the shortfall", func() {
+			np0 := newNodePool("np0", "cloud-0",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np0)).To(Succeed())
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-a", Size: 4})
+
+			err := hwmgr.ProcessNewNodePool(ctx, nodepool)
+			Expect(err).To(MatchError(
+				"not enough free resources in group profile-a: freenodes=3, needed=4: " +
+					"add 1 node(s) to hardware profile profile-a, or reduce the size of nodegroup worker to 3"))
+			var insufficient *InsufficientResourcesError
+			Expect(errors.As(err, &insufficient)).To(BeTrue())
+		})
+
+		It("distinguishes a request exceeding the capacity of the profile", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 5})
+
+			err := hwmgr.ProcessNewNodePool(ctx, nodepool)
+			Expect(err).To(MatchError(
+				"nodegroup worker requests 5 node(s), but hardware profile profile-b has only 2 node(s) in the inventory"))
+			var exceeds *ExceedsCapacityError
+			Expect(errors.As(err, &exceeds)).To(BeTrue())
+		})
+
+		It("distinguishes a request for an unknown hardware profile", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-bogus", Size: 1})
+
+			err := hwmgr.ProcessNewNodePool(ctx, nodepool)
+			Expect(err).To(MatchError(`nodegroup worker requests unknown hardware profile "profile-bogus"`))
+			var unknown *UnknownProfileError
+			Expect(errors.As(err, &unknown)).To(BeTrue())
+		})
+
+		It("accounts for excluded nodes", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-a", Size: 3})
+			nodepool.Annotations = map[string]string{
+				utils.ExcludeNodesAnnotationPrefix + "worker": "node-a-0,node-a-1,node-a-2",
+			}
+
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(MatchError(
+				"not enough free resources in group profile-a: freenodes=1, needed=3: " +
+					"add 2 node(s) to hardware profile profile-a, " +
+					"or allow 2 of the 3 free node(s) excluded from nodegroup worker, " +
+					"or reduce the size of nodegroup worker to 1"))
+		})
+	})
+
+	Context("when a nodegroup requests a number of accelerators", func() {
+		It("allocates enough nodes to reach the accelerator target", func() {
+			resources := `
+hwprofiles:
+  - profile-gpu
+nodes:
+  gpu-0:
+    hwprofile: profile-gpu
+    bmc:
+      address: "redfish+https://192.168.2.0/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+  gpu-1:
+    hwprofile: profile-gpu
+    accelerators: 4
+    bmc:
+      address: "redfish+https://192.168.2.1/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+  gpu-2:
+    hwprofile: profile-gpu
+    accelerators: 4
+    bmc:
+      address: "redfish+https://192.168.2.2/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+  gpu-3:
+    hwprofile: profile-gpu
+    accelerators: 4
+    bmc:
+      address: "redfish+https://192.168.2.3/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-gpu", Size: 1})
+			nodepool.Annotations = map[string]string{utils.AcceleratorsAnnotationPrefix + "worker": "8"}
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(Succeed())
+
+			for i := 0; i < 3; i++ {
+				Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			}
+
+			// The node without accelerators is passed over
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"gpu-1", "gpu-2"}))
+			Expect(hwmgr.IsNodeFullyAllocated(ctx, nodepool)).To(BeTrue())
+		})
+	})
+
+	Context("when a nodegroup is scaled down", func() {
+		It("releases the most recently allocated surplus nodes", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 3})
+			for i := 0; i < 3; i++ {
+				Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			}
+			Expect(hwmgr.GetNodePoolNodes(ctx, nodepool)).To(Equal([]string{"node-a-0", "node-a-1", "node-a-2"}))
+
+			nodepool.Spec.NodeGroup[0].Size = 1
+			Expect(hwmgr.CheckNodePoolProgress(ctx, nodepool)).To(BeTrue())
+
+			Expect(getAllocations(ctx, c).Clouds).To(Equal([]cmAllocatedCloud{
+				{CloudID: "cloud-1", Nodegroups: map[string][]string{"master": {"node-a-0"}}},
+			}))
+			Expect(hwmgr.GetNodePoolNodes(ctx, nodepool)).To(Equal([]string{"node-a-0"}))
+			for _, nodename := range []string{"node-a-1", "node-a-2"} {
+				err := c.Get(ctx, types.NamespacedName{Name: nodename + "-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-0-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})).To(Succeed())
+
+			// Nothing more is released once the nodegroup is at its size
+			Expect(hwmgr.ReconcileNodePoolSize(ctx, nodepool)).To(BeEmpty())
+		})
+
+		It("leaves a surplus node allocated until its Node CR is gone", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 3})
+			for i := 0; i < 3; i++ {
+				Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			}
+
+			node := &hwmgmtv1alpha1.Node{}
+			key := types.NamespacedName{Name: "node-a-2", Namespace: testNamespace}
+			Expect(c.Get(ctx, key, node)).To(Succeed())
+			node.Finalizers = append(node.Finalizers, "example.com/slow-delete")
+			Expect(c.Update(ctx, node)).To(Succeed())
+
+			// The node whose Node CR is gone is freed, while the held one stays allocated
+			nodepool.Spec.NodeGroup[0].Size = 1
+			_, err := hwmgr.ReconcileNodePoolSize(ctx, nodepool)
+			var pending *ReleasePendingError
+			Expect(errors.As(err, &pending)).To(BeTrue())
+			Expect(pending.Nodes).To(Equal([]string{"node-a-2"}))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0", "node-a-2"}))
+
+			// The node cannot be reallocated to another pool in the meantime
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			Expect(hwmgr.PlanAllocation(ctx, np2)).To(Equal([]AllocationPick{{NodeGroup: "master", NodeName: "node-a-1"}}))
+
+			Expect(c.Get(ctx, key, node)).To(Succeed())
+			node.Finalizers = nil
+			Expect(c.Update(ctx, node)).To(Succeed())
+			Expect(hwmgr.ReconcileNodePoolSize(ctx, nodepool)).To(Equal([]string{"node-a-2"}))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0"}))
+		})
+	})
+
+	Context("when racks have power caps", func() {
+		const resources = `
+hwprofiles:
+  - profile-p
+rackPowerCaps:
+  rack-1: 1000
+nodes:
+  node-p-0:
+    hwprofile: profile-p
+    rack: rack-1
+    powerDraw: 400
+  node-p-1:
+    hwprofile: profile-p
+    rack: rack-1
+    powerDraw: 800
+  node-p-2:
+    hwprofile: profile-p
+    rack: rack-2
+    powerDraw: 800
+`
+
+		It("does not allocate a free node that would exceed the power cap of its rack", func() {
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+
+			np1 := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-p", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, np1)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, np1)).To(Equal([]string{"node-p-0"}))
+
+			// The high-draw node in the capped rack is skipped, although it is free
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-p", Size: 1})
+			Expect(hwmgr.ProcessNewNodePool(ctx, np2)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, np2)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, np2)).To(Equal([]string{"node-p-2"}))
+
+			np3 := newNodePool("np3", "cloud-3",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-p", Size: 1})
+			err := hwmgr.ProcessNewNodePool(ctx, np3)
+			var insufficient *InsufficientResourcesError
+			Expect(errors.As(err, &insufficient)).To(BeTrue())
+			Expect(insufficient.FreeNodes).To(BeZero())
+			Expect(err).To(MatchError(ContainSubstring("raise the power caps of the racks of 1 free node(s)")))
+
+			// A node can be allocated again once the power in the rack is freed
+			Expect(hwmgr.ReleaseNodePool(ctx, np1)).To(Succeed())
+			Expect(hwmgr.ProcessNewNodePool(ctx, np3)).To(Succeed())
+		})
+
+		It("admits only as many nodes as fit within the power cap together", func() {
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-p", Size: 3})
+			err := hwmgr.ProcessNewNodePool(ctx, nodepool)
+			var insufficient *InsufficientResourcesError
+			Expect(errors.As(err, &insufficient)).To(BeTrue())
+			Expect(insufficient.FreeNodes).To(Equal(2))
+		})
+	})
+
+	Context("when a NodePool limits its nodes per rack", func() {
+		const resources = `
+hwprofiles:
+  - profile-r
+nodes:
+  node-r-0:
+    hwprofile: profile-r
+    rack: rack-1
+  node-r-1:
+    hwprofile: profile-r
+    rack: rack-1
+  node-r-2:
+    hwprofile: profile-r
+    rack: rack-2
+`
+
+		newRackLimitedNodePool := func() *hwmgmtv1alpha1.NodePool {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-r", Size: 2})
+			nodepool.Annotations = map[string]string{utils.MaxPerRackAnnotation: "1"}
+			return nodepool
+		}
+
+		It("spreads the nodes across racks", func() {
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+
+			nodepool := newRackLimitedNodePool()
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-r-0", "node-r-2"}))
+		})
+
+		It("fails the allocation if there are too few racks", func() {
+			c = newFakeClientBuilder(newNodelistConfigMap(strings.ReplaceAll(resources, "rack-2", "rack-1"), "")).Build()
+			hwmgr = newTestService(c)
+
+			nodepool := newRackLimitedNodePool()
+			err := hwmgr.ProcessNewNodePool(ctx, nodepool)
+			var insufficient *InsufficientResourcesError
+			Expect(errors.As(err, &insufficient)).To(BeTrue())
+			Expect(insufficient.FreeNodes).To(Equal(1))
+			Expect(err).To(MatchError(ContainSubstring("raise the limit of 1 node(s) per rack")))
+
+			// Once a node is allocated from the only rack, no further node can be allocated
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			err = hwmgr.AllocateNode(ctx, nodepool)
+			Expect(errors.As(err, &insufficient)).To(BeTrue())
+			Expect(err).To(MatchError(
+				"not enough free resources in group profile-r: freenodes=0, needed=1: " +
+					"add 1 node(s) to hardware profile profile-r, or raise the limit of 1 node(s) per rack, " +
+					"or reduce the size of nodegroup worker to 1"))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-r-0"}))
+		})
+
+		It("does not admit an invalid limit", func() {
+			nodepool := newRackLimitedNodePool()
+			nodepool.Annotations[utils.MaxPerRackAnnotation] = "-1"
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(MatchError(ContainSubstring("must not be negative")))
+		})
+	})
+
+	Context("when a NodePool requires firmware compliance", func() {
+		BeforeEach(func() {
+			resources := `
+hwprofiles:
+  - profile-fw
+nodes:
+  fw-0:
+    hwprofile: profile-fw
+    bmc:
+      address: "redfish+https://192.168.4.0/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+  fw-1:
+    hwprofile: profile-fw
+    firmwareCompliant: true
+    bmc:
+      address: "redfish+https://192.168.4.1/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+		})
+
+		It("excludes non-compliant nodes from a compliance-requiring pool", func() {
+			production := newNodePool("production", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-fw", Size: 1})
+			production.Annotations = map[string]string{utils.RequireFirmwareComplianceAnnotation: "true"}
+			Expect(hwmgr.AllocateNode(ctx, production)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, production)).To(Equal([]string{"fw-1"}))
+
+			// Only the non-compliant node remains, so another production pool cannot be allocated
+			other := newNodePool("other", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-fw", Size: 1})
+			other.Annotations = map[string]string{utils.RequireFirmwareComplianceAnnotation: "true"}
+			var insufficient *InsufficientResourcesError
+			Expect(errors.As(hwmgr.AllocateNode(ctx, other), &insufficient)).To(BeTrue())
+		})
+
+		It("allows non-compliant nodes for a lenient pool", func() {
+			lab := newNodePool("lab", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-fw", Size: 2})
+			Expect(hwmgr.AllocateNode(ctx, lab)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, lab)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, lab)).To(Equal([]string{"fw-0", "fw-1"}))
+		})
+	})
+
+	Context("when a NodePool requires BMCs", func() {
+		BeforeEach(func() {
+			resources := `
+hwprofiles:
+  - profile-bmc
+nodes:
+  bmc-0:
+    hwprofile: profile-bmc
+  bmc-1:
+    hwprofile: profile-bmc
+    bmc:
+      address: "redfish+https://192.168.5.1/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+		})
+
+		It("skips the nodes without a BMC", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-bmc", Size: 1})
+			nodepool.Annotations = map[string]string{utils.RequireBMCAnnotation: "true"}
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"bmc-1"}))
+
+			// Only the node without a BMC remains, so another BMC-requiring pool is rejected
+			other := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-bmc", Size: 1})
+			other.Annotations = map[string]string{utils.RequireBMCAnnotation: "true"}
+			var insufficient *InsufficientResourcesError
+			Expect(errors.As(hwmgr.ProcessNewNodePool(ctx, other), &insufficient)).To(BeTrue())
+
+			other.Annotations = nil
+			Expect(hwmgr.ProcessNewNodePool(ctx, other)).To(Succeed())
+		})
+	})
+
+	Context("when a hardware profile is cordoned", func() {
+		BeforeEach(func() {
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: defaultCMName, Namespace: testNamespace}, cm)).To(Succeed())
+			cm.Data[defaultResourcesKey] = testResources + "cordonedProfiles:\n  - profile-b\n"
+			Expect(c.Update(ctx, cm)).To(Succeed())
+		})
+
+		It("rejects a new NodePool requesting the profile", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1})
+
+			err := hwmgr.ProcessNewNodePool(ctx, nodepool)
+			var cordoned *ProfileCordonedError
+			Expect(errors.As(err, &cordoned)).To(BeTrue())
+			Expect(err).To(MatchError("hardware profile profile-b requested by nodegroup worker is cordoned"))
+		})
+
+		It("admits a NodePool requesting other profiles", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(Succeed())
+		})
+	})
+
+	Context("when an update would exceed the configmap size limit", func() {
+		It("fails with a clear error and leaves the configmap unchanged", func() {
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: defaultCMName, Namespace: testNamespace}, cm)).To(Succeed())
+			hwmgr.maxConfigMapSize = configMapDataSize(cm) + 10
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			err := hwmgr.AllocateNode(ctx, nodepool)
+			Expect(err).To(MatchError(ContainSubstring("would exceed the limit")))
+			Expect(err).To(MatchError(ContainSubstring("sharding")))
+
+			Expect(getAllocations(ctx, c).Clouds).To(BeEmpty())
+		})
+	})
+
+	Context("when an update would over-subscribe a node", func() {
+		It("rejects the write and leaves the configmap unchanged", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			cm, _, allocations, err := hwmgr.GetCurrentResources(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			allocations.Clouds = append(allocations.Clouds, cmAllocatedCloud{
+				CloudID:    "cloud-2",
+				Nodegroups: map[string][]string{"master": {"node-a-0"}},
+			})
+
+			err = hwmgr.updateAllocations(ctx, cm, allocations)
+			Expect(err).To(MatchError(ContainSubstring(
+				"node node-a-0 is over-subscribed: allocated to both cloud-1/master and cloud-2/master")))
+
+			Expect(getAllocations(ctx, c).Clouds).To(HaveLen(1))
+		})
+	})
+
+	Context("when the configmap assigns a node to more than one cloud", func() {
+		It("refuses further allocations until a release repairs them", func() {
+			allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+  - cloudID: cloud-2
+    nodegroups:
+      worker:
+        - node-a-1
+        - node-a-0
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(testResources, allocations)).Build()
+			hwmgr = newTestService(c)
+
+			// The allocations can still be read, so that the nodes can be released
+			_, _, _, err := hwmgr.GetCurrentResources(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			// Nothing further is allocated from the inconsistent state
+			nodepool := newNodePool("np3", "cloud-3",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(MatchError(ContainSubstring(
+				"node node-a-0 is over-subscribed: allocated to both cloud-1/master and cloud-2/worker")))
+
+			// Releasing one of the clouds double-booking the node repairs the allocations
+			Expect(hwmgr.ReleaseNodePool(ctx, newNodePool("np2", "cloud-2"))).To(Succeed())
+			Expect(getAllocations(ctx, c).Clouds).To(HaveLen(1))
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+		})
+	})
+
+	Context("when nodes are allocated and released", func() {
+		It("maintains cumulative counters per hardware profile", func() {
+			counters := func() map[string]string {
+				cm := &corev1.ConfigMap{}
+				Expect(c.Get(ctx, types.NamespacedName{Name: defaultCMName, Namespace: testNamespace}, cm)).To(Succeed())
+				return cm.Annotations
+			}
+
+			np1 := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1})
+			np2 := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+
+			Expect(hwmgr.AllocateNode(ctx, np1)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, np1)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, np2)).To(Succeed())
+			Expect(counters()).To(Equal(map[string]string{
+				utils.AllocatedCountAnnotationPrefix + "profile-a": "3",
+				utils.AllocatedCountAnnotationPrefix + "profile-b": "1",
+			}))
+
+			Expect(hwmgr.SwapNode(ctx, np2, "master", "node-a-2", "node-a-3")).To(Succeed())
+			Expect(hwmgr.ReleaseNodePool(ctx, np1)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, np1)).To(Succeed())
+			Expect(counters()).To(Equal(map[string]string{
+				utils.AllocatedCountAnnotationPrefix + "profile-a": "5",
+				utils.AllocatedCountAnnotationPrefix + "profile-b": "2",
+				utils.ReleasedCountAnnotationPrefix + "profile-a":  "3",
+				utils.ReleasedCountAnnotationPrefix + "profile-b":  "1",
+			}))
+		})
+	})
+
+	Context("when the allocations are already in the desired state", func() {
+		It("does not update the configmap", func() {
+			var updates int
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+						if _, ok := obj.(*corev1.ConfigMap); ok {
+							updates++
+						}
+						return c.Update(ctx, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(updates).To(Equal(1))
+
+			// The nodegroup is fully allocated
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(updates).To(Equal(1))
+
+			// Rewriting the same allocations
+			cm, _, allocations, err := hwmgr.GetCurrentResources(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hwmgr.updateAllocations(ctx, cm, allocations)).To(Succeed())
+			Expect(updates).To(Equal(1))
+
+			// Releasing a NodePool with no allocations
+			Expect(hwmgr.ReleaseNodePool(ctx, newNodePool("np2", "cloud-2"))).To(Succeed())
+			Expect(updates).To(Equal(1))
+
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			Expect(updates).To(Equal(2))
+		})
+	})
+
+	Context("when allocating nodes to several nodegroups", func() {
+		var (
+			updates   int
+			createErr error
+		)
+
+		BeforeEach(func() {
+			updates = 0
+			createErr = nil
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+						if _, ok := obj.(*hwmgmtv1alpha1.Node); ok && obj.GetName() == "node-b-0" && createErr != nil {
+							return createErr
+						}
+						return c.Create(ctx, obj, opts...)
+					},
+					Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+						if _, ok := obj.(*corev1.ConfigMap); ok {
+							updates++
+						}
+						return c.Update(ctx, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+		})
+
+		It("updates the configmap once", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(updates).To(Equal(1))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0", "node-b-0"}))
+			Expect(hwmgr.GetNodePoolNodes(ctx, nodepool)).To(HaveLen(2))
+		})
+
+		It("does not claim a node whose Node CR failed to be created", func() {
+			createErr = errors.New("create failed")
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(MatchError(ContainSubstring("create failed")))
+			Expect(updates).To(Equal(1))
+
+			// The node prepared before the failure is committed, while the failed one is cleaned up
+			Expect(getAllocations(ctx, c).Clouds).To(Equal([]cmAllocatedCloud{
+				{CloudID: "cloud-1", Nodegroups: map[string][]string{"master": {"node-a-0"}}},
+			}))
+			err := c.Get(ctx, types.NamespacedName{Name: "node-b-0-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			Expect(hwmgr.GetNodePoolNodes(ctx, nodepool)).To(Equal([]string{"node-a-0"}))
+
+			createErr = nil
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0", "node-b-0"}))
+		})
+	})
+
+	Context("when a released Node CR is held by another finalizer", func() {
+		It("leaves the node allocated until the Node CR is gone", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			node := &hwmgmtv1alpha1.Node{}
+			key := types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}
+			Expect(c.Get(ctx, key, node)).To(Succeed())
+			node.Finalizers = append(node.Finalizers, "example.com/slow-delete")
+			Expect(c.Update(ctx, node)).To(Succeed())
+
+			// The release must be repeated while the Node CR remains
+			var pending *ReleasePendingError
+			Expect(errors.As(hwmgr.ReleaseNodePool(ctx, nodepool), &pending)).To(BeTrue())
+			Expect(pending.Nodes).To(Equal([]string{"node-a-0"}))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0"}))
+			Expect(c.Get(ctx, key, node)).To(Succeed())
+			Expect(node.DeletionTimestamp).ToNot(BeNil())
+
+			node.Finalizers = nil
+			Expect(c.Update(ctx, node)).To(Succeed())
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+		})
+	})
+
+	Context("when nodegroups have teardown priorities", func() {
+		var (
+			deleted  []string
+			nodepool *hwmgmtv1alpha1.NodePool
+		)
+
+		BeforeEach(func() {
+			deleted = nil
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+						if _, ok := obj.(*hwmgmtv1alpha1.Node); ok {
+							deleted = append(deleted, obj.GetName())
+						}
+						return c.Delete(ctx, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+
+			nodepool = newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "storage", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1})
+			nodepool.Annotations = map[string]string{
+				utils.TeardownPriorityAnnotationPrefix + "storage": "10",
+				utils.TeardownPriorityAnnotationPrefix + "master":  "5",
+			}
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+		})
+
+		It("releases the nodegroups in priority order", func() {
+			nodegroups := getAllocations(ctx, c).Clouds[0].Nodegroups
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+
+			Expect(deleted).To(Equal([]string{nodegroups["worker"][0], nodegroups["master"][0], nodegroups["storage"][0]}))
+			Expect(getAllocations(ctx, c).Clouds).To(BeEmpty())
+		})
+
+		It("waits for the Node CRs of a nodegroup to be gone before tearing down the next", func() {
+			nodegroups := getAllocations(ctx, c).Clouds[0].Nodegroups
+			node := &hwmgmtv1alpha1.Node{}
+			key := types.NamespacedName{Name: nodegroups["master"][0], Namespace: testNamespace}
+			Expect(c.Get(ctx, key, node)).To(Succeed())
+			node.Finalizers = append(node.Finalizers, "example.com/slow-delete")
+			Expect(c.Update(ctx, node)).To(Succeed())
+
+			var pending *ReleasePendingError
+			Expect(errors.As(hwmgr.ReleaseNodePool(ctx, nodepool), &pending)).To(BeTrue())
+			Expect(pending.Nodes).To(Equal([]string{nodegroups["master"][0]}))
+			Expect(deleted).To(Equal([]string{nodegroups["worker"][0], nodegroups["master"][0]}))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(HaveLen(3))
+
+			Expect(c.Get(ctx, key, node)).To(Succeed())
+			node.Finalizers = nil
+			Expect(c.Update(ctx, node)).To(Succeed())
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			Expect(deleted).To(HaveLen(3))
+			Expect(deleted[2]).To(Equal(nodegroups["storage"][0]))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+		})
+
+		It("rejects an invalid teardown priority", func() {
+			nodepool := newNodePool("np2", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			nodepool.Annotations = map[string]string{utils.TeardownPriorityAnnotationPrefix + "master": "last"}
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(MatchError(ContainSubstring("invalid")))
+		})
+	})
+
+	Context("when a NodePool is deleted during allocation", func() {
+		var (
+			nodepool *hwmgmtv1alpha1.NodePool
+			deleteOn func(obj client.Object) bool
+		)
+
+		// noOrphans verifies that no node remains allocated once the NodePool is released
+		noOrphans := func() {
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			Expect(getAllocations(ctx, c).Clouds).To(BeEmpty())
+			nodes := &hwmgmtv1alpha1.NodeList{}
+			Expect(c.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(BeEmpty())
+			secrets := &corev1.SecretList{}
+			Expect(c.List(ctx, secrets)).To(Succeed())
+			Expect(secrets.Items).To(BeEmpty())
+		}
+
+		BeforeEach(func() {
+			nodepool = newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			nodepool.Finalizers = []string{"example.com/release"}
+
+			// The NodePool is deleted on the first access to an object matched by deleteOn
+			deleteOn = func(client.Object) bool { return false }
+			deleted := false
+			maybeDelete := func(ctx context.Context, c client.WithWatch, obj client.Object) {
+				if !deleted && deleteOn(obj) {
+					deleted = true
+					Expect(c.Delete(ctx, nodepool.DeepCopy())).To(Succeed())
+				}
+			}
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, ""), nodepool).Build(),
+				interceptor.Funcs{
+					Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+						maybeDelete(ctx, c, obj)
+						return c.Get(ctx, key, obj, opts...)
+					},
+					Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+						maybeDelete(ctx, c, obj)
+						return c.Create(ctx, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(nodepool), nodepool)).To(Succeed())
+		})
+
+		It("does not allocate nodes once the NodePool is marked for deletion", func() {
+			deleteOn = func(obj client.Object) bool {
+				_, ok := obj.(*corev1.ConfigMap)
+				return ok
+			}
+
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(getAllocations(ctx, c).Clouds).To(BeEmpty())
+			nodes := &hwmgmtv1alpha1.NodeList{}
+			Expect(c.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(BeEmpty())
+			noOrphans()
+		})
+
+		It("releases the nodes of an allocation committed while the NodePool is marked for deletion", func() {
+			deleteOn = func(obj client.Object) bool {
+				_, ok := obj.(*hwmgmtv1alpha1.Node)
+				return ok
+			}
+
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(HaveLen(1))
+			noOrphans()
+		})
+	})
+
+	Context("when a warm node cannot be claimed", func() {
+		BeforeEach(func() {
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+						if node, ok := obj.(*hwmgmtv1alpha1.Node); ok && node.Spec.NodePool != "" {
+							return errors.New("claim failed")
+						}
+						return c.Update(ctx, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+		})
+
+		It("rolls back the committed allocation of the node", func() {
+			Expect(hwmgr.ReplenishWarmPool(ctx, map[string]int{"profile-a": 1})).To(Succeed())
+			Expect(getAllocations(ctx, c).Warm).To(Equal([]string{"node-a-0"}))
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(MatchError(ContainSubstring("claim failed")))
+
+			allocations := getAllocations(ctx, c)
+			Expect(allocations.Clouds).To(BeEmpty())
+			Expect(allocations.Warm).To(BeEmpty())
+			err := c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, &hwmgmtv1alpha1.Node{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			err = c.Get(ctx, types.NamespacedName{Name: "node-a-0-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Context("when a custom configmap name and keys are set", func() {
+		It("reads and writes the allocations in that configmap", func() {
+			custom := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "lab-b-inventory", Namespace: testNamespace},
+				Data:       map[string]string{"nodes": testResources},
+			}
+			// The default configmap holds a separate inventory, which is left untouched
+			c = newFakeClientBuilder(custom, newNodelistConfigMap(testResources, "")).Build()
+			var err error
+			hwmgr, err = NewHwMgrService().
+				SetClient(c).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetConfigMapName("lab-b-inventory").
+				SetResourcesKey("nodes").
+				SetAllocationsKey("assigned").
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.ProcessNewNodePool(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0"}))
+
+			Expect(c.Get(ctx, types.NamespacedName{Name: "lab-b-inventory", Namespace: testNamespace}, custom)).To(Succeed())
+			Expect(custom.Data).To(HaveKeyWithValue("assigned", ContainSubstring("node-a-0")))
+			Expect(getAllocations(ctx, c).Clouds).To(BeEmpty())
+
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			Expect(c.Get(ctx, types.NamespacedName{Name: "lab-b-inventory", Namespace: testNamespace}, custom)).To(Succeed())
+			Expect(custom.Data["assigned"]).ToNot(ContainSubstring("node-a-0"))
+		})
+
+		It("rejects identical resources and allocations keys", func() {
+			_, err := NewHwMgrService().
+				SetClient(c).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetAllocationsKey(defaultResourcesKey).
+				Build(ctx)
+			Expect(err).To(MatchError(ContainSubstring("resources and allocations keys must differ")))
+		})
+	})
+
+	Context("when separate read and write clients are set", func() {
+		It("serves the read-only queries through the read client and the updates through the write client", func() {
+			type spy struct{ gets, updates int }
+			spyOn := func(base client.WithWatch, counts *spy) client.WithWatch {
+				return interceptor.NewClient(base, interceptor.Funcs{
+					Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object,
+						opts ...client.GetOption) error {
+						if _, ok := obj.(*corev1.ConfigMap); ok {
+							counts.gets++
+						}
+						return c.Get(ctx, key, obj, opts...)
+					},
+					Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+						if _, ok := obj.(*corev1.ConfigMap); ok {
+							counts.updates++
+						}
+						return c.Update(ctx, obj, opts...)
+					},
+				})
+			}
+
+			base := newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build()
+			var cached, direct spy
+			var err error
+			hwmgr, err = NewHwMgrService().
+				SetClient(spyOn(base, &direct)).
+				SetReadClient(spyOn(base, &cached)).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetAllocationDelay(0).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			nodepool := newNodePool("np1", "cloud-1", hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			// The read leading to the update must not come from the read client, which may be stale
+			Expect(cached).To(Equal(spy{}))
+			Expect(direct.gets).To(BeNumerically(">", 0))
+			Expect(direct.updates).To(Equal(1))
+
+			direct = spy{}
+			Expect(hwmgr.GetCapacity(ctx)).ToNot(BeEmpty())
+			Expect(cached).To(Equal(spy{gets: 1}))
+			Expect(direct).To(Equal(spy{}))
+			Expect(getAllocations(ctx, base).Clouds).To(Equal([]cmAllocatedCloud{
+				{CloudID: "cloud-1", Nodegroups: map[string][]string{"master": {"node-a-0"}}},
+			}))
+		})
+	})
+
+	Context("when the configmap is modified concurrently", func() {
+		var (
+			conflicts int
+			stolen    string
+		)
+
+		BeforeEach(func() {
+			conflicts = 0
+			stolen = "node-a-0"
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+						if _, ok := obj.(*corev1.ConfigMap); ok && conflicts > 0 {
+							conflicts--
+
+							// Allocate a node behind the plugin's back, leaving the update with a stale resourceVersion
+							current := &corev1.ConfigMap{}
+							Expect(c.Get(ctx, client.ObjectKeyFromObject(obj), current)).To(Succeed())
+							allocations := getAllocations(ctx, c)
+							allocations.Clouds = append(allocations.Clouds,
+								cmAllocatedCloud{CloudID: "cloud-0", Nodegroups: map[string][]string{"master": {stolen}}})
+							data, err := yaml.Marshal(&allocations)
+							Expect(err).ToNot(HaveOccurred())
+							current.Data[defaultAllocationsKey] = string(data)
+							Expect(c.Update(ctx, current)).To(Succeed())
+						}
+						return c.Update(ctx, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+		})
+
+		It("retries the allocation with the current allocations", func() {
+			conflicts = 1
+
+			nodepool := newNodePool("np1", "cloud-1", hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(getAllocations(ctx, c).Clouds).To(ConsistOf(
+				cmAllocatedCloud{CloudID: "cloud-0", Nodegroups: map[string][]string{"master": {"node-a-0"}}},
+				cmAllocatedCloud{CloudID: "cloud-1", Nodegroups: map[string][]string{"master": {"node-a-1"}}},
+			))
+
+			// The Node CR of the node picked by the conflicting attempt is cleaned up
+			Expect(hwmgr.GetNodePoolNodes(ctx, nodepool)).To(Equal([]string{"node-a-1"}))
+		})
+
+		It("returns the conflict once the retries are exhausted", func() {
+			conflicts = 2
+
+			var err error
+			hwmgr, err = NewHwMgrService().
+				SetClient(c).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetConflictRetries(1).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			nodepool := newNodePool("np1", "cloud-1", hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			err = hwmgr.AllocateNode(ctx, nodepool)
+			Expect(apierrors.IsConflict(err)).To(BeTrue())
+			Expect(conflicts).To(BeZero())
+		})
+
+		It("retries the release with the current allocations", func() {
+			nodepool := newNodePool("np1", "cloud-1", hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			conflicts = 1
+			stolen = "node-a-1"
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			Expect(getAllocations(ctx, c).Clouds).To(Equal([]cmAllocatedCloud{
+				{CloudID: "cloud-0", Nodegroups: map[string][]string{"master": {"node-a-1"}}},
+			}))
+			Expect(hwmgr.GetNodePoolNodes(ctx, nodepool)).To(BeEmpty())
+		})
+
+		It("retries the release of surplus nodes with the current allocations", func() {
+			nodepool := newNodePool("np1", "cloud-1", hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			conflicts = 1
+			stolen = "node-a-3"
+			nodepool.Spec.NodeGroup[0].Size = 1
+			Expect(hwmgr.ReconcileNodePoolSize(ctx, nodepool)).To(Equal([]string{"node-a-1"}))
+			Expect(conflicts).To(BeZero())
+			Expect(getAllocations(ctx, c).Clouds).To(ConsistOf(
+				cmAllocatedCloud{CloudID: "cloud-0", Nodegroups: map[string][]string{"master": {"node-a-3"}}},
+				cmAllocatedCloud{CloudID: "cloud-1", Nodegroups: map[string][]string{"master": {"node-a-0"}}},
+			))
+		})
+
+		It("retries a node swap with the current allocations", func() {
+			nodepool := newNodePool("np1", "cloud-1", hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			conflicts = 1
+			stolen = "node-a-3"
+			Expect(hwmgr.SwapNode(ctx, nodepool, "master", "node-a-0", "node-a-2")).To(Succeed())
+			Expect(conflicts).To(BeZero())
+			Expect(getAllocations(ctx, c).Clouds).To(ConsistOf(
+				cmAllocatedCloud{CloudID: "cloud-0", Nodegroups: map[string][]string{"master": {"node-a-3"}}},
+				cmAllocatedCloud{CloudID: "cloud-1", Nodegroups: map[string][]string{"master": {"node-a-2"}}},
+			))
+			Expect(hwmgr.GetNodePoolNodes(ctx, nodepool)).To(Equal([]string{"node-a-2"}))
+		})
+	})
+
+	Context("when swapping an allocated node", func() {
+		It("replaces the node without the nodegroup dipping below its size", func() {
+			var sizes []int
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+						if cm, ok := obj.(*corev1.ConfigMap); ok {
+							allocations, err := utils.ExtractDataFromConfigMap[cmAllocations](cm, defaultAllocationsKey)
+							Expect(err).ToNot(HaveOccurred())
+							for _, cloud := range allocations.Clouds {
+								sizes = append(sizes, len(cloud.Nodegroups["master"]))
+							}
+						}
+						return c.Update(ctx, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-0", "node-a-1"}))
+
+			sizes = nil
+			Expect(hwmgr.SwapNode(ctx, nodepool, "master", "node-a-0", "node-a-2")).To(Succeed())
+			Expect(sizes).To(Equal([]int{2}))
+
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-1", "node-a-2"}))
+
+			// The new node is left to be provisioned as any newly allocated node is
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-2", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Spec.NodePool).To(Equal("cloud-1"))
+			Expect(node.Spec.GroupName).To(Equal("master"))
+			Expect(meta.IsStatusConditionTrue(node.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))).To(BeFalse())
+			Expect(hwmgr.IsNodePoolProvisioned(ctx, nodepool)).To(BeFalse())
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+			Expect(hwmgr.IsNodePoolProvisioned(ctx, nodepool)).To(BeTrue())
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-a-2-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})).To(Succeed())
+
+			err := c.Get(ctx, types.NamespacedName{Name: "node-a-0", Namespace: testNamespace}, &hwmgmtv1alpha1.Node{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			err = c.Get(ctx, types.NamespacedName{Name: "node-a-0-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("rejects a new node that is already allocated", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-b", Size: 2})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			Expect(hwmgr.SwapNode(ctx, nodepool, "master", "node-b-0", "node-b-1")).To(MatchError(ContainSubstring("not free")))
+			Expect(hwmgr.SwapNode(ctx, nodepool, "master", "node-b-0", "node-a-0")).To(MatchError(ContainSubstring("hardware profile")))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-b-0", "node-b-1"}))
+		})
+
+		It("rejects a new node that the nodegroup would not be allocated", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			nodepool.Annotations = map[string]string{utils.ExcludeNodesAnnotationPrefix + "master": "node-a-1"}
+			Expect(hwmgr.SwapNode(ctx, nodepool, "master", "node-a-0", "node-a-1")).To(
+				MatchError(ContainSubstring("rejected by excluded filter")))
+			Expect(hwmgr.SwapNode(ctx, nodepool, "master", "node-a-0", "node-a-2")).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-a-2"}))
+		})
+	})
+
+	Context("when a node has plaintext BMC credentials", func() {
+		It("writes them to the bmc-secret unchanged", func() {
+			resources := `
+hwprofiles:
+  - profile-c
+nodes:
+  node-c-0:
+    hwprofile: profile-c
+    bmc:
+      address: "redfish+https://192.168.3.0/redfish/v1/Systems/1"
+      username: lab-admin
+      password: "not=base64!"
+  node-c-1:
+    hwprofile: profile-c
+    bmc:
+      address: "redfish+https://192.168.3.1/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+			hwmgr.validateInventory = true
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-c", Size: 2})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			secret := &corev1.Secret{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-0-bmc-secret", Namespace: testNamespace}, secret)).To(Succeed())
+			Expect(secret.Data).To(Equal(map[string][]byte{"username": []byte("lab-admin"), "password": []byte("not=base64!")}))
+
+			// Base64 encoded credentials remain the default
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-1-bmc-secret", Namespace: testNamespace}, secret)).To(Succeed())
+			Expect(secret.Data).To(Equal(map[string][]byte{"username": []byte("admin"), "password": []byte("mypass")}))
+		})
+	})
+
+	Context("when the base64 encoded BMC password is malformed", func() {
+		It("names the password field without its value", func() {
+			c = newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build()
+			hwmgr = newTestService(c)
+
+			err := hwmgr.CreateBMCSecret(ctx, "node-a-0", "YWRtaW4=", "s3cret!")
+			Expect(err).To(MatchError(ContainSubstring("failed to decode passwordBase64 for node node-a-0")))
+			Expect(err.Error()).ToNot(ContainSubstring("s3cret!"))
+		})
+	})
+
+	Context("when a node has labels in the inventory", func() {
+		It("sets them on the Node CR, which keeps them once provisioned", func() {
+			resources := `
+hwprofiles:
+  - profile-c
+nodes:
+  node-c-0:
+    hwprofile: profile-c
+    bmc:
+      address: "redfish+https://192.168.3.0/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+    labels:
+      site: lab-1
+      example.com/model: r650
+      oran-hwmgr/inventory-key: other
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-c", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			// The label reserved for the plugin is not set from the inventory
+			expected := map[string]string{"site": "lab-1", "example.com/model": "r650"}
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-0", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Labels).To(Equal(expected))
+
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-0", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(node.Status.Conditions, string(hwmgmtv1alpha1.Provisioned))).To(BeTrue())
+			Expect(node.Labels).To(Equal(expected))
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.BMCProtocolAnnotation, BMCProtocolRedfish))
+		})
+	})
+
+	Context("when a node has several interfaces", func() {
+		It("publishes the MAC address of its boot interface on the Node CR", func() {
+			resources := `
+hwprofiles:
+  - profile-c
+nodes:
+  node-c-0:
+    hwprofile: profile-c
+    interfaces:
+      - name: eno1
+        label: management
+        macAddress: "c6:b6:13:a0:03:00"
+      - name: ens1f0
+        label: bootable-interface
+        macAddress: "c6:b6:13:a0:03:01"
+      - name: ens1f1
+        macAddress: "c6:b6:13:a0:03:02"
+  node-c-1:
+    hwprofile: profile-c
+    interfaces:
+      - name: eth0
+        macAddress: "c6:b6:13:a0:03:10"
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-c", Size: 2})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-0", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.BootMACAddressAnnotation, "c6:b6:13:a0:03:01"))
+			Expect(node.Status.Interfaces).To(HaveLen(3))
+
+			// The only interface of a node is its boot interface, labelled or not
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-1", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Annotations).To(HaveKeyWithValue(utils.BootMACAddressAnnotation, "c6:b6:13:a0:03:10"))
+		})
+	})
+
+	Context("when a node references an existing credentials secret", func() {
+		const resources = `
+hwprofiles:
+  - profile-c
+nodes:
+  node-c-0:
+    hwprofile: profile-c
+    bmc:
+      address: "redfish+https://192.168.3.0/redfish/v1/Systems/1"
+      credentialsSecretRef: lab-bmc-credentials
+  node-c-1:
+    hwprofile: profile-c
+    bmc:
+      address: "redfish+https://192.168.3.1/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+`
+		var nodepool *hwmgmtv1alpha1.NodePool
+
+		BeforeEach(func() {
+			nodepool = newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-c", Size: 2})
+		})
+
+		It("points the Node CR at the referenced secret rather than writing a bmc-secret", func() {
+			credentials := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "lab-bmc-credentials", Namespace: testNamespace},
+				Data:       map[string][]byte{"username": []byte("vault-admin"), "password": []byte("vault-pass")},
+			}
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, ""), credentials).Build()
+			hwmgr = newTestService(c)
+			hwmgr.validateInventory = true
+
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-0", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Status.BMC.CredentialsName).To(Equal("lab-bmc-credentials"))
+			err := c.Get(ctx, types.NamespacedName{Name: "node-c-0-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			// Inline credentials are still written to a bmc-secret
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-1", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Status.BMC.CredentialsName).To(Equal("node-c-1-bmc-secret"))
+			secret := &corev1.Secret{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-1-bmc-secret", Namespace: testNamespace}, secret)).To(Succeed())
+			Expect(secret.Data).To(Equal(map[string][]byte{"username": []byte("admin"), "password": []byte("mypass")}))
+
+			// The referenced secret is neither rewritten nor deleted by the plugin
+			Expect(hwmgr.ReconcileBMCSecrets(ctx, nodepool)).To(BeEmpty())
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			Expect(c.Get(ctx, types.NamespacedName{Name: "lab-bmc-credentials", Namespace: testNamespace}, secret)).To(Succeed())
+			Expect(secret.Data).To(HaveKeyWithValue("username", []byte("vault-admin")))
+		})
+
+		It("fails the allocation of the node if the referenced secret is missing", func() {
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+
+			nodepool.Spec.NodeGroup[0].Size = 1
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(MatchError(
+				ContainSubstring("failed to get credentials secret lab-bmc-credentials of node node-c-0")))
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(BeEmpty())
+		})
+	})
+
+	Context("when the BMC credentials in the inventory change", func() {
+		It("rewrites the bmc-secrets of the allocated nodes to match", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			nodenames, err := hwmgr.GetAllocatedNodes(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nodenames).To(HaveLen(1))
+			nodename := nodenames[0]
+
+			// Nothing to reconcile while the bmc-secret matches the inventory
+			Expect(hwmgr.ReconcileBMCSecrets(ctx, nodepool)).To(BeEmpty())
+
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: defaultCMName, Namespace: testNamespace}, cm)).To(Succeed())
+			resources := cmResources{}
+			Expect(yaml.Unmarshal([]byte(cm.Data[defaultResourcesKey]), &resources)).To(Succeed())
+			resources.Nodes[nodename].BMC.UsernameBase64 = "cm9vdA=="
+			resources.Nodes[nodename].BMC.PasswordBase64 = "cm90YXRlZA=="
+			data, err := yaml.Marshal(resources)
+			Expect(err).ToNot(HaveOccurred())
+			cm.Data[defaultResourcesKey] = string(data)
+			Expect(c.Update(ctx, cm)).To(Succeed())
+
+			Expect(hwmgr.ReconcileBMCSecrets(ctx, nodepool)).To(Equal([]string{nodename}))
+
+			secret := &corev1.Secret{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: nodename + "-bmc-secret", Namespace: testNamespace}, secret)).To(Succeed())
+			Expect(secret.Data).To(Equal(map[string][]byte{"username": []byte("root"), "password": []byte("rotated")}))
+
+			Expect(hwmgr.ReconcileBMCSecrets(ctx, nodepool)).To(BeEmpty())
+		})
+	})
+
+	Context("when a node has no BMC info", func() {
+		It("allocates and provisions the node without a bmc-secret or BMC status", func() {
+			resources := `
+hwprofiles:
+  - profile-c
+nodes:
+  node-c-0:
+    hwprofile: profile-c
+    hostname: node-c-0.localhost
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-c", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{"node-c-0"}))
+
+			_, err := hwmgr.ProvisionAllocatedNodes(ctx, nodepool)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hwmgr.IsNodePoolProvisioned(ctx, nodepool)).To(BeTrue())
+
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: "node-c-0", Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Status.BMC).To(BeNil())
+			Expect(node.Status.Hostname).To(Equal("node-c-0.localhost"))
+
+			err = c.Get(ctx, types.NamespacedName{Name: "node-c-0-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Context("when an inventory key is not a valid object name", func() {
+		It("creates the Node CR with a valid name and preserves the original key", func() {
+			resources := `
+hwprofiles:
+  - profile-c
+nodes:
+  Rack1_Node_C0:
+    hwprofile: profile-c
+    bmc:
+      address: "redfish+https://192.168.3.0/redfish/v1/Systems/1"
+      username-base64: YWRtaW4=
+      password-base64: bXlwYXNz
+`
+			c = newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+			hwmgr = newTestService(c)
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-c", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			Expect(hwmgr.ProvisionAllocatedNodes(ctx, nodepool)).To(BeZero())
+
+			name := SanitizeNodeName("Rack1_Node_C0")
+			Expect(name).To(MatchRegexp(`^rack1-node-c0-[0-9a-f]{8}$`))
+			Expect(validation.IsDNS1123Subdomain(name)).To(BeEmpty())
+			Expect(hwmgr.GetAllocatedNodes(ctx, nodepool)).To(Equal([]string{name}))
+
+			node := &hwmgmtv1alpha1.Node{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: name, Namespace: testNamespace}, node)).To(Succeed())
+			Expect(node.Labels).To(HaveKeyWithValue(utils.InventoryKeyLabel, "Rack1_Node_C0"))
+			Expect(node.Status.BMC.CredentialsName).To(Equal(name + "-bmc-secret"))
+			Expect(c.Get(ctx, types.NamespacedName{Name: name + "-bmc-secret", Namespace: testNamespace}, &corev1.Secret{})).To(Succeed())
+
+			// The inventory key is recovered from the Node CR
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: defaultCMName, Namespace: testNamespace}, cm)).To(Succeed())
+			delete(cm.Data, defaultAllocationsKey)
+			Expect(c.Update(ctx, cm)).To(Succeed())
+			Expect(hwmgr.RecoverAllocations(ctx)).To(BeTrue())
+			Expect(getAllocations(ctx, c).Clouds[0].Nodegroups["master"]).To(Equal([]string{"Rack1_Node_C0"}))
+
+			Expect(hwmgr.ReleaseNodePool(ctx, nodepool)).To(Succeed())
+			err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: testNamespace}, &hwmgmtv1alpha1.Node{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("maps distinct keys to distinct names", func() {
+			Expect(SanitizeNodeName("node-a-0")).To(Equal("node-a-0"))
+			Expect(SanitizeNodeName("Node_A")).ToNot(Equal(SanitizeNodeName("node-a")))
+			Expect(SanitizeNodeName("Node_A")).ToNot(Equal(SanitizeNodeName("NODE_A")))
+		})
+	})
+
+	Context("when the sanitized names of nodes collide", func() {
+		const collidingResources = `
+hwprofiles:
+  - profile-c
+nodes:
+  Node_C0:
+    hwprofile: profile-c
+    bmc:
+      address: "redfish+https://192.168.3.0/redfish/v1/Systems/1"
+      username: admin-upper
+      password: pass-upper
+  node-c0:
+    hwprofile: profile-c
+    bmc:
+      address: "redfish+https://192.168.3.1/redfish/v1/Systems/1"
+      username: admin-lower
+      password: pass-lower
+`
+
+		getSecret := func(name string) *corev1.Secret {
+			secret := &corev1.Secret{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: name, Namespace: testNamespace}, secret)).To(Succeed())
+			return secret
+		}
+
+		newCollidingService := func(allocations string, objs ...client.Object) {
+			c = newFakeClientBuilder(append(objs, newNodelistConfigMap(collidingResources, allocations))...).Build()
+
+			var err error
+			hwmgr, err = NewHwMgrService().
+				SetClient(c).
+				SetLogger(slog.New(slog.NewTextHandler(GinkgoWriter, nil))).
+				SetNodeNameFunc(func(key string) string { return strings.ReplaceAll(strings.ToLower(key), "_", "-") }).
+				Build(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		It("writes distinct bmc-secrets", func() {
+			newCollidingService("")
+			_, resources, _, err := hwmgr.GetCurrentResources(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(hwmgr.createNodeBMCSecret(ctx, "node-c0", resources.Nodes["node-c0"].BMC)).To(Succeed())
+			Expect(hwmgr.createNodeBMCSecret(ctx, "Node_C0", resources.Nodes["Node_C0"].BMC)).To(Succeed())
+
+			altName := hwmgr.altBMCSecretName("Node_C0")
+			Expect(altName).To(MatchRegexp(`^node-c0-[0-9a-f]{8}-bmc-secret$`))
+			Expect(getSecret("node-c0-bmc-secret").Data).To(HaveKeyWithValue("username", []byte("admin-lower")))
+			Expect(getSecret(altName).Data).To(HaveKeyWithValue("username", []byte("admin-upper")))
+
+			// Each node resolves to its own bmc-secret, even once the other is deleted
+			Expect(hwmgr.DeleteBMCSecret(ctx, "node-c0")).To(Succeed())
+			Expect(hwmgr.resolveBMCSecretName(ctx, "Node_C0")).To(Equal(altName))
+			Expect(hwmgr.DeleteBMCSecret(ctx, "Node_C0")).To(Succeed())
+			err = c.Get(ctx, types.NamespacedName{Name: altName, Namespace: testNamespace}, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("repairs a bmc-secret shared by colliding nodes", func() {
+			// The shared bmc-secret predates the collision detection, so it has no owner and holds the credentials
+			// of whichever node was written last
+			shared := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-c0-bmc-secret", Namespace: testNamespace},
+				Data:       map[string][]byte{"username": []byte("admin-upper"), "password": []byte("pass-upper")},
+			}
+			newCollidingService("clouds:\n- cloudID: cloud-1\n  nodegroups:\n    master:\n    - Node_C0\n    - node-c0\n", shared)
+
+			Expect(hwmgr.RepairBMCSecrets(ctx)).To(Equal([]string{"Node_C0", "node-c0"}))
+
+			Expect(getSecret("node-c0-bmc-secret").Data).To(HaveKeyWithValue("username", []byte("admin-upper")))
+			Expect(getSecret("node-c0-bmc-secret").Annotations).To(HaveKeyWithValue(utils.InventoryKeyLabel, "Node_C0"))
+			altName := hwmgr.altBMCSecretName("node-c0")
+			Expect(getSecret(altName).Data).To(HaveKeyWithValue("username", []byte("admin-lower")))
+			Expect(getSecret(altName).Annotations).To(HaveKeyWithValue(utils.InventoryKeyLabel, "node-c0"))
+
+			// Each node now resolves to its own bmc-secret
+			Expect(hwmgr.resolveBMCSecretName(ctx, "node-c0")).To(Equal(altName))
+			Expect(hwmgr.resolveBMCSecretName(ctx, "Node_C0")).To(Equal("node-c0-bmc-secret"))
+		})
+	})
+
+	Context("when an event recorder is configured", func() {
+		var recorder *record.FakeRecorder
+
+		events := func() (result []string) {
+			for len(recorder.Events) > 0 {
+				result = append(result, <-recorder.Events)
+			}
+			return
+		}
+
+		BeforeEach(func() {
+			recorder = record.NewFakeRecorder(10)
+		})
+
+		It("emits the allocation plan before the allocation events", func() {
+			hwmgr.recorder = recorder
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1},
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.PlanAllocation(ctx, nodepool)).To(Equal([]AllocationPick{
+				{NodeGroup: "master", NodeName: "node-a-0"},
+				{NodeGroup: "worker", NodeName: "node-a-1"},
+			}))
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+
+			Expect(events()).To(Equal([]string{
+				"Normal AllocationPlanned Planned node allocation: master=node-a-0, worker=node-a-1",
+				"Normal NodeAllocated Allocated node node-a-0 to nodegroup master",
+				"Normal NodeAllocated Allocated node node-a-1 to nodegroup worker",
+			}))
+		})
+
+		It("emits the allocation plan even if the commit fails", func() {
+			c = interceptor.NewClient(newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build(),
+				interceptor.Funcs{
+					Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+						if _, ok := obj.(*hwmgmtv1alpha1.Node); ok {
+							return errors.New("injected failure")
+						}
+						return c.Create(ctx, obj, opts...)
+					},
+				})
+			hwmgr = newTestService(c)
+			hwmgr.recorder = recorder
+
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).ToNot(Succeed())
+
+			Expect(events()).To(HaveExactElements(
+				"Normal AllocationPlanned Planned node allocation: master=node-a-0",
+				HavePrefix("Warning AllocationFailed Node allocation failed:"),
+			))
+		})
+	})
+
+	Context("when the allocations are lost from the configmap", func() {
+		It("recovers them from the Node CRs", func() {
+			nodepools := []*hwmgmtv1alpha1.NodePool{
+				newNodePool("np1", "cloud-1",
+					hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2},
+					hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1}),
+				newNodePool("np2", "cloud-2",
+					hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1}),
+			}
+			for _, nodepool := range nodepools {
+				Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+				Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			}
+			expected := getAllocations(ctx, c)
+
+			// Nothing to recover while the allocations are intact
+			recovered, err := hwmgr.RecoverAllocations(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(recovered).To(BeFalse())
+
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: defaultCMName, Namespace: testNamespace}, cm)).To(Succeed())
+			delete(cm.Data, defaultAllocationsKey)
+			Expect(c.Update(ctx, cm)).To(Succeed())
+
+			recovered, err = hwmgr.RecoverAllocations(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(recovered).To(BeTrue())
+
+			allocations := getAllocations(ctx, c)
+			Expect(allocations.Clouds).To(ConsistOf(expected.Clouds))
+
+			for _, nodepool := range nodepools {
+				for _, nodegroup := range nodepool.Spec.NodeGroup {
+					for _, cloud := range allocations.Clouds {
+						if cloud.CloudID == nodepool.Spec.CloudID {
+							Expect(cloud.Nodegroups[nodegroup.Name]).To(HaveLen(nodegroup.Size))
+						}
+					}
+				}
+			}
+		})
+
+		It("recovers them in the periodic inventory maintenance", func() {
+			nodepool := newNodePool("np1", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+			Expect(hwmgr.AllocateNode(ctx, nodepool)).To(Succeed())
+			expected := getAllocations(ctx, c)
+
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: defaultCMName, Namespace: testNamespace}, cm)).To(Succeed())
+			delete(cm.Data, defaultAllocationsKey)
+			Expect(c.Update(ctx, cm)).To(Succeed())
+
+			Expect(hwmgr.MaintainInventory(ctx)).To(Succeed())
+			Expect(getAllocations(ctx, c).Clouds).To(ConsistOf(expected.Clouds))
+
+			status := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, types.NamespacedName{Name: InventoryStatusConfigMapName, Namespace: testNamespace}, status)).To(Succeed())
+			Expect(status.Data).To(HaveKeyWithValue(InventoryStatusKey, InventoryHealthy))
+		})
+	})
+
+	Context("when allocating multiple NodePools concurrently", func() {
+		It("does not corrupt the allocations", func() {
+			pools := []*hwmgmtv1alpha1.NodePool{
+				newNodePool("np1", "cloud-1",
+					hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2},
+					hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1}),
+				newNodePool("np2", "cloud-2",
+					hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2},
+					hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 1}),
+			}
+
+			// Each pass allocates a node to each nodegroup that needs one, so a few passes suffice for each NodePool
+			var wg sync.WaitGroup
+			errs := make([]error, len(pools))
+			full := make([]bool, len(pools))
+			for i, nodepool := range pools {
+				wg.Add(1)
+				go func(i int, nodepool *hwmgmtv1alpha1.NodePool) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for pass := 0; pass < 10 && !full[i] && errs[i] == nil; pass++ {
+						full[i], errs[i] = hwmgr.CheckNodePoolProgress(ctx, nodepool)
+					}
+				}(i, nodepool)
+			}
+			wg.Wait()
+
+			for i := range pools {
+				Expect(errs[i]).ToNot(HaveOccurred())
+				Expect(full[i]).To(BeTrue(), "NodePool %s not fully allocated", pools[i].Name)
+			}
+
+			allocations := getAllocations(ctx, c)
+			Expect(allocations.Clouds).To(HaveLen(2))
+
+			seen := make(map[string]string)
+			for _, cloud := range allocations.Clouds {
+				Expect(cloud.Nodegroups["master"]).To(HaveLen(2))
+				Expect(cloud.Nodegroups["worker"]).To(HaveLen(1))
+				for _, nodes := range cloud.Nodegroups {
+					for _, nodename := range nodes {
+						Expect(seen).ToNot(HaveKey(nodename), "node %s allocated more than once", nodename)
+						seen[nodename] = cloud.CloudID
+					}
+				}
+			}
+
+			nodes := &hwmgmtv1alpha1.NodeList{}
+			Expect(c.List(ctx, nodes)).To(Succeed())
+			Expect(nodes.Items).To(HaveLen(len(seen)))
+		})
+	})
+})
+
+var _ = Describe("Stale allocation groups", func() {
+	var (
+		ctx   context.Context
+		c     client.Client
+		hwmgr *HwMgrService
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+      worker:
+        - node-b-0
+  - cloudID: cloud-2
+    nodegroups:
+      master:
+        - node-a-1
+`
+		nodepool := newNodePool("np1", "cloud-1",
+			hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1})
+		c = newFakeClientBuilder(newNodelistConfigMap(testResources, allocations), nodepool).Build()
+		hwmgr = newTestService(c)
+	})
+
+	It("flags the nodegroups with no corresponding NodePool", func() {
+		stale, err := hwmgr.FindStaleAllocationGroups(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stale).To(Equal([]StaleAllocationGroup{
+			{CloudID: "cloud-1", Nodegroup: "worker", Nodes: []string{"node-b-0"}},
+			{CloudID: "cloud-2", Nodegroup: "master", Nodes: []string{"node-a-1"}},
+		}))
+	})
+
+	It("prunes the stale nodegroups, releasing their nodes", func() {
+		stale, err := hwmgr.FindStaleAllocationGroups(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hwmgr.PruneStaleAllocationGroups(ctx, stale)).To(Succeed())
+
+		Expect(getAllocations(ctx, c).Clouds).To(Equal([]cmAllocatedCloud{
+			{CloudID: "cloud-1", Nodegroups: map[string][]string{"master": {"node-a-0"}}},
+		}))
+
+		stale, err = hwmgr.FindStaleAllocationGroups(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stale).To(BeEmpty())
+	})
+
+	It("only flags the stale nodegroups on a periodic check unless pruning is enabled", func() {
+		Expect(hwmgr.CheckStaleAllocationGroups(ctx, false)).To(HaveLen(2))
+		Expect(getAllocations(ctx, c).Clouds).To(HaveLen(2))
+
+		checkCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		manager := &StaleAllocationManager{HwMgr: hwmgr, Prune: true, Interval: time.Hour}
+		go func() {
+			defer GinkgoRecover()
+			Expect(manager.Start(checkCtx)).To(Succeed())
+		}()
+
+		Eventually(func() []cmAllocatedCloud { return getAllocations(ctx, c).Clouds }).Should(Equal([]cmAllocatedCloud{
+			{CloudID: "cloud-1", Nodegroups: map[string][]string{"master": {"node-a-0"}}},
+		}))
+	})
+})
+
+var _ = Describe("NodePool validation report", func() {
+	It("classifies each NodePool against the inventory", func() {
+		ctx := context.Background()
+		allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+  - cloudID: cloud-2
+    nodegroups:
+      master:
+        - node-a-1
+        - node-a-2
+`
+		c := newFakeClientBuilder(newNodelistConfigMap(testResources, allocations),
+			newNodePool("np-healthy", "cloud-1",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 2}),
+			newNodePool("np-over", "cloud-2",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-a", Size: 1}),
+			newNodePool("np-missing", "cloud-3",
+				hwmgmtv1alpha1.NodeGroup{Name: "master", HwProfile: "profile-c", Size: 1}),
+			newNodePool("np-short", "cloud-4",
+				hwmgmtv1alpha1.NodeGroup{Name: "worker", HwProfile: "profile-b", Size: 3}),
+		).Build()
+		hwmgr := newTestService(c)
+
+		report, err := hwmgr.ValidateNodePools(ctx)
+		Expect(err).ToNot(HaveOccurred())
+
+		statuses := make(map[string]NodePoolValidationStatus)
+		for _, result := range report.NodePools {
+			statuses[result.Name] = result.Status
+		}
+		Expect(statuses).To(Equal(map[string]NodePoolValidationStatus{
+			"np-healthy": NodePoolSatisfiable,
+			"np-over":    NodePoolOverAllocated,
+			"np-missing": NodePoolMissingProfile,
+			"np-short":   NodePoolUnsatisfiable,
+		}))
+		Expect(report.NodePools[3].Name).To(Equal("np-short"))
+		Expect(report.NodePools[3].Message).To(ContainSubstring("hardware profile profile-b has only 2 node(s)"))
+	})
+})
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Paths served by the inventory API
+const (
+	InventoryAPICapacityPath    = "/api/v1/capacity"
+	InventoryAPIAllocationsPath = "/api/v1/allocations"
+	InventoryAPINodesPath       = "/api/v1/nodes"
+)
+
+// InventoryAPIHandler serves the read-only inventory and allocation data of the HwMgrService as JSON. Requests must
+// present the configured token as a bearer token.
+type InventoryAPIHandler struct {
+	hwmgr  *HwMgrService
+	token  string
+	logger *slog.Logger
+	mux    *http.ServeMux
+}
+
+// NewInventoryAPIHandler creates a handler for the inventory API, protected by the given bearer token
+func NewInventoryAPIHandler(hwmgr *HwMgrService, token string) *InventoryAPIHandler {
+	handler := &InventoryAPIHandler{
+		hwmgr:  hwmgr,
+		token:  token,
+		logger: hwmgr.logger,
+		mux:    http.NewServeMux(),
+	}
+
+	handler.mux.HandleFunc(InventoryAPICapacityPath, func(w http.ResponseWriter, r *http.Request) {
+		handler.serve(w, r, func(ctx context.Context) (any, error) { return hwmgr.GetCapacity(ctx) })
+	})
+	handler.mux.HandleFunc(InventoryAPIAllocationsPath, func(w http.ResponseWriter, r *http.Request) {
+		handler.serve(w, r, func(ctx context.Context) (any, error) { return hwmgr.GetAllAllocations(ctx) })
+	})
+	handler.mux.HandleFunc(InventoryAPINodesPath, func(w http.ResponseWriter, r *http.Request) {
+		handler.serve(w, r, func(ctx context.Context) (any, error) { return hwmgr.GetNodeInventory(ctx) })
+	})
+
+	return handler
+}
+
+func (a *InventoryAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="inventory"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *InventoryAPIHandler) authorized(r *http.Request) bool {
+	if a.token == "" {
+		return false
+	}
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+func (a *InventoryAPIHandler) serve(w http.ResponseWriter, r *http.Request, get func(ctx context.Context) (any, error)) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := get(r.Context())
+	if err != nil {
+		a.logger.ErrorContext(r.Context(), "inventory API request failed", "path", r.URL.Path, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		a.logger.ErrorContext(r.Context(), "failed to encode inventory API response", "path", r.URL.Path, "error", err)
+	}
+}
+
+// InventoryAPIServer runs the inventory API as a manager Runnable
+type InventoryAPIServer struct {
+	Addr    string
+	Handler http.Handler
+}
+
+// Start serves the inventory API until the context is cancelled
+func (s *InventoryAPIServer) Start(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              s.Addr,
+		Handler:           s.Handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("inventory API server failed: %w", err)
+	}
+
+	return nil
+}
+
+// NeedLeaderElection allows the inventory API to be served by every replica, as it is read-only
+func (s *InventoryAPIServer) NeedLeaderElection() bool {
+	return false
+}
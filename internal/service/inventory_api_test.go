@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("InventoryAPIHandler", func() {
+	const token = "test-token"
+
+	var (
+		c       client.Client
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+      worker:
+        - node-b-1
+`
+		c = newFakeClientBuilder(newNodelistConfigMap(testResources, allocations)).Build()
+		handler = NewInventoryAPIHandler(newTestService(c), token)
+	})
+
+	get := func(path, bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil).WithContext(context.Background())
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("rejects requests without a valid token", func() {
+		Expect(get(InventoryAPICapacityPath, "").Code).To(Equal(http.StatusUnauthorized))
+		Expect(get(InventoryAPICapacityPath, "wrong-token").Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("reports the capacity per hwprofile", func() {
+		rec := get(InventoryAPICapacityPath, token)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var capacity map[string]ProfileCapacity
+		Expect(json.Unmarshal(rec.Body.Bytes(), &capacity)).To(Succeed())
+		Expect(capacity).To(Equal(map[string]ProfileCapacity{
+			"profile-a": {Total: 4, Allocated: 1, Free: 3},
+			"profile-b": {Total: 2, Allocated: 1, Free: 1},
+		}))
+	})
+
+	It("reports the allocations per cloud", func() {
+		rec := get(InventoryAPIAllocationsPath, token)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"cloud-1": {"master": ["node-a-0"], "worker": ["node-b-1"]}}`))
+	})
+
+	It("reports the node inventory without credentials", func() {
+		rec := get(InventoryAPINodesPath, token)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).ToNot(ContainSubstring("YWRtaW4="))
+
+		var inventory map[string]NodeInventory
+		Expect(json.Unmarshal(rec.Body.Bytes(), &inventory)).To(Succeed())
+		Expect(inventory).To(HaveLen(6))
+		Expect(inventory["node-a-0"]).To(Equal(NodeInventory{
+			HwProfile:  "profile-a",
+			Hostname:   "node-a-0.localhost",
+			BMCAddress: "redfish+https://192.168.1.0/redfish/v1/Systems/1",
+			BMC:        &BMCAddress{Scheme: "redfish+https", Host: "192.168.1.0", Port: 443},
+			CloudID:    "cloud-1",
+			GroupName:  "master",
+		}))
+		Expect(inventory["node-a-1"].CloudID).To(BeEmpty())
+	})
+})
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// inventoryCache holds the nodelist configmap as last read, so that the read-only queries made within a reconcile do
+// not each fetch it again
+type inventoryCache struct {
+	lock    sync.Mutex
+	cm      *corev1.ConfigMap
+	expires time.Time
+
+	// generation is bumped on each invalidation, so that a configmap read before an update is not cached after it
+	generation uint64
+}
+
+// readConfigMap fetches the nodelist configmap through the given reader, caching it for the read-only queries if the
+// inventory cache is enabled
+func (h *HwMgrService) readConfigMap(ctx context.Context, reader client.Reader) (*corev1.ConfigMap, error) {
+	generation := h.inventoryCacheGeneration()
+
+	cm, err := utils.GetConfigmap(ctx, reader, h.cmName, h.namespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get configmap: %w", err)
+	}
+
+	if h.inventoryCacheTTL > 0 {
+		h.inventoryCache.lock.Lock()
+		if h.inventoryCache.generation == generation {
+			h.inventoryCache.cm = cm.DeepCopy()
+			h.inventoryCache.expires = h.clock.Now().Add(h.inventoryCacheTTL)
+		}
+		h.inventoryCache.lock.Unlock()
+	}
+
+	return cm, nil
+}
+
+// inventoryCacheGeneration gets the current generation of the inventory cache
+func (h *HwMgrService) inventoryCacheGeneration() uint64 {
+	h.inventoryCache.lock.Lock()
+	defer h.inventoryCache.lock.Unlock()
+	return h.inventoryCache.generation
+}
+
+// invalidateInventoryCache drops the cached nodelist configmap, as it is being updated
+func (h *HwMgrService) invalidateInventoryCache() {
+	h.inventoryCache.lock.Lock()
+	defer h.inventoryCache.lock.Unlock()
+	h.inventoryCache.cm = nil
+	h.inventoryCache.generation++
+}
+
+// getCachedResources is GetCurrentResources for read-only queries, reusing the nodelist configmap if it was read within
+// the inventory cache TTL, or else reading it through the read client. A read that leads to an update of the configmap
+// must use GetCurrentResources instead, which always reads it afresh through the write client.
+func (h *HwMgrService) getCachedResources(ctx context.Context) (
+	cm *corev1.ConfigMap, resources cmResources, allocations cmAllocations, err error) {
+	h.inventoryCache.lock.Lock()
+	if h.inventoryCache.cm != nil && h.clock.Now().Before(h.inventoryCache.expires) {
+		cm = h.inventoryCache.cm.DeepCopy()
+	}
+	h.inventoryCache.lock.Unlock()
+
+	if cm == nil {
+		if cm, err = h.readConfigMap(ctx, h.reader); err != nil {
+			return
+		}
+	}
+
+	resources, allocations, err = h.parseConfigMap(ctx, cm)
+	return
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// nodelistSchemaJSON is the bundled JSON schema for the data keys of the nodelist configmap
+//
+//go:embed schema/nodelist.schema.json
+var nodelistSchemaJSON []byte
+
+// jsonSchema is the subset of JSON schema used to describe the nodelist configmap
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+
+	// reject is set for the "false" boolean schema, which no value satisfies
+	reject bool
+}
+
+// schemaTypes holds the type keyword, which may be a single type or a list of types
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("type must be a string or list of strings: %w", err)
+	}
+	*t = list
+	return nil
+}
+
+func (s *jsonSchema) UnmarshalJSON(data []byte) error {
+	var boolean bool
+	if err := json.Unmarshal(data, &boolean); err == nil {
+		*s = jsonSchema{reject: !boolean}
+		return nil
+	}
+
+	type plain jsonSchema
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+var nodelistSchema = func() *jsonSchema {
+	schema := &jsonSchema{}
+	if err := json.Unmarshal(nodelistSchemaJSON, schema); err != nil {
+		panic(fmt.Sprintf("invalid bundled nodelist schema: %v", err))
+	}
+	return schema
+}()
+
+// schemaType gets the JSON schema type name of a decoded JSON value
+func schemaType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// validate checks a decoded JSON value against the schema, returning an error for each violation, prefixed by the
+// path to the offending field
+func (s *jsonSchema) validate(path string, value any) (errs []error) {
+	if s.reject {
+		return []error{fmt.Errorf("%s: field is not allowed", path)}
+	}
+
+	if len(s.Type) > 0 {
+		valueType := schemaType(value)
+		if !slices.Contains(s.Type, valueType) && !(valueType == "integer" && slices.Contains(s.Type, "number")) {
+			return []error{fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.Type, " or "), valueType)}
+		}
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(item any) bool { return reflect.DeepEqual(item, value) }) {
+		errs = append(errs, fmt.Errorf("%s: value %v is not one of %v", path, value, s.Enum))
+	}
+
+	switch v := value.(type) {
+	case string:
+		if s.MinLength != nil && len(v) < *s.MinLength {
+			errs = append(errs, fmt.Errorf("%s: must be at least %d characters", path, *s.MinLength))
+		}
+		if s.Pattern != "" {
+			if matched, err := regexp.MatchString(s.Pattern, v); err != nil || !matched {
+				errs = append(errs, fmt.Errorf("%s: value %q does not match pattern %q", path, v, s.Pattern))
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case map[string]any:
+		for _, key := range s.Required {
+			if _, exists := v[key]; !exists {
+				errs = append(errs, fmt.Errorf("%s: missing required field %q", path, key))
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if property, exists := s.Properties[key]; exists {
+				errs = append(errs, property.validate(path+"."+key, v[key])...)
+			} else if s.AdditionalProperties != nil {
+				errs = append(errs, s.AdditionalProperties.validate(path+"."+key, v[key])...)
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkInventoryConsistency checks for conflicts between the nodes of the inventory that cannot be expressed in the
+// schema, such as a MAC address shared by more than one interface, more than one boot interface, labels that are not
+// valid on a Node CR, or credentials that are not valid base64
+func checkInventoryConsistency(resources cmResources, resourcesKey string) (errs []error) {
+	nodenames := make([]string, 0, len(resources.Nodes))
+	for nodename := range resources.Nodes {
+		nodenames = append(nodenames, nodename)
+	}
+	sort.Strings(nodenames)
+
+	owners := make(map[string]string)
+	for _, nodename := range nodenames {
+		for i, iface := range resources.Nodes[nodename].Interfaces {
+			if iface == nil {
+				continue
+			}
+
+			path := fmt.Sprintf("%s.nodes.%s.interfaces[%d].macAddress", resourcesKey, nodename, i)
+			mac := strings.ToLower(iface.MACAddress)
+			if owner, exists := owners[mac]; exists {
+				errs = append(errs, fmt.Errorf("%s: duplicate MAC address %s, also used by %s", path, iface.MACAddress, owner))
+				continue
+			}
+			owners[mac] = path
+		}
+	}
+
+	for _, nodename := range nodenames {
+		var boot []string
+		for i, iface := range resources.Nodes[nodename].Interfaces {
+			if iface != nil && iface.Label == BootInterfaceLabel {
+				boot = append(boot, fmt.Sprintf("%s.nodes.%s.interfaces[%d]", resourcesKey, nodename, i))
+			}
+		}
+		if len(boot) > 1 {
+			errs = append(errs, fmt.Errorf("%s: more than one interface labelled %s", strings.Join(boot, ", "),
+				BootInterfaceLabel))
+		}
+	}
+
+	for _, nodename := range nodenames {
+		labels := resources.Nodes[nodename].Labels
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			path := fmt.Sprintf("%s.nodes.%s.labels.%s", resourcesKey, nodename, key)
+			switch {
+			case strings.HasPrefix(key, utils.AnnotationPrefix):
+				errs = append(errs, fmt.Errorf("%s: the %s prefix is reserved for the plugin", path, utils.AnnotationPrefix))
+			case len(validation.IsQualifiedName(key)) != 0:
+				errs = append(errs, fmt.Errorf("%s: invalid label key: %s", path,
+					strings.Join(validation.IsQualifiedName(key), "; ")))
+			case len(validation.IsValidLabelValue(labels[key])) != 0:
+				errs = append(errs, fmt.Errorf("%s: invalid label value: %s", path,
+					strings.Join(validation.IsValidLabelValue(labels[key]), "; ")))
+			}
+		}
+	}
+
+	if err := checkBMCCredentials(resources); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", resourcesKey, err))
+	}
+
+	return
+}
+
+// validateConfigMap checks the data keys of the nodelist configmap against the bundled schema, and the consistency of
+// the inventory. The schema is defined for the default keys, which are mapped to the configured ones.
+func (h *HwMgrService) validateConfigMap(cm *corev1.ConfigMap) error {
+	var errs []error
+	for _, keys := range [][2]string{{defaultResourcesKey, h.resourcesKey}, {defaultAllocationsKey, h.allocationsKey}} {
+		property, key := keys[0], keys[1]
+		data, exists := cm.Data[key]
+		if !exists {
+			continue
+		}
+
+		jsonData, err := yaml.YAMLToJSON([]byte(data))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid yaml: %w", key, err))
+			continue
+		}
+
+		var value any
+		if err := json.Unmarshal(jsonData, &value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid data: %w", key, err))
+			continue
+		}
+
+		errs = append(errs, nodelistSchema.Properties[property].validate(key, value)...)
+	}
+
+	// The consistency of the inventory is only checked once it matches the schema
+	if _, exists := cm.Data[h.resourcesKey]; exists && len(errs) == 0 {
+		resources, err := utils.ExtractDataFromConfigMap[cmResources](cm, h.resourcesKey)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", h.resourcesKey, err))
+		} else {
+			errs = append(errs, checkInventoryConsistency(resources, h.resourcesKey)...)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s configmap does not match the schema: %w", h.cmName, errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// ValidateInventory checks the nodelist configmap against the bundled schema and for inconsistencies between nodes,
+// reporting any violations with the path to the offending field
+func (h *HwMgrService) ValidateInventory(ctx context.Context) error {
+	cm, err := utils.GetConfigmap(ctx, h.reader, h.cmName, h.namespace)
+	if err != nil {
+		return fmt.Errorf("unable to get configmap: %w", err)
+	}
+
+	return h.validateConfigMap(cm)
+}
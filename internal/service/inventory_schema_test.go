@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("ValidateInventory", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("accepts a valid inventory and allocations", func() {
+		allocations := `
+clouds:
+  - cloudID: cloud-1
+    nodegroups:
+      master:
+        - node-a-0
+`
+		hwmgr := newTestService(newFakeClientBuilder(newNodelistConfigMap(testResources, allocations)).Build())
+		Expect(hwmgr.ValidateInventory(ctx)).To(Succeed())
+	})
+
+	It("accepts the power caps of racks and the power draw of nodes", func() {
+		resources := `
+hwprofiles:
+  - profile-a
+rackPowerCaps:
+  rack-1: 5000
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    rack: rack-1
+    powerDraw: 800
+`
+		hwmgr := newTestService(newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build())
+		Expect(hwmgr.ValidateInventory(ctx)).To(Succeed())
+	})
+
+	It("accepts the allocations written for an empty cloud list", func() {
+		hwmgr := newTestService(newFakeClientBuilder(newNodelistConfigMap(testResources, "clouds: null\n")).Build())
+		Expect(hwmgr.ValidateInventory(ctx)).To(Succeed())
+	})
+
+	It("reports the path to each offending field", func() {
+		resources := `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    bmc:
+      address: 10
+  node-a-1:
+    hwprofile: profile-a
+    interfaces:
+      - name: eth0
+        macAddress: "not-a-mac"
+  node-a-2:
+    bmc:
+      address: "redfish+https://192.168.1.2/redfish/v1/Systems/1"
+    hostame: node-a-2.localhost
+`
+		hwmgr := newTestService(newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build())
+
+		err := hwmgr.ValidateInventory(ctx)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("resources.nodes.node-a-0.bmc.address: expected string, got integer"))
+		Expect(err.Error()).To(ContainSubstring(`resources.nodes.node-a-1.interfaces[0].macAddress: value "not-a-mac" does not match pattern`))
+		Expect(err.Error()).To(ContainSubstring(`resources.nodes.node-a-2: missing required field "hwprofile"`))
+		Expect(err.Error()).To(ContainSubstring("resources.nodes.node-a-2.hostame: field is not allowed"))
+	})
+
+	It("reports a MAC address shared by more than one node as an unhealthy inventory status", func() {
+		resources := `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    interfaces:
+      - name: eth0
+        macAddress: "00:11:22:33:44:55"
+  node-a-1:
+    hwprofile: profile-a
+    interfaces:
+      - name: eth0
+        macAddress: "00:11:22:33:44:AA"
+      - name: eth1
+        macAddress: "00:11:22:33:44:55"
+`
+		c := newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build()
+		hwmgr := newTestService(c)
+
+		Expect(hwmgr.ValidateInventory(ctx)).To(MatchError(ContainSubstring(
+			"resources.nodes.node-a-1.interfaces[1].macAddress: duplicate MAC address 00:11:22:33:44:55, " +
+				"also used by resources.nodes.node-a-0.interfaces[0].macAddress")))
+
+		Expect(hwmgr.UpdateInventoryStatus(ctx)).To(BeFalse())
+
+		status := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: InventoryStatusConfigMapName, Namespace: testNamespace}, status)).To(Succeed())
+		Expect(status.Data).To(HaveKeyWithValue(InventoryStatusKey, InventoryUnhealthy))
+		Expect(status.Data[InventoryStatusMessageKey]).To(ContainSubstring("duplicate MAC address"))
+	})
+
+	It("reports the nodes with malformed BMC credentials without affecting the other nodes", func() {
+		resources := `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    bmc:
+      address: redfish+http://192.168.1.10/redfish/v1/Systems/1
+      username-base64: YWRtaW4=
+      password-base64: cGFzc3dvcmQ=
+  node-a-1:
+    hwprofile: profile-a
+    bmc:
+      address: redfish+http://192.168.1.11/redfish/v1/Systems/1
+      username-base64: YWRtaW4
+      password-base64: cGFzc3dvcmQ=
+`
+		hwmgr := newTestService(newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build())
+
+		err := hwmgr.ValidateInventory(ctx)
+		Expect(err).To(MatchError(ContainSubstring("malformed BMC credentials for nodes node-a-1:")))
+		Expect(err).To(MatchError(ContainSubstring("failed to decode usernameBase64 for node node-a-1")))
+		Expect(err.Error()).NotTo(ContainSubstring("node-a-0"))
+
+		_, parsed, _, err := hwmgr.GetCurrentResources(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Nodes).To(HaveLen(2))
+
+		username, password, err := bmcCredentials("node-a-0", parsed.Nodes["node-a-0"].BMC)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(username)).To(Equal("admin"))
+		Expect(string(password)).To(Equal("password"))
+	})
+
+	It("reports the labels of a node that cannot be set on its Node CR", func() {
+		resources := `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    labels:
+      site: lab-1
+      oran-hwmgr/inventory-key: other
+      model: "not a valid value"
+`
+		hwmgr := newTestService(newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build())
+
+		err := hwmgr.ValidateInventory(ctx)
+		Expect(err).To(MatchError(ContainSubstring(
+			"resources.nodes.node-a-0.labels.model: invalid label value")))
+		Expect(err).To(MatchError(ContainSubstring(
+			"resources.nodes.node-a-0.labels.oran-hwmgr/inventory-key: the oran-hwmgr/ prefix is reserved for the plugin")))
+		Expect(err.Error()).NotTo(ContainSubstring("labels.site"))
+	})
+
+	It("reports a node with more than one boot interface", func() {
+		resources := `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofile: profile-a
+    interfaces:
+      - name: eth0
+        label: bootable-interface
+        macAddress: "00:11:22:33:44:55"
+      - name: eth1
+        label: bootable-interface
+        macAddress: "00:11:22:33:44:66"
+`
+		hwmgr := newTestService(newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build())
+		Expect(hwmgr.ValidateInventory(ctx)).To(MatchError(ContainSubstring(
+			"resources.nodes.node-a-0.interfaces[0], resources.nodes.node-a-0.interfaces[1]: " +
+				"more than one interface labelled bootable-interface")))
+	})
+
+	It("reports a valid inventory as a healthy inventory status", func() {
+		c := newFakeClientBuilder(newNodelistConfigMap(testResources, "")).Build()
+		hwmgr := newTestService(c)
+
+		Expect(hwmgr.UpdateInventoryStatus(ctx)).To(BeTrue())
+
+		status := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: InventoryStatusConfigMapName, Namespace: testNamespace}, status)).To(Succeed())
+		Expect(status.Data).To(HaveKeyWithValue(InventoryStatusKey, InventoryHealthy))
+
+		// The status is not rewritten while unchanged
+		resourceVersion := status.ResourceVersion
+		Expect(hwmgr.UpdateInventoryStatus(ctx)).To(BeTrue())
+		Expect(c.Get(ctx, types.NamespacedName{Name: InventoryStatusConfigMapName, Namespace: testNamespace}, status)).To(Succeed())
+		Expect(status.ResourceVersion).To(Equal(resourceVersion))
+	})
+
+	It("rejects an invalid inventory on read when enabled", func() {
+		resources := `
+hwprofiles: profile-a
+nodes: {}
+`
+		hwmgr := newTestService(newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build())
+		hwmgr.validateInventory = true
+
+		_, _, _, err := hwmgr.GetCurrentResources(ctx)
+		Expect(err).To(MatchError(ContainSubstring("resources.hwprofiles: expected array, got string")))
+	})
+
+	It("rejects unknown fields on read only in strict mode", func() {
+		resources := `
+hwprofiles:
+  - profile-a
+nodes:
+  node-a-0:
+    hwprofle: profile-a
+`
+		hwmgr := newTestService(newFakeClientBuilder(newNodelistConfigMap(resources, "")).Build())
+
+		_, parsed, _, err := hwmgr.GetCurrentResources(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Nodes).To(HaveKey("node-a-0"))
+
+		hwmgr.strictInventory = true
+		_, _, _, err = hwmgr.GetCurrentResources(ctx)
+		Expect(err).To(MatchError(ContainSubstring(`unknown field "hwprofle"`)))
+
+		Expect(hwmgr.ValidateInventory(ctx)).To(MatchError(ContainSubstring(
+			"resources.nodes.node-a-0.hwprofle: field is not allowed")))
+	})
+})
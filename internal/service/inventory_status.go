@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The inventory status configmap reports the health of the nodelist configmap, as found by ValidateInventory. It is
+// named after the nodelist configmap, so InventoryStatusConfigMapName is its name for the default nodelist configmap.
+const (
+	InventoryStatusConfigMapName = defaultCMName + inventoryStatusSuffix
+	InventoryStatusKey           = "status"
+	InventoryStatusMessageKey    = "message"
+)
+
+// inventoryStatusSuffix is appended to the name of the nodelist configmap to name its inventory status configmap
+const inventoryStatusSuffix = "-status"
+
+// Values of the status key of the inventory status configmap
+const (
+	InventoryHealthy   = "Healthy"
+	InventoryUnhealthy = "Unhealthy"
+)
+
+// UpdateInventoryStatus validates the nodelist configmap and records the result in the inventory status configmap, so
+// that operators can see the health of the inventory at a glance. The configmap is only written when the result
+// changes. It returns whether the inventory is healthy.
+func (h *HwMgrService) UpdateInventoryStatus(ctx context.Context) (healthy bool, err error) {
+	data := map[string]string{
+		InventoryStatusKey:        InventoryHealthy,
+		InventoryStatusMessageKey: "Inventory is valid",
+	}
+	if validationErr := h.ValidateInventory(ctx); validationErr != nil {
+		data[InventoryStatusKey] = InventoryUnhealthy
+		data[InventoryStatusMessageKey] = validationErr.Error()
+	}
+	healthy = data[InventoryStatusKey] == InventoryHealthy
+
+	name := h.cmName + inventoryStatusSuffix
+	cm := &corev1.ConfigMap{}
+	err = h.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: h.namespace}, cm)
+	switch {
+	case errors.IsNotFound(err):
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: h.namespace,
+			},
+			Data: data,
+		}
+		if err = h.Client.Create(ctx, cm); err != nil {
+			err = fmt.Errorf("failed to create %s configmap: %w", name, err)
+		}
+		return
+	case err != nil:
+		err = fmt.Errorf("failed to get %s configmap: %w", name, err)
+		return
+	}
+
+	if cm.Data[InventoryStatusKey] == data[InventoryStatusKey] &&
+		cm.Data[InventoryStatusMessageKey] == data[InventoryStatusMessageKey] {
+		return
+	}
+
+	if !healthy {
+		h.logger.WarnContext(ctx, "Inventory is unhealthy", "message", data[InventoryStatusMessageKey])
+	}
+
+	cm.Data = data
+	if err = h.Client.Update(ctx, cm); err != nil {
+		err = fmt.Errorf("failed to update %s configmap: %w", name, err)
+	}
+	return
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// MaintainInventory does the upkeep of the whole inventory that is not specific to any NodePool: it rebuilds the
+// allocations from the Node CRs in case they were lost from the nodelist configmap, reports the health of the
+// inventory in its status configmap, and recomputes the free nodes metric
+func (h *HwMgrService) MaintainInventory(ctx context.Context) error {
+	if _, err := h.RecoverAllocations(ctx); err != nil {
+		return fmt.Errorf("failed to recover allocations: %w", err)
+	}
+
+	if _, err := h.UpdateInventoryStatus(ctx); err != nil {
+		return fmt.Errorf("failed to update inventory status: %w", err)
+	}
+
+	if err := h.UpdateFreeNodesMetric(ctx); err != nil {
+		return fmt.Errorf("failed to update free nodes metric: %w", err)
+	}
+
+	return nil
+}
+
+// InventoryMaintenanceManager periodically maintains the inventory as a manager Runnable, starting as soon as it is
+// started, so that the allocations are recovered before they are relied on
+type InventoryMaintenanceManager struct {
+	HwMgr *HwMgrService
+
+	// Interval is the period between maintenance passes
+	Interval time.Duration
+}
+
+// Start maintains the inventory at each interval until the context is cancelled
+func (m *InventoryMaintenanceManager) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := m.HwMgr.MaintainInventory(ctx); err != nil {
+			m.HwMgr.logger.ErrorContext(ctx, "failed to maintain the inventory", "error", err)
+		}
+	}, m.Interval)
+
+	return nil
+}
+
+// NeedLeaderElection restricts the maintenance to the leader, as recovery updates the allocations
+func (m *InventoryMaintenanceManager) NeedLeaderElection() bool {
+	return true
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Reasons for which nodes are filtered out of the candidates for a nodegroup, as recorded in the allocation metrics
+const (
+	filteredReasonProfile      = "profile"
+	filteredReasonAllocated    = "allocated"
+	filteredReasonCooldown     = "cooldown"
+	filteredReasonExcluded     = "excluded"
+	filteredReasonSerial       = "serial"
+	filteredReasonAccelerators = "accelerators"
+	filteredReasonFirmware     = "firmware"
+	filteredReasonBMC          = "bmc"
+	filteredReasonRack         = "rack"
+	filteredReasonPower        = "power"
+)
+
+// allocationCandidates reports the number of inventory nodes considered as candidates when selecting nodes for
+// nodegroups
+var allocationCandidates = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "oran_hwmgr_allocation_candidates_total",
+	Help: "Number of inventory nodes considered as candidates when selecting nodes for nodegroups",
+})
+
+// allocationFiltered reports the number of candidate nodes filtered out when selecting nodes for nodegroups, by the
+// reason for which they were filtered out
+var allocationFiltered = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "oran_hwmgr_allocation_candidates_filtered_total",
+	Help: "Number of candidate nodes filtered out when selecting nodes for nodegroups, by reason",
+}, []string{"reason"})
+
+// allocationSelected reports the number of candidate nodes selected for nodegroups
+var allocationSelected = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "oran_hwmgr_allocation_candidates_selected_total",
+	Help: "Number of candidate nodes selected for nodegroups",
+})
+
+// nodesAllocated reports the number of nodes allocated to NodePools
+var nodesAllocated = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "oran_hwmgr_nodes_allocated_total",
+	Help: "Number of nodes allocated to NodePools",
+})
+
+// nodesReleased reports the number of nodes released from NodePools
+var nodesReleased = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "oran_hwmgr_nodes_released_total",
+	Help: "Number of nodes released from NodePools",
+})
+
+// insufficientResources reports the number of NodePool admissions and allocations that failed for a lack of free
+// nodes, by the operation that failed
+var insufficientResources = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "oran_hwmgr_insufficient_resources_total",
+	Help: "Number of NodePool admissions and allocations that failed for a lack of free nodes, by operation",
+}, []string{"operation"})
+
+// Operations recorded in the insufficient resources metric
+const (
+	operationAdmission  = "admission"
+	operationAllocation = "allocation"
+)
+
+// freeNodes reports the number of free nodes in each hardware profile
+var freeNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "oran_hwmgr_free_nodes",
+	Help: "Number of free nodes in each hardware profile",
+}, []string{"hwprofile"})
+
+// fleetUtilization reports the percentage of the nodes in the inventory, across all hardware profiles, that are not
+// free, for top-level dashboards
+var fleetUtilization = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "oran_hwmgr_fleet_utilization_percent",
+	Help: "Percentage of the nodes in the inventory, across all hardware profiles, that are not free",
+})
+
+func init() {
+	metrics.Registry.MustRegister(allocationCandidates, allocationFiltered, allocationSelected,
+		nodesAllocated, nodesReleased, insufficientResources, freeNodes, fleetUtilization)
+}
+
+// countInsufficientResources records a failure of the specified operation if it was for a lack of free nodes
+func countInsufficientResources(operation string, err error) {
+	var insufficient *InsufficientResourcesError
+	if errors.As(err, &insufficient) {
+		insufficientResources.WithLabelValues(operation).Inc()
+	}
+}
+
+// UpdateFreeNodesMetric recomputes the number of free nodes in each hardware profile from the current inventory and
+// allocations, dropping the hardware profiles that are no longer in the inventory, along with the fleet utilization.
+// An empty inventory is reported as unutilized.
+func (h *HwMgrService) UpdateFreeNodesMetric(ctx context.Context) error {
+	_, resources, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	var total, allocated int
+	freeNodes.Reset()
+	for profname, capacity := range computeCapacity(resources, allocations) {
+		freeNodes.WithLabelValues(profname).Set(float64(capacity.Free))
+		total += capacity.Total
+		allocated += capacity.Allocated
+	}
+
+	utilization := 0.0
+	if total > 0 {
+		utilization = 100 * float64(allocated) / float64(total)
+	}
+	fleetUtilization.Set(utilization)
+
+	return nil
+}
+
+// candidateTally accumulates the decisions made when selecting nodes for the nodegroups of a NodePool, so that they are
+// recorded in the allocation metrics once per allocation, rather than once per attempt
+type candidateTally struct {
+	candidates int
+	filtered   map[string]int
+	selected   int
+}
+
+// consider tallies the inventory nodes considered as candidates for a nodegroup with the specified hardware profile,
+// attributing each node that is filtered out to the first reason for which it is rejected
+func (t *candidateTally) consider(resources cmResources, allocations cmAllocations, profname string,
+	filters []candidateFilter) {
+	if t == nil {
+		return
+	}
+	if t.filtered == nil {
+		t.filtered = make(map[string]int)
+	}
+
+	inuse := getNodesInUse(allocations)
+	for nodename, node := range resources.Nodes {
+		t.candidates++
+		switch {
+		case node.HwProfile != profname:
+			t.filtered[filteredReasonProfile]++
+		case inuse[nodename]:
+			t.filtered[filteredReasonAllocated]++
+		default:
+			if reason := rejectedBy(nodename, node, filters); reason != "" {
+				t.filtered[reason]++
+			}
+		}
+	}
+}
+
+// pick tallies a node selected for a nodegroup
+func (t *candidateTally) pick() {
+	if t != nil {
+		t.selected++
+	}
+}
+
+// record adds the tallied decisions to the allocation metrics
+func (t *candidateTally) record() {
+	allocationCandidates.Add(float64(t.candidates))
+	for reason, count := range t.filtered {
+		allocationFiltered.WithLabelValues(reason).Add(float64(count))
+	}
+	allocationSelected.Add(float64(t.selected))
+}
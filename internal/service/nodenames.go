@@ -0,0 +1,87 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// bmcSecretSuffix is appended to the Node CR name to get the name of its bmc-secret
+const bmcSecretSuffix = "-bmc-secret"
+
+// maxNodeNameLength leaves room for the bmc-secret suffix within the object name length limit
+const maxNodeNameLength = validation.DNS1123SubdomainMaxLength - len(bmcSecretSuffix)
+
+var invalidNodeNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// SanitizeNodeName is the default mapping of an inventory key to a Node CR name. Keys that are already valid object
+// names are used as-is. Otherwise, the key is lowercased, runs of invalid characters are replaced by "-", and a hash of
+// the original key is appended so that distinct keys cannot map to the same name.
+func SanitizeNodeName(key string) string {
+	if len(key) <= maxNodeNameLength && len(validation.IsDNS1123Subdomain(key)) == 0 {
+		return key
+	}
+
+	suffix := "-" + keyHash(key)
+
+	name := invalidNodeNameChars.ReplaceAllString(strings.ToLower(key), "-")
+	if len(name) > maxNodeNameLength-len(suffix) {
+		name = name[:maxNodeNameLength-len(suffix)]
+	}
+	name = strings.Trim(name, ".-")
+	if name == "" {
+		name = "node"
+	}
+
+	return name + suffix
+}
+
+// keyHash gets a short hash of an inventory key, used to disambiguate the names derived from it
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// nodeName gets the Node CR name for an inventory key
+func (h *HwMgrService) nodeName(key string) string {
+	return h.nodeNameFunc(key)
+}
+
+// nodeLabels gets the labels for the Node CR of an inventory key, preserving the original key where it differs from
+// the Node CR name and is a valid label value
+func (h *HwMgrService) nodeLabels(key string) map[string]string {
+	if key == h.nodeName(key) || len(validation.IsValidLabelValue(key)) != 0 {
+		return nil
+	}
+	return map[string]string{utils.InventoryKeyLabel: key}
+}
+
+// applyInventoryLabels sets the labels given to a node in the inventory on its Node CR, skipping those under the
+// oran-hwmgr/ prefix, which are reserved for the plugin
+func applyInventoryLabels(node *hwmgmtv1alpha1.Node, labels map[string]string) {
+	for key, value := range labels {
+		if strings.HasPrefix(key, utils.AnnotationPrefix) {
+			continue
+		}
+		if node.Labels == nil {
+			node.Labels = make(map[string]string)
+		}
+		node.Labels[key] = value
+	}
+}
+
+// inventoryKey gets the inventory key for a Node CR, as recorded when it was created
+func inventoryKey(node *hwmgmtv1alpha1.Node) string {
+	if key, exists := node.Annotations[utils.InventoryKeyLabel]; exists {
+		return key
+	}
+	if key, exists := node.Labels[utils.InventoryKeyLabel]; exists {
+		return key
+	}
+	return node.Name
+}
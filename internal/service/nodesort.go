@@ -0,0 +1,120 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Keys by which the candidate nodes for a nodegroup can be ordered
+const (
+	NodeSortKeyRack     = "rack"
+	NodeSortKeySerial   = "serial"
+	NodeSortKeyHostname = "hostname"
+	NodeSortKeyName     = "name"
+)
+
+// nodeSortFields maps each sort key to the node field it compares
+var nodeSortFields = map[string]func(nodename string, node cmNodeInfo) string{
+	NodeSortKeyRack:     func(_ string, node cmNodeInfo) string { return node.Rack },
+	NodeSortKeySerial:   func(_ string, node cmNodeInfo) string { return node.Serial },
+	NodeSortKeyHostname: func(_ string, node cmNodeInfo) string { return node.Hostname },
+	NodeSortKeyName:     func(nodename string, _ cmNodeInfo) string { return nodename },
+}
+
+// ParseNodeSortKeys parses a comma-separated list of sort keys (e.g. "rack,name") by which ties between candidate nodes
+// are broken
+func ParseNodeSortKeys(value string) ([]string, error) {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+
+		if _, exists := nodeSortFields[key]; !exists {
+			return nil, fmt.Errorf("invalid node sort key %q: expected one of %s, %s, %s, or %s",
+				key, NodeSortKeyRack, NodeSortKeySerial, NodeSortKeyHostname, NodeSortKeyName)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// nodeComparator orders two candidate nodes, returning a negative number if a comes first, a positive number if b
+// comes first, or zero if they are tied
+type nodeComparator func(a, b string) int
+
+// nodeSortComparator returns a comparator chain for the specified sort keys, comparing by each key in turn until the
+// tie is broken. Nodes with an empty value for a key are ordered after those with one. The node name is always the
+// final key, so the order is fully deterministic.
+func nodeSortComparator(resources cmResources, keys []string) nodeComparator {
+	chain := make([]func(nodename string, node cmNodeInfo) string, 0, len(keys)+1)
+	for _, key := range keys {
+		chain = append(chain, nodeSortFields[key])
+	}
+	chain = append(chain, nodeSortFields[NodeSortKeyName])
+
+	return func(a, b string) int {
+		for _, field := range chain {
+			valueA, valueB := field(a, resources.Nodes[a]), field(b, resources.Nodes[b])
+			switch {
+			case valueA == valueB:
+				continue
+			case valueA == "":
+				return 1
+			case valueB == "":
+				return -1
+			default:
+				return cmp.Compare(valueA, valueB)
+			}
+		}
+		return 0
+	}
+}
+
+// adjacentFirst orders the candidate nodes for a nodegroup by their distance, in the name order of the nodes of its
+// hardware profile, from the nearest node of the profile already allocated to the cloud, so that scaling up keeps the
+// nodes of a NodePool contiguous. The order is left unchanged if the cloud has no such nodes.
+func adjacentFirst(resources cmResources, profname string, cloud cmAllocatedCloud, freenodes []string) []string {
+	var profile []string
+	for nodename, node := range resources.Nodes {
+		if node.HwProfile == profname {
+			profile = append(profile, nodename)
+		}
+	}
+	slices.Sort(profile)
+
+	var allocated []int
+	for _, nodenames := range cloud.Nodegroups {
+		for _, nodename := range nodenames {
+			if position, found := slices.BinarySearch(profile, nodename); found {
+				allocated = append(allocated, position)
+			}
+		}
+	}
+	if len(allocated) == 0 {
+		return freenodes
+	}
+
+	distance := func(nodename string) int {
+		position, _ := slices.BinarySearch(profile, nodename)
+		nearest := len(profile)
+		for _, other := range allocated {
+			nearest = min(nearest, max(position-other, other-position))
+		}
+		return nearest
+	}
+
+	slices.SortStableFunc(freenodes, func(a, b string) int {
+		return cmp.Compare(distance(a), distance(b))
+	})
+	return freenodes
+}
+
+// sortCandidates orders the candidate nodes for a nodegroup by the configured sort keys
+func (h *HwMgrService) sortCandidates(resources cmResources, freenodes []string) []string {
+	slices.SortStableFunc(freenodes, nodeSortComparator(resources, h.nodeSortKeys))
+	return freenodes
+}
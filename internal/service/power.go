@@ -0,0 +1,91 @@
+package service
+
+import "slices"
+
+// rackPowerUsage gets the power drawn by the nodes in use in each rack, whether allocated or in a warm pool, as both are
+// powered on. Nodes without a rack are not counted.
+func rackPowerUsage(resources cmResources, allocations cmAllocations) map[string]int {
+	usage := make(map[string]int)
+	draw := func(nodename string) {
+		if node := resources.Nodes[nodename]; node.Rack != "" {
+			usage[node.Rack] += node.PowerDraw
+		}
+	}
+
+	for nodename := range getNodesInUse(allocations) {
+		draw(nodename)
+	}
+	for _, nodename := range allocations.Warm {
+		draw(nodename)
+	}
+	return usage
+}
+
+// withinPowerCap reports whether a node can be allocated within the power cap of its rack, given the power drawn in
+// each rack. A warm node already draws its power, and nodes without a rack, or in a rack without a power cap, are not
+// limited.
+func withinPowerCap(resources cmResources, usage map[string]int, warm map[string]bool, nodename string,
+	node cmNodeInfo) bool {
+	limit, capped := resources.RackPowerCaps[node.Rack]
+	return !capped || node.Rack == "" || warm[nodename] || usage[node.Rack]+node.PowerDraw <= limit
+}
+
+// warmNodes gets the set of nodes in a warm pool
+func warmNodes(allocations cmAllocations) map[string]bool {
+	warm := make(map[string]bool, len(allocations.Warm))
+	for _, nodename := range allocations.Warm {
+		warm[nodename] = true
+	}
+	return warm
+}
+
+// powerFilters gets the filters applied to all candidate nodes for the power caps of their racks
+func powerFilters(resources cmResources, allocations cmAllocations) (filters []candidateFilter) {
+	if len(resources.RackPowerCaps) > 0 {
+		usage := rackPowerUsage(resources, allocations)
+		warm := warmNodes(allocations)
+		filters = append(filters, candidateFilter{filteredReasonPower, func(nodename string, node cmNodeInfo) bool {
+			return withinPowerCap(resources, usage, warm, nodename, node)
+		}})
+	}
+
+	return
+}
+
+// capRackPower drops the candidate nodes beyond those that could still be allocated within the power caps of their
+// racks, so that the number of remaining candidates is the number of nodes that could actually be allocated
+func capRackPower(resources cmResources, allocations cmAllocations, freenodes []string) []string {
+	if len(resources.RackPowerCaps) == 0 {
+		return freenodes
+	}
+
+	usage := rackPowerUsage(resources, allocations)
+	warm := warmNodes(allocations)
+	var capped []string
+	for _, nodename := range freenodes {
+		node := resources.Nodes[nodename]
+		if withinPowerCap(resources, usage, warm, nodename, node) {
+			if !warm[nodename] && node.Rack != "" {
+				usage[node.Rack] += node.PowerDraw
+			}
+			capped = append(capped, nodename)
+		}
+	}
+	return capped
+}
+
+// poweredOnFirst orders the candidate nodes that are already powered on ahead of those that are powered off, keeping
+// the existing order of nodes in the same power state, so that they are provisioned without first being powered up
+func poweredOnFirst(resources cmResources, nodenames []string) []string {
+	slices.SortStableFunc(nodenames, func(a, b string) int {
+		switch poweredA, poweredB := resources.Nodes[a].PoweredOn, resources.Nodes[b].PoweredOn; {
+		case poweredA == poweredB:
+			return 0
+		case poweredA:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return nodenames
+}
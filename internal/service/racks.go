@@ -0,0 +1,65 @@
+package service
+
+import (
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+// maxPerRack gets the maximum number of nodes of a NodePool that may be allocated from any one rack, or zero if it is
+// not limited. An invalid annotation is rejected when the NodePool is admitted, so it is ignored here.
+func maxPerRack(nodepool *hwmgmtv1alpha1.NodePool) int {
+	limit, _ := utils.GetIntAnnotation(nodepool, utils.MaxPerRackAnnotation)
+	return limit
+}
+
+// rackCounts gets the number of nodes allocated to a cloud from each rack, across all of its nodegroups. Nodes without
+// a rack are not counted.
+func rackCounts(resources cmResources, allocations cmAllocations, cloudID string) map[string]int {
+	counts := make(map[string]int)
+	for _, cloud := range allocations.Clouds {
+		if cloud.CloudID != cloudID {
+			continue
+		}
+		for _, nodenames := range cloud.Nodegroups {
+			for _, nodename := range nodenames {
+				if rack := resources.Nodes[nodename].Rack; rack != "" {
+					counts[rack]++
+				}
+			}
+		}
+	}
+	return counts
+}
+
+// rackLimit returns a nodeFilter that rejects the nodes in racks from which the limit of nodes has already been
+// allocated. Nodes without a rack are not limited.
+func rackLimit(counts map[string]int, limit int) nodeFilter {
+	return func(_ string, node cmNodeInfo) bool {
+		return node.Rack == "" || counts[node.Rack] < limit
+	}
+}
+
+// capPerRack drops the candidate nodes of a NodePool beyond those that could still be allocated from each rack under
+// its rack limit, so that the number of remaining candidates is the number of nodes that could actually be allocated
+func capPerRack(resources cmResources, allocations cmAllocations, nodepool *hwmgmtv1alpha1.NodePool,
+	freenodes []string) []string {
+	limit := maxPerRack(nodepool)
+	if limit == 0 {
+		return freenodes
+	}
+
+	counts := rackCounts(resources, allocations, nodepool.Spec.CloudID)
+	var capped []string
+	for _, nodename := range freenodes {
+		rack := resources.Nodes[nodename].Rack
+		if rack == "" {
+			capped = append(capped, nodename)
+			continue
+		}
+		if counts[rack] < limit {
+			counts[rack]++
+			capped = append(capped, nodename)
+		}
+	}
+	return capped
+}
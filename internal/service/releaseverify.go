@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// ReleaseVerifier checks, before a released node is freed, that it is safe to allocate again, such as by confirming
+// through its BMC that it is powered off
+type ReleaseVerifier interface {
+	// VerifyPoweredOff reports whether the node is powered off. The bmc-secret of the node still exists when it is
+	// called, so that the verifier can use the credentials to query the BMC.
+	VerifyPoweredOff(ctx context.Context, nodename, bmcAddress string) (bool, error)
+}
+
+// unverifiedNodes returns the nodes allocated to a cloud that the release verifier has not yet confirmed to be powered
+// off. If no verifier is configured, all nodes are considered verified.
+func (h *HwMgrService) unverifiedNodes(
+	ctx context.Context, resources cmResources, cloud cmAllocatedCloud) ([]string, error) {
+	if h.releaseVerifier == nil {
+		return nil, nil
+	}
+
+	var pending []string
+	for _, nodenames := range cloud.Nodegroups {
+		for _, nodename := range nodenames {
+			var address string
+			if bmc := resources.Nodes[nodename].BMC; bmc != nil {
+				address = bmc.Address
+			}
+
+			off, err := h.releaseVerifier.VerifyPoweredOff(ctx, nodename, address)
+			if err != nil {
+				return nil, fmt.Errorf("failed to verify power state of node %s: %w", nodename, err)
+			}
+			if !off {
+				pending = append(pending, nodename)
+			}
+		}
+	}
+
+	slices.Sort(pending)
+	return pending, nil
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+// Strategies by which a node is selected for a nodegroup, as recorded on its Node CR
+const (
+	// SelectionStrategySorted picks the first candidate in the order of the configured sort keys, or by name
+	SelectionStrategySorted = "sorted"
+
+	// SelectionStrategyAdjacent picks the candidate closest to the nodes already allocated to the NodePool
+	SelectionStrategyAdjacent = "adjacent"
+
+	// SelectionStrategyAccelerators picks the candidate with the most accelerators, for a nodegroup requesting a
+	// number of accelerators
+	SelectionStrategyAccelerators = "most-accelerators"
+
+	// SelectionStrategyPoweredOn picks a candidate that is already powered on, ahead of those that are powered off
+	SelectionStrategyPoweredOn = "powered-on"
+
+	// SelectionStrategyWarm picks a pre-provisioned candidate from the warm pool
+	SelectionStrategyWarm = "warm-pool"
+
+	// SelectionStrategySwap is an explicit replacement of another node in the nodegroup
+	SelectionStrategySwap = "swap"
+
+	// SelectionStrategyRestore recreates the missing Node CR of a node that was already allocated
+	SelectionStrategyRestore = "restore"
+)
+
+// NodeSelection describes why a node was selected for a nodegroup, for post-hoc auditing of the allocation decisions
+type NodeSelection struct {
+	// Strategy is how the node was chosen among the candidates for the nodegroup
+	Strategy string
+
+	// Criteria are the reasons, as recorded in the allocation metrics, for which candidates were filtered out, all of
+	// which the node satisfied
+	Criteria []string
+}
+
+// nodeSelection describes the selection of a node for a nodegroup of a NodePool, following the order of preference
+// applied to the candidates when planning the allocation
+func (h *HwMgrService) nodeSelection(resources cmResources, allocations cmAllocations, nodepool *hwmgmtv1alpha1.NodePool,
+	nodegroup hwmgmtv1alpha1.NodeGroup, nodename string, warm bool) NodeSelection {
+	selection := NodeSelection{Strategy: SelectionStrategySorted}
+	switch {
+	case warm:
+		selection.Strategy = SelectionStrategyWarm
+	case h.preferPoweredOn && resources.Nodes[nodename].PoweredOn:
+		selection.Strategy = SelectionStrategyPoweredOn
+	case acceleratorTarget(nodepool, nodegroup) > 0:
+		selection.Strategy = SelectionStrategyAccelerators
+	case h.preferAdjacent:
+		selection.Strategy = SelectionStrategyAdjacent
+	}
+
+	for _, filter := range h.nodeFilters(resources, allocations, nodepool, nodegroup) {
+		selection.Criteria = append(selection.Criteria, filter.reason)
+	}
+
+	return selection
+}
+
+// annotateSelection records the selection of a node in the annotations of its Node CR
+func annotateSelection(node *hwmgmtv1alpha1.Node, selection NodeSelection) {
+	if node.Annotations == nil {
+		node.Annotations = make(map[string]string)
+	}
+
+	node.Annotations[utils.SelectionStrategyAnnotation] = selection.Strategy
+	node.Annotations[utils.SelectionProfileAnnotation] = node.Spec.HwProfile
+	if len(selection.Criteria) > 0 {
+		node.Annotations[utils.SelectionCriteriaAnnotation] = strings.Join(selection.Criteria, ",")
+	} else {
+		delete(node.Annotations, utils.SelectionCriteriaAnnotation)
+	}
+}
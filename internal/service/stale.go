@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+// StaleAllocationGroup is a nodegroup in the allocations data that does not correspond to a nodegroup of any NodePool,
+// such as one left behind after its NodePool was removed without being released
+type StaleAllocationGroup struct {
+	CloudID   string
+	Nodegroup string
+	Nodes     []string
+}
+
+// FindStaleAllocationGroups returns the allocated nodegroups that have no corresponding nodegroup in a NodePool
+func (h *HwMgrService) FindStaleAllocationGroups(ctx context.Context) ([]StaleAllocationGroup, error) {
+	_, _, allocations, err := h.getCachedResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	nodepools := &hwmgmtv1alpha1.NodePoolList{}
+	if err := h.Client.List(ctx, nodepools, client.InNamespace(h.namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list NodePools: %w", err)
+	}
+
+	groups := make(map[string][]string)
+	for _, nodepool := range nodepools.Items {
+		for _, nodegroup := range nodepool.Spec.NodeGroup {
+			groups[nodepool.Spec.CloudID] = append(groups[nodepool.Spec.CloudID], nodegroup.Name)
+		}
+	}
+
+	var stale []StaleAllocationGroup
+	for _, cloud := range allocations.Clouds {
+		groupnames := make([]string, 0, len(cloud.Nodegroups))
+		for groupname := range cloud.Nodegroups {
+			groupnames = append(groupnames, groupname)
+		}
+		sort.Strings(groupnames)
+
+		for _, groupname := range groupnames {
+			if !slices.Contains(groups[cloud.CloudID], groupname) {
+				stale = append(stale, StaleAllocationGroup{
+					CloudID:   cloud.CloudID,
+					Nodegroup: groupname,
+					Nodes:     cloud.Nodegroups[groupname],
+				})
+			}
+		}
+	}
+
+	return stale, nil
+}
+
+// PruneStaleAllocationGroups releases the nodes of the given stale nodegroups and removes the nodegroups from the
+// allocations data, along with any cloud left without nodegroups
+func (h *HwMgrService) PruneStaleAllocationGroups(ctx context.Context, stale []StaleAllocationGroup) error {
+	for _, group := range stale {
+		if len(group.Nodes) == 0 {
+			continue
+		}
+
+		h.logger.InfoContext(ctx, "Releasing nodes of stale nodegroup",
+			"cloudID", group.CloudID, "nodegroup", group.Nodegroup, "nodes", group.Nodes)
+		// A nodegroup whose Node CRs are still being deleted keeps its remaining nodes, so it is pruned once they are
+		// released on a later check
+		var pending *ReleasePendingError
+		if err := h.releaseNodes(ctx, group.CloudID, group.Nodes); errors.As(err, &pending) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to release nodes of stale nodegroup %s/%s: %w", group.CloudID, group.Nodegroup, err)
+		}
+	}
+
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	return h.retryOnConflict(ctx, func() error {
+		return h.removeEmptyAllocationGroups(ctx, stale)
+	})
+}
+
+// removeEmptyAllocationGroups removes the given stale nodegroups from the allocations data once all of their nodes are
+// released, along with any cloud left without nodegroups. It must be called with the allocation lock held.
+func (h *HwMgrService) removeEmptyAllocationGroups(ctx context.Context, stale []StaleAllocationGroup) error {
+	cm, _, allocations, err := h.GetCurrentResources(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	pruned := false
+	for _, group := range stale {
+		index := slices.IndexFunc(allocations.Clouds, func(cloud cmAllocatedCloud) bool {
+			return cloud.CloudID == group.CloudID
+		})
+		if index == -1 {
+			continue
+		}
+
+		// Leave the nodegroup if nodes were allocated to it since it was found to be stale
+		if nodes, exists := allocations.Clouds[index].Nodegroups[group.Nodegroup]; !exists || len(nodes) != 0 {
+			continue
+		}
+
+		delete(allocations.Clouds[index].Nodegroups, group.Nodegroup)
+		if len(allocations.Clouds[index].Nodegroups) == 0 {
+			allocations.Clouds = slices.Delete(allocations.Clouds, index, index+1)
+		}
+		pruned = true
+	}
+
+	if !pruned {
+		return nil
+	}
+
+	return h.updateAllocations(ctx, cm, allocations)
+}
+
+// CheckStaleAllocationGroups flags the stale allocated nodegroups in the logs, and releases them if pruning is enabled,
+// returning the stale nodegroups found
+func (h *HwMgrService) CheckStaleAllocationGroups(ctx context.Context, prune bool) ([]StaleAllocationGroup, error) {
+	stale, err := h.FindStaleAllocationGroups(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find stale allocations: %w", err)
+	}
+
+	for _, group := range stale {
+		h.logger.WarnContext(ctx, "Allocated nodegroup has no corresponding NodePool",
+			slog.String("cloudID", group.CloudID),
+			slog.String("nodegroup", group.Nodegroup),
+			slog.Any("nodes", group.Nodes),
+			slog.Bool("prune", prune))
+	}
+
+	if len(stale) == 0 || !prune {
+		return stale, nil
+	}
+
+	if err := h.PruneStaleAllocationGroups(ctx, stale); err != nil {
+		return stale, fmt.Errorf("failed to prune stale allocations: %w", err)
+	}
+
+	return stale, nil
+}
+
+// StaleAllocationManager periodically checks for stale allocated nodegroups as a manager Runnable
+type StaleAllocationManager struct {
+	HwMgr *HwMgrService
+
+	// Prune enables the release of the stale nodegroups. Otherwise, they are only flagged.
+	Prune bool
+
+	// Interval is the period between checks for stale nodegroups
+	Interval time.Duration
+}
+
+// Start checks for stale nodegroups at each interval until the context is cancelled
+func (m *StaleAllocationManager) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if _, err := m.HwMgr.CheckStaleAllocationGroups(ctx, m.Prune); err != nil {
+			m.HwMgr.logger.ErrorContext(ctx, "failed to check for stale allocations", "error", err)
+		}
+	}, m.Interval)
+
+	return nil
+}
+
+// NeedLeaderElection restricts the checks to the leader, as pruning updates the allocations
+func (m *StaleAllocationManager) NeedLeaderElection() bool {
+	return true
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const testNamespace = "oran-hwmgr-plugin-test"
+
+func TestService(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Service Suite")
+}
+
+var _ = BeforeSuite(func() {
+	Expect(os.Setenv("MY_POD_NAMESPACE", testNamespace)).To(Succeed())
+})
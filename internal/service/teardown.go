@@ -0,0 +1,39 @@
+package service
+
+import (
+	"slices"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+// teardownPriority gets the teardown priority of a nodegroup of a NodePool, or zero if it is not set. An invalid
+// annotation is rejected when the NodePool is admitted, so it is ignored here.
+func teardownPriority(nodepool *hwmgmtv1alpha1.NodePool, groupname string) int {
+	priority, _ := utils.GetIntAnnotation(nodepool, utils.TeardownPriorityAnnotationPrefix+groupname)
+	return priority
+}
+
+// teardownTiers groups the allocated nodegroups of a NodePool by their teardown priority, in the order in which they
+// are released, from the lowest priority to the highest. The nodegroups of each tier are sorted by name.
+func teardownTiers(nodepool *hwmgmtv1alpha1.NodePool, nodegroups map[string][]string) [][]string {
+	byPriority := make(map[int][]string)
+	for groupname := range nodegroups {
+		priority := teardownPriority(nodepool, groupname)
+		byPriority[priority] = append(byPriority[priority], groupname)
+	}
+
+	priorities := make([]int, 0, len(byPriority))
+	for priority := range byPriority {
+		priorities = append(priorities, priority)
+	}
+	slices.Sort(priorities)
+
+	tiers := make([][]string, 0, len(priorities))
+	for _, priority := range priorities {
+		groupnames := byPriority[priority]
+		slices.Sort(groupnames)
+		tiers = append(tiers, groupnames)
+	}
+	return tiers
+}
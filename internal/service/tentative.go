@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// setNodeTentative adds or removes the tentative annotation on the Node CR of an allocated node
+func (h *HwMgrService) setNodeTentative(ctx context.Context, nodename string, tentative bool) error {
+	node := &hwmgmtv1alpha1.Node{}
+	if err := h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
+		return fmt.Errorf("failed to get Node: %w", err)
+	}
+
+	if tentative {
+		if node.Annotations == nil {
+			node.Annotations = make(map[string]string)
+		}
+		node.Annotations[utils.TentativeAnnotation] = "true"
+	} else {
+		delete(node.Annotations, utils.TentativeAnnotation)
+	}
+
+	if err := h.Client.Update(ctx, node); err != nil {
+		return fmt.Errorf("failed to update Node %s: %w", nodename, err)
+	}
+
+	return nil
+}
+
+// ReconcileTentativeAllocations handles the tentative node allocations of a NodePool CR. Allocations confirmed by the
+// external system become firm, while those left unconfirmed past the tentative allocation TTL expire and their nodes
+// are freed. It returns when the next pending allocation expires, or the zero time if none are pending, and the
+// inventory keys of the expired nodes.
+func (h *HwMgrService) ReconcileTentativeAllocations(ctx context.Context, nodepool *hwmgmtv1alpha1.NodePool) (
+	heldUntil time.Time, expired []string, err error) {
+	ttl, err := utils.GetDurationAnnotation(nodepool, utils.TentativeAllocationTTLAnnotation)
+	if err != nil {
+		return
+	}
+
+	allocatedNodes, err := h.getAllocatedNodeKeys(ctx, nodepool)
+	if err != nil {
+		err = fmt.Errorf("failed to get allocated nodes for %s: %w", nodepool.Name, err)
+		return
+	}
+
+	now := h.clock.Now()
+	for _, nodename := range allocatedNodes {
+		node := &hwmgmtv1alpha1.Node{}
+		if err = h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
+			err = fmt.Errorf("failed to get Node %s: %w", nodename, err)
+			return
+		}
+
+		if !utils.IsAnnotationTrue(node, utils.TentativeAnnotation) {
+			continue
+		}
+
+		if utils.IsAnnotationTrue(node, utils.ConfirmedAnnotation) {
+			h.logger.InfoContext(ctx, "Tentative allocation confirmed", "nodename", nodename)
+			if err = h.setNodeTentative(ctx, nodename, false); err != nil {
+				return
+			}
+			continue
+		}
+
+		// Without a TTL, or a valid allocation time, the allocation remains tentative until confirmed
+		allocatedAt, parseErr := time.Parse(time.RFC3339, node.Annotations[utils.AllocatedAtAnnotation])
+		if ttl == 0 || parseErr != nil {
+			continue
+		}
+
+		if expiry := allocatedAt.Add(ttl); expiry.After(now) {
+			if heldUntil.IsZero() || expiry.Before(heldUntil) {
+				heldUntil = expiry
+			}
+			continue
+		}
+
+		expired = append(expired, nodename)
+	}
+
+	if len(expired) != 0 {
+		h.logger.InfoContext(ctx, "Tentative allocations expired", "name", nodepool.Name, "nodes", expired)
+		if err = h.releaseNodes(ctx, nodepool.Spec.CloudID, expired); err != nil {
+			err = fmt.Errorf("failed to free expired tentative allocations: %w", err)
+		}
+	}
+
+	return
+}
+
+// releaseNodes frees the specified nodes allocated to a cloud. Their Node CRs are deleted first, and only the nodes
+// whose Node CRs are gone are freed, so that a node is never reallocated while its old Node CR remains. The rest are
+// left allocated, with a ReleasePendingError returned so that the release is repeated.
+func (h *HwMgrService) releaseNodes(ctx context.Context, cloudID string, nodenames []string) error {
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	for _, nodename := range nodenames {
+		if err := h.DeleteNode(ctx, nodename); err != nil {
+			return fmt.Errorf("failed to delete node %s: %w", nodename, err)
+		}
+	}
+
+	pending, err := h.pendingNodeDeletions(ctx, nodenames)
+	if err != nil {
+		return err
+	}
+	freed := slices.DeleteFunc(slices.Clone(nodenames), func(nodename string) bool {
+		return slices.Contains(pending, nodename)
+	})
+	if err := h.retryOnConflict(ctx, func() error {
+		return h.freeNodes(ctx, cloudID, freed)
+	}, "cloudID", cloudID); err != nil {
+		return err
+	}
+
+	if len(pending) != 0 {
+		h.logger.InfoContext(ctx, "Waiting for Node CRs to be deleted before release", "cloudID", cloudID, "nodes", pending)
+		return &ReleasePendingError{Reason: "waiting for Node CRs to be deleted", Nodes: pending}
+	}
+
+	return nil
+}
+
+// freeNodes removes the specified nodes from the allocations of a cloud. It must be called with the allocation lock
+// held.
+func (h *HwMgrService) freeNodes(ctx context.Context, cloudID string, nodenames []string) error {
+	if len(nodenames) == 0 {
+		return nil
+	}
+
+	cm, resources, allocations, err := h.GetCurrentResources(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	cloud := allocations.findCloud(cloudID)
+	if cloud == nil {
+		return nil
+	}
+
+	now := h.clock.Now()
+	released := 0
+	for groupname, nodes := range cloud.Nodegroups {
+		cloud.Nodegroups[groupname] = slices.DeleteFunc(nodes, func(nodename string) bool {
+			if !slices.Contains(nodenames, nodename) {
+				return false
+			}
+			released++
+
+			h.recordHistory(&allocations, historyActionReleased, cloudID, groupname, nodename)
+
+			if nodeinfo, exists := resources.Nodes[nodename]; exists {
+				utils.IncrementCounterAnnotation(cm, utils.ReleasedCountAnnotationPrefix+nodeinfo.HwProfile, 1)
+			}
+			if h.releaseCooldown > 0 {
+				if allocations.Released == nil {
+					allocations.Released = make(map[string]metav1.Time)
+				}
+				allocations.Released[nodename] = metav1.NewTime(now)
+			}
+			return true
+		})
+	}
+
+	if err := h.updateAllocations(ctx, cm, allocations); err != nil {
+		return err
+	}
+	nodesReleased.Add(float64(released))
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+)
+
+// NodePoolValidationStatus classifies a NodePool in a ValidationReport
+type NodePoolValidationStatus string
+
+const (
+	// NodePoolSatisfiable indicates that the NodePool is, or can be, fully allocated from the inventory
+	NodePoolSatisfiable NodePoolValidationStatus = "Satisfiable"
+
+	// NodePoolUnsatisfiable indicates that there are too few free nodes to complete the allocation of the NodePool
+	NodePoolUnsatisfiable NodePoolValidationStatus = "Unsatisfiable"
+
+	// NodePoolOverAllocated indicates that more nodes are allocated to a nodegroup of the NodePool than it requests
+	NodePoolOverAllocated NodePoolValidationStatus = "OverAllocated"
+
+	// NodePoolMissingProfile indicates that a nodegroup of the NodePool requests a hardware profile that is not in the
+	// inventory
+	NodePoolMissingProfile NodePoolValidationStatus = "MissingProfile"
+)
+
+// NodePoolValidation is the result of validating a single NodePool against the inventory
+type NodePoolValidation struct {
+	Name    string                   `json:"name"`
+	CloudID string                   `json:"cloudID"`
+	Status  NodePoolValidationStatus `json:"status"`
+	Message string                   `json:"message,omitempty"`
+}
+
+// ValidationReport is the result of validating all NodePools against the inventory
+type ValidationReport struct {
+	ResourceVersion string               `json:"resourceVersion"`
+	NodePools       []NodePoolValidation `json:"nodepools"`
+}
+
+// ValidateNodePools validates every NodePool against the current inventory and allocations, as an administrative
+// check. Each NodePool is validated on its own, so pools that compete for the same free nodes may each be reported as
+// satisfiable.
+func (h *HwMgrService) ValidateNodePools(ctx context.Context) (*ValidationReport, error) {
+	cm, resources, allocations, err := h.GetCurrentResources(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	nodepools := &hwmgmtv1alpha1.NodePoolList{}
+	if err := h.Client.List(ctx, nodepools, client.InNamespace(h.namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list nodepools: %w", err)
+	}
+
+	report := &ValidationReport{ResourceVersion: cm.ResourceVersion}
+	for i := range nodepools.Items {
+		nodepool := &nodepools.Items[i]
+		result := h.validateNodePoolInventory(resources, allocations, nodepool)
+		result.Name = nodepool.Name
+		result.CloudID = nodepool.Spec.CloudID
+		report.NodePools = append(report.NodePools, result)
+	}
+
+	slices.SortFunc(report.NodePools, func(a, b NodePoolValidation) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return report, nil
+}
+
+// validateNodePoolInventory classifies a NodePool against the inventory, reporting missing profiles and
+// over-allocations before checking whether its allocation can be completed
+func (h *HwMgrService) validateNodePoolInventory(resources cmResources, allocations cmAllocations,
+	nodepool *hwmgmtv1alpha1.NodePool) NodePoolValidation {
+	for _, nodegroup := range nodepool.Spec.NodeGroup {
+		if !slices.Contains(resources.HwProfiles, nodegroup.HwProfile) {
+			return NodePoolValidation{
+				Status:  NodePoolMissingProfile,
+				Message: fmt.Sprintf("nodegroup %s requests unknown hardware profile %q", nodegroup.Name, nodegroup.HwProfile),
+			}
+		}
+	}
+
+	for _, cloud := range allocations.Clouds {
+		if cloud.CloudID != nodepool.Spec.CloudID {
+			continue
+		}
+		for _, nodegroup := range nodepool.Spec.NodeGroup {
+			if acceleratorTarget(nodepool, nodegroup) > 0 {
+				continue
+			}
+			if allocated := len(cloud.Nodegroups[nodegroup.Name]); allocated > nodegroup.Size {
+				return NodePoolValidation{
+					Status: NodePoolOverAllocated,
+					Message: fmt.Sprintf("nodegroup %s has %d node(s) allocated, but requests %d",
+						nodegroup.Name, allocated, nodegroup.Size),
+				}
+			}
+		}
+	}
+
+	if err := h.validateNodePool(resources, allocations, nodepool); err != nil {
+		return NodePoolValidation{Status: NodePoolUnsatisfiable, Message: err.Error()}
+	}
+
+	return NodePoolValidation{Status: NodePoolSatisfiable}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openshift-kni/oran-hwmgr-plugin-test/internal/controller/utils"
+	hwmgmtv1alpha1 "github.com/openshift-kni/oran-o2ims/api/hardwaremanagement/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ParseWarmPoolSizes parses a comma-separated list of profile=count pairs (e.g. "profile-a=2,profile-b=1") into the
+// number of warm nodes to maintain for each hardware profile
+func ParseWarmPoolSizes(value string) (map[string]int, error) {
+	sizes := make(map[string]int)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		profname, count, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid warm pool size %q: expected profile=count", item)
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid warm pool size %q: count must be a non-negative integer", item)
+		}
+		sizes[strings.TrimSpace(profname)] = size
+	}
+
+	return sizes, nil
+}
+
+// warmFirst reorders the free nodes so that those in the warm pool come first, preserving their order otherwise
+func warmFirst(freenodes, warm []string) []string {
+	ordered := make([]string, 0, len(freenodes))
+	for _, nodename := range freenodes {
+		if slices.Contains(warm, nodename) {
+			ordered = append(ordered, nodename)
+		}
+	}
+	for _, nodename := range freenodes {
+		if !slices.Contains(warm, nodename) {
+			ordered = append(ordered, nodename)
+		}
+	}
+	return ordered
+}
+
+// claimWarmNode assigns the Node CR of a warm node to a nodegroup, as it is allocated, annotating it with the reasons
+// for which the node was selected
+func (h *HwMgrService) claimWarmNode(ctx context.Context, cloudID, nodename, groupname string,
+	selection NodeSelection) error {
+	node := &hwmgmtv1alpha1.Node{}
+	if err := h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
+		return fmt.Errorf("failed to get Node: %w", err)
+	}
+
+	node.Spec.NodePool = cloudID
+	node.Spec.GroupName = groupname
+	delete(node.Annotations, utils.WarmAnnotation)
+	annotateSelection(node, selection)
+
+	if err := h.Client.Update(ctx, node); err != nil {
+		return fmt.Errorf("failed to update Node %s: %w", nodename, err)
+	}
+
+	return nil
+}
+
+// removeWarmNode removes a node from the warm pool, deleting its bmc-secret
+func (h *HwMgrService) removeWarmNode(ctx context.Context, nodename string) error {
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	if err := h.retryOnConflict(ctx, func() error {
+		cm, _, allocations, err := h.GetCurrentResources(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to get current resources: %w", err)
+		}
+
+		allocations.Warm = slices.DeleteFunc(allocations.Warm, func(warmnode string) bool { return warmnode == nodename })
+		return h.updateAllocations(ctx, cm, allocations)
+	}, "nodename", nodename); err != nil {
+		return err
+	}
+
+	if err := h.DeleteBMCSecret(ctx, nodename); err != nil {
+		return fmt.Errorf("failed to delete bmc-secret for %s: %w", nodename, err)
+	}
+
+	return nil
+}
+
+// ReplenishWarmPool pre-provisions free nodes until each hardware profile has the specified number of warm nodes,
+// creating their bmc-secrets and Node CRs ahead of allocation. Warm nodes complete their provisioning once the
+// allocation delay has elapsed, so that a NodePool drawing from the warm pool does not wait for it.
+func (h *HwMgrService) ReplenishWarmPool(ctx context.Context, sizes map[string]int) error {
+	h.allocationLock.Lock()
+	defer h.allocationLock.Unlock()
+
+	// Nodes already added to the warm pool are counted on a retry, so only the remaining nodes are added again
+	return h.retryOnConflict(ctx, func() error {
+		return h.replenishWarmPoolOnce(ctx, sizes)
+	})
+}
+
+// replenishWarmPoolOnce makes a single attempt to replenish the warm pools from the current allocations. It must be
+// called with the allocation lock held.
+func (h *HwMgrService) replenishWarmPoolOnce(ctx context.Context, sizes map[string]int) error {
+	cm, resources, allocations, err := h.GetCurrentResources(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get current resources: %w", err)
+	}
+
+	profiles := make([]string, 0, len(sizes))
+	for profname := range sizes {
+		profiles = append(profiles, profname)
+	}
+	sort.Strings(profiles)
+
+	for _, profname := range profiles {
+		current := 0
+		for _, nodename := range allocations.Warm {
+			if resources.Nodes[nodename].HwProfile == profname {
+				current++
+			}
+		}
+
+		freenodes := slices.DeleteFunc(getFreeNodesInProfile(resources, allocations, profname, h.inventoryFilters(allocations)...),
+			func(nodename string) bool { return slices.Contains(allocations.Warm, nodename) })
+		needed := sizes[profname] - current
+		if needed > len(freenodes) {
+			h.logger.WarnContext(ctx, "not enough free nodes to fill the warm pool",
+				"hwprofile", profname, "needed", needed, "free", len(freenodes))
+			needed = len(freenodes)
+		}
+
+		for _, nodename := range freenodes[:max(needed, 0)] {
+			h.logger.InfoContext(ctx, "Adding node to warm pool", "hwprofile", profname, "nodename", nodename)
+
+			nodeinfo := resources.Nodes[nodename]
+			if err := h.createNodeBMCSecret(ctx, nodename, nodeinfo.BMC); err != nil {
+				return fmt.Errorf("failed to create bmc-secret for warm node %s: %w", nodename, err)
+			}
+
+			allocations.Warm = append(allocations.Warm, nodename)
+			if err := h.updateAllocations(ctx, cm, allocations); err != nil {
+				return err
+			}
+
+			node := h.newNode("", nodename, "", profname)
+			applyInventoryLabels(node, nodeinfo.Labels)
+			node.Annotations[utils.WarmAnnotation] = "true"
+			if err := h.Client.Create(ctx, node); err != nil {
+				return fmt.Errorf("failed to create warm node (%s): %w", nodename, err)
+			}
+		}
+	}
+
+	return h.provisionWarmNodes(ctx, resources, allocations)
+}
+
+// provisionWarmNodes completes the provisioning of the warm nodes whose provisioning delay has elapsed
+func (h *HwMgrService) provisionWarmNodes(ctx context.Context, resources cmResources, allocations cmAllocations) error {
+	now := h.clock.Now()
+	for _, nodename := range allocations.Warm {
+		node := &hwmgmtv1alpha1.Node{}
+		if err := h.Client.Get(ctx, types.NamespacedName{Name: h.nodeName(nodename), Namespace: h.namespace}, node); err != nil {
+			return fmt.Errorf("failed to get warm Node %s: %w", nodename, err)
+		}
+
+		if meta.IsStatusConditionTrue(node.Status.Conditions, string(hwmgmtv1alpha1.Provisioned)) {
+			continue
+		}
+
+		if allocatedAt, err := time.Parse(time.RFC3339, node.Annotations[utils.AllocatedAtAnnotation]); err == nil &&
+			now.Before(allocatedAt.Add(h.provisionDelay(resources.Nodes[nodename]))) {
+			continue
+		}
+
+		if err := h.provisionNode(ctx, nodename, resources.Nodes[nodename]); err != nil {
+			return fmt.Errorf("failed to provision warm node (%s): %w", nodename, err)
+		}
+	}
+
+	return nil
+}
+
+// WarmPoolManager periodically replenishes the warm pools as a manager Runnable
+type WarmPoolManager struct {
+	HwMgr *HwMgrService
+
+	// Sizes is the number of warm nodes to maintain for each hardware profile
+	Sizes map[string]int
+
+	// Interval is the period between replenishments of the warm pools
+	Interval time.Duration
+}
+
+// Start replenishes the warm pools at each interval until the context is cancelled
+func (m *WarmPoolManager) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := m.HwMgr.ReplenishWarmPool(ctx, m.Sizes); err != nil {
+			m.HwMgr.logger.ErrorContext(ctx, "failed to replenish warm pool", "error", err)
+		}
+	}, m.Interval)
+
+	return nil
+}
+
+// NeedLeaderElection restricts the warm pools to be managed by the leader, as it updates the allocations
+func (m *WarmPoolManager) NeedLeaderElection() bool {
+	return true
+}